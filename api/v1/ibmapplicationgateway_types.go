@@ -24,11 +24,159 @@ type IBMApplicationGatewaySpec struct {
 	// +optional
 	Replicas int32 `json:"replicas"`
 
-	// Specification of the desired behavior of the Deployment.
+	// The name of the IBMApplicationGatewayClass which supplies the defaults
+	// for the deployment and the initial configuration sources.  If not
+	// specified the class which is marked as the default class, if any, is
+	// used.
+	// +optional
+	ClassName string `json:"className,omitempty"`
+
+	// Specification of the desired behavior of the Deployment.  Any field
+	// which is not specified is taken from the IBMApplicationGatewayClass.
+	// +optional
 	Deployment IBMApplicationGatewayDeployment `json:"deployment"`
 
 	// The configuration information associated with the deployed container.
+	// The configuration sources of the IBMApplicationGatewayClass are merged
+	// before these sources.
+	// +optional
 	Configuration []IBMApplicationGatewayConfiguration `json:"configuration"`
+
+	// Whether a provenance report is added to the generated configuration
+	// map.  The report maps the JSON pointer of each value in the merged
+	// configuration to the configuration source which last set the value.
+	// Defaults to false.
+	// +optional
+	ConfigurationProvenance bool `json:"configurationProvenance,omitempty"`
+
+	// Whether the rollout of changes is paused.  While paused the merged
+	// configuration, and the differences from the deployed configuration,
+	// are previewed in the status and the generated configuration map, the
+	// deployment, the service and the ingress are not changed.  Defaults to
+	// false.
+	// +optional
+	Paused bool `json:"paused,omitempty"`
+
+	// The number of old generated configuration revisions which are
+	// retained so that the gateway can be rolled back to them.  Defaults to
+	// 10.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
+
+	// The generated configuration revision which is deployed, instead of the
+	// configuration which is merged from the configuration sources.  This
+	// can be used to roll back to a known good configuration without
+	// reverting the configuration sources.  The revision must be one of the
+	// revisions which are reported in the status.  If not specified, or 0,
+	// the merged configuration is deployed.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	ConfigurationRevision int64 `json:"configurationRevision,omitempty"`
+
+	// The service which is used to expose the deployed containers.  If no
+	// service is specified, and no ingress is specified, a service will not
+	// be created.
+	// +optional
+	Service *IBMApplicationGatewayService `json:"service,omitempty"`
+
+	// The ingress, or OpenShift route, which is used to expose the service
+	// outside of the cluster.  A default service will be created if an
+	// ingress is specified without a service.
+	// +optional
+	Ingress *IBMApplicationGatewayIngress `json:"ingress,omitempty"`
+}
+
+type IBMApplicationGatewayService struct {
+	// The name of the service.  Defaults to the name of the custom resource.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// The type of the service.
+	// One of ClusterIP, NodePort, LoadBalancer.
+	// +kubebuilder:validation:Enum=ClusterIP;NodePort;LoadBalancer
+	// +kubebuilder:default=ClusterIP
+	// +optional
+	Type corev1.ServiceType `json:"type,omitempty"`
+
+	// The list of ports which are exposed by the service.  If no ports are
+	// specified the 8443 port of the container is exposed as port 8443.
+	// +optional
+	Ports []IBMApplicationGatewayServicePort `json:"ports,omitempty"`
+
+	// The annotations to add to the service.
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type IBMApplicationGatewayServicePort struct {
+	// The name of the port.  Required if more than one port is specified.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// The port which is exposed by the service.
+	Port int32 `json:"port"`
+
+	// The port of the container which is targeted by the service.
+	// Defaults to 8443.
+	// +optional
+	TargetPort int32 `json:"targetPort,omitempty"`
+
+	// The port on each node on which the service is exposed.  Used when the
+	// type is NodePort or LoadBalancer.  If not specified a port will be
+	// allocated by Kubernetes.
+	// +optional
+	NodePort int32 `json:"nodePort,omitempty"`
+}
+
+type IBMApplicationGatewayIngress struct {
+	// The kind of resource which is created.  Valid types are either
+	// ingress or route.  A route may only be used in an OpenShift
+	// environment.
+	// +kubebuilder:validation:Enum=ingress;route
+	// +kubebuilder:default=ingress
+	// +optional
+	Type string `json:"type,omitempty"`
+
+	// The name of the ingress or route.  Defaults to the name of the custom
+	// resource.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// The host name which is used to access the gateway.  If no host is
+	// specified for a route a host will be generated by OpenShift.
+	// +optional
+	Host string `json:"host,omitempty"`
+
+	// The path which is routed to the gateway.  Defaults to /.
+	// +optional
+	Path string `json:"path,omitempty"`
+
+	// The name of the service port which is targeted.  Defaults to the
+	// first port of the service.
+	// +optional
+	ServicePort string `json:"servicePort,omitempty"`
+
+	// The name of the IngressClass which is used.  Used when the type is
+	// ingress.
+	// +optional
+	IngressClassName string `json:"ingressClassName,omitempty"`
+
+	// The name of the secret which contains the TLS certificate and key for
+	// the host.  Used when the type is ingress.
+	// +optional
+	TLSSecret string `json:"tlsSecret,omitempty"`
+
+	// The TLS termination of the route.  One of passthrough, reencrypt,
+	// edge.  Used when the type is route.
+	// +kubebuilder:validation:Enum=passthrough;reencrypt;edge
+	// +kubebuilder:default=passthrough
+	// +optional
+	Termination string `json:"termination,omitempty"`
+
+	// The annotations to add to the ingress or route.
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
 }
 
 // Custom annotations to add to deployed IBM Appication Gateway container
@@ -41,8 +189,10 @@ type CustomAnnotation struct {
 
 type IBMApplicationGatewayDeployment struct {
 
-	// Docker image name.
+	// Docker image name.  Required unless it is supplied by the
+	// IBMApplicationGatewayClass.
 	// More info: https://kubernetes.io/docs/concepts/containers/images
+	// +optional
 	ImageLocation string `json:"image"`
 
 	// Image pull policy.
@@ -66,7 +216,7 @@ type IBMApplicationGatewayDeployment struct {
 	ServiceAccountName string `json:"serviceAccountName"`
 
 	// The language in which log messages from the container will be generated.
-	// +kubebuilder:default=C
+	// Defaults to C.
 	// +optional
 	Lang string `json:"lang"`
 
@@ -75,6 +225,15 @@ type IBMApplicationGatewayDeployment struct {
 	// +optional
 	ConfigMapSuffix string `json:"generatedConfigmapSuffix"`
 
+	// The type of object which the generated configuration is stored in.  A
+	// secret should be used if the configuration sources contain sensitive
+	// data, such as client secrets.  Defaults to secret if a secret or
+	// oidc_registration configuration source is specified, or configmap
+	// otherwise.
+	// +kubebuilder:validation:Enum=configmap;secret
+	// +optional
+	ConfigStorage string `json:"configStorage,omitempty"`
+
 	// Periodic probe of container service readiness.
 	// Container will be removed from service endpoints if the probe fails.
 	// Cannot be updated.
@@ -101,6 +260,64 @@ type IBMApplicationGatewayDeployment struct {
 	// +patchMergeKey=key
 	// +patchStrategy=merge,
 	CustomAnnotations []CustomAnnotation `json:"customAnnotations,omitempty" patchStrategy:"merge" patchMergeKey:"key" protobuf:"bytes,5,opt,name=customAnnotations"`
+
+	// The set of labels to add to the pods being created.  The labels which
+	// are used by the operator to manage the pods cannot be overridden.
+	// +optional
+	PodLabels map[string]string `json:"podLabels,omitempty"`
+
+	// Compute resources required by the container.
+	// More info: https://kubernetes.io/docs/concepts/configuration/manage-resources-containers/
+	// +optional
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// NodeSelector is a selector which must be true for the pod to fit on a node.
+	// More info: https://kubernetes.io/docs/concepts/configuration/assign-pod-node/
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// The pod's tolerations.
+	// +optional
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+
+	// The pod's scheduling constraints.
+	// +optional
+	Affinity *corev1.Affinity `json:"affinity,omitempty"`
+
+	// TopologySpreadConstraints describes how the pods ought to spread across
+	// topology domains.
+	// +optional
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+
+	// If specified, indicates the pod's priority.
+	// +optional
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+
+	// The pod-level security attributes and common container settings.
+	// +optional
+	PodSecurityContext *corev1.PodSecurityContext `json:"podSecurityContext,omitempty"`
+
+	// The security options the container should be run with.
+	// More info: https://kubernetes.io/docs/tasks/configure-pod-container/security-context/
+	// +optional
+	SecurityContext *corev1.SecurityContext `json:"securityContext,omitempty"`
+
+	// Additional environment variables to set in the container.  The LANG
+	// variable is controlled by the lang field.
+	// +optional
+	Env []corev1.EnvVar `json:"env,omitempty"`
+
+	// Additional sources to populate environment variables in the container.
+	// +optional
+	EnvFrom []corev1.EnvFromSource `json:"envFrom,omitempty"`
+
+	// Additional volumes which can be mounted by the container.
+	// +optional
+	Volumes []corev1.Volume `json:"volumes,omitempty"`
+
+	// Additional volumes to mount into the container's filesystem.
+	// +optional
+	VolumeMounts []corev1.VolumeMount `json:"volumeMounts,omitempty"`
 }
 
 type IBMApplicationGatewayProbe struct {
@@ -140,18 +357,26 @@ type IBMApplicationGatewayProbe struct {
 
 type IBMApplicationGatewayConfiguration struct {
 	// The type of configuration data which is being provided.  Valid types
-	// include: configmap, oidc_registration, web, literal.
+	// include: configmap, secret, oidc_registration, web, literal.
 	Type string `json:"type"`
 
-	// The name of the configuration map to be used, when the type is configmap.
+	// The name of the configuration map, or secret, to be used, when the type
+	// is configmap or secret.
 	// +optional
 	Name string `json:"name"`
 
-	// The name of the ConfigMap key which contains the configuration data.
-	// Used when the type is configmap.
+	// The name of the ConfigMap, or Secret, key which contains the
+	// configuration data.  Used when the type is configmap or secret.
 	// +optional
 	DataKey string `json:"dataKey"`
 
+	// The namespace of the configuration map, if it is not in the namespace
+	// of the custom resource.  The configuration map must have been granted
+	// to the namespace of the custom resource by an
+	// IBMApplicationGatewayConfigGrant.  Used when the type is configmap.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
 	// The URL which is used to retrieve the configuration data.  Used when the
 	// type is web.
 	// +optional
@@ -162,10 +387,78 @@ type IBMApplicationGatewayConfiguration struct {
 	// +optional
 	Headers []IBMApplicationGatewayHeaders `json:"headers"`
 
+	// How often the configuration data is retrieved again from the URL, for
+	// example 5m or 1h.  The gateway is only reloaded if the merged
+	// configuration changes as a result.  If not specified the configuration
+	// data is only retrieved when the custom resource is reconciled.  Used
+	// when type is web.
+	// +optional
+	RefreshInterval *metav1.Duration `json:"refreshInterval,omitempty"`
+
+	// The name of a secret whose ca.crt key contains the PEM encoded CA
+	// certificates which are trusted when the configuration data is
+	// retrieved, in addition to the system CA certificates.  Used when type
+	// is web.
+	// +optional
+	CASecret string `json:"caSecret,omitempty"`
+
+	// The name of a config map whose ca.crt key contains the PEM encoded CA
+	// certificates which are trusted when the configuration data is
+	// retrieved, in addition to the system CA certificates.  Used when type
+	// is web.
+	// +optional
+	CAConfigMap string `json:"caConfigMap,omitempty"`
+
+	// The name of a kubernetes.io/tls secret which contains the client
+	// certificate and key which are presented when the configuration data is
+	// retrieved.  Used when type is web.
+	// +optional
+	ClientCertSecret string `json:"clientCertSecret,omitempty"`
+
+	// Whether the certificate of the server is not verified when the
+	// configuration data is retrieved.  This should only be used for testing.
+	// Used when type is web.
+	// +optional
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+
+	// The number of seconds after which the request to retrieve the
+	// configuration data times out.  Defaults to 20 seconds.  Used when type
+	// is web.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+
 	// The literal configuration data.  Used when type is literal.
 	// +optional
 	Value string `json:"value"`
 
+	// Whether the configuration data is a Go template, which is rendered
+	// before it is merged.  The template can refer to the metadata of the
+	// custom resource, the service host name, the keys of config maps and
+	// secrets and the environment of the gateway container.  Used when the
+	// type is configmap, literal or web.  A web template, or the template of
+	// a config map in another namespace, may not read a secret, and a web
+	// template may not read a config map.  When the type is
+	// oidc_registration the POST data values are rendered instead.
+	// +optional
+	Template bool `json:"template,omitempty"`
+
+	// How the arrays in the configuration data are merged with the arrays
+	// from the earlier configuration sources.  Valid strategies are merge,
+	// which adds the new elements after the existing elements, replace,
+	// which replaces the existing elements, and mergeByKey, which merges a
+	// new element with the existing element which has the same value for the
+	// mergeKey.  Defaults to merge.  Used when the type is configmap, secret,
+	// web or literal.
+	// +kubebuilder:validation:Enum=merge;replace;mergeByKey
+	// +optional
+	MergeStrategy string `json:"mergeStrategy,omitempty"`
+
+	// The name of the key, for example path or name, which is used to match
+	// array elements when the mergeStrategy is mergeByKey.
+	// +optional
+	MergeKey string `json:"mergeKey,omitempty"`
+
 	// The OIDC discovery endpoint.  Used when type is oidc_registration.
 	// +optional
 	DiscoveryEndpoint string `json:"discoveryEndpoint"`
@@ -214,16 +507,173 @@ type IBMApplicationGatewayPostData struct {
 	Values []string `json:"values"`
 }
 
+// The condition types which are reported in the status of an
+// IBMApplicationGateway.
+const (
+	// The gateway has been fully rolled out with the current configuration
+	// and all of the desired replicas are ready.
+	ConditionReady = "Ready"
+
+	// The configuration sources have been successfully merged into the
+	// generated configuration.
+	ConditionConfigMerged = "ConfigMerged"
+
+	// The OIDC client has been registered.  This condition is only reported
+	// when an oidc_registration configuration source has been specified.
+	ConditionOIDCRegistered = "OIDCRegistered"
+
+	// The owned deployment has the minimum number of available replicas.
+	ConditionDeploymentAvailable = "DeploymentAvailable"
+
+	// The last reconcile of the resource failed.
+	ConditionDegraded = "Degraded"
+
+	// The rollout of changes is paused and the merged configuration is
+	// being previewed.  This condition is only reported when the resource
+	// is paused.
+	ConditionPaused = "Paused"
+)
+
 // IBMApplicationGatewayStatus defines the observed state of IBMApplicationGateway
 type IBMApplicationGatewayStatus struct {
-	// A boolean which is used to signify whether the resource has been
-	// successfully created.
-	// +kubebuilder:default=true
-	Status bool `json:"status"`
+	// The generation of the custom resource which was most recently processed
+	// by the operator.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// The name of the ConfigMap, or Secret, which contains the generated
+	// configuration.
+	// +optional
+	ConfigMapName string `json:"configMapName,omitempty"`
+
+	// The version of the generated configuration which has been rolled out
+	// to all of the replicas.  The version is derived from the SHA-256 hash
+	// of the generated configuration.
+	// +optional
+	ConfigVersion string `json:"configVersion,omitempty"`
+
+	// The generated configuration revision which is deployed.
+	// +optional
+	ConfigRevision int64 `json:"configRevision,omitempty"`
+
+	// The generated configuration revisions which are retained, oldest
+	// first.
+	// +optional
+	ConfigRevisions []IBMApplicationGatewayConfigRevision `json:"configRevisions,omitempty"`
+
+	// The generated configuration revision which was most recently rolled
+	// out to all of the replicas and became ready.
+	// +optional
+	HealthyConfigRevision int64 `json:"healthyConfigRevision,omitempty"`
+
+	// The hash of the pod template, excluding the generated configuration,
+	// which was deployed along with the healthy configuration revision.
+	// +optional
+	HealthyPodTemplateHash string `json:"healthyPodTemplateHash,omitempty"`
+
+	// The config maps which were read by the configuration templates when
+	// the configuration sources were last merged.  The config maps in other
+	// namespaces are prefixed with their namespace.
+	// +optional
+	TemplateConfigMaps []string `json:"templateConfigMaps,omitempty"`
+
+	// The secrets which were read by the configuration templates when the
+	// configuration sources were last merged.
+	// +optional
+	TemplateSecrets []string `json:"templateSecrets,omitempty"`
+
+	// The generated configuration revision which failed to become ready and
+	// was automatically rolled back.
+	// +optional
+	FailedConfigRevision int64 `json:"failedConfigRevision,omitempty"`
+
+	// The hash of the generated configuration which failed to become ready.
+	// The merged configuration is not rolled out again until it changes.
+	// +optional
+	FailedConfigHash string `json:"failedConfigHash,omitempty"`
+
+	// The total number of replicas of the owned deployment.
+	// +optional
+	Replicas int32 `json:"replicas,omitempty"`
+
+	// The number of replicas of the owned deployment which are ready.
+	// +optional
+	ReadyReplicas int32 `json:"readyReplicas,omitempty"`
+
+	// The number of replicas of the owned deployment which are available.
+	// +optional
+	AvailableReplicas int32 `json:"availableReplicas,omitempty"`
+
+	// The preview of the merged configuration.  This is only reported when
+	// the resource is paused.
+	// +optional
+	Preview *IBMApplicationGatewayConfigPreview `json:"preview,omitempty"`
+
+	// The latest available observations of the state of the resource.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+// IBMApplicationGatewayConfigRevision describes a single generated
+// configuration revision.  Each revision is stored in an immutable ConfigMap.
+type IBMApplicationGatewayConfigRevision struct {
+	// The number of the revision.
+	Revision int64 `json:"revision"`
+
+	// The name of the ConfigMap, or Secret, which contains the generated
+	// configuration.
+	ConfigMapName string `json:"configMapName"`
+
+	// The type of object which contains the generated configuration, either
+	// configmap or secret.
+	// +optional
+	Storage string `json:"storage,omitempty"`
+
+	// The SHA-256 hash of the generated configuration.
+	Hash string `json:"hash"`
+
+	// When the revision was created.
+	// +optional
+	CreationTimestamp metav1.Time `json:"creationTimestamp,omitempty"`
+}
+
+// IBMApplicationGatewayConfigPreview contains the configuration which would be
+// generated from the specification of a paused IBMApplicationGateway.
+type IBMApplicationGatewayConfigPreview struct {
+	// The generation of the custom resource which was previewed.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// The merged configuration which would be generated, with the secret
+	// values redacted.  This is not set if the configuration is stored in a
+	// secret.
+	// +optional
+	Configuration string `json:"configuration,omitempty"`
+
+	// A unified diff from the deployed configuration to the merged
+	// configuration, with the secret values redacted.  Every value is
+	// redacted if the configuration is stored in a secret.  This is empty if
+	// the configuration would not change.
+	// +optional
+	Diff string `json:"diff,omitempty"`
+
+	// The reason the configuration could not be merged, if it could not be
+	// merged.
+	// +optional
+	Error string `json:"error,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Replicas",type=integer,JSONPath=`.status.readyReplicas`
+//+kubebuilder:printcolumn:name="Config",type=string,JSONPath=`.status.configMapName`,priority=1
+//+kubebuilder:printcolumn:name="Paused",type=boolean,JSONPath=`.spec.paused`,priority=1
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // IBMApplicationGateway is the Schema for the ibmapplicationgateways API
 type IBMApplicationGateway struct {
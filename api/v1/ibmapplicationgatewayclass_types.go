@@ -0,0 +1,57 @@
+/*
+ * Copyright contributors to the IBM Application Gateway Operator project
+ */
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The annotation which marks an IBMApplicationGatewayClass as the default
+// class.  The default class is used by the IBMApplicationGateway custom
+// resources, and the annotated deployments, which do not name a class.
+const DefaultClassAnnotation = "ibm-application-gateway.security.ibm.com/is-default-class"
+
+// IBMApplicationGatewayClassSpec defines the defaults which are shared by the
+// IBMApplicationGateway custom resources which use the class.
+type IBMApplicationGatewayClassSpec struct {
+	// The defaults for the deployment.  A field which is not specified by
+	// the custom resource is taken from the class.
+	// +optional
+	Deployment *IBMApplicationGatewayDeployment `json:"deployment,omitempty"`
+
+	// The configuration sources which are merged before the configuration
+	// sources of the custom resource.  A configuration source without a
+	// namespace is read from the namespace of the custom resource.
+	// +optional
+	Configuration []IBMApplicationGatewayConfiguration `json:"configuration,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Image",type=string,JSONPath=`.spec.deployment.image`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// IBMApplicationGatewayClass supplies the deployment defaults and the initial
+// configuration sources of the IBMApplicationGateway custom resources which
+// reference it.
+type IBMApplicationGatewayClass struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec IBMApplicationGatewayClassSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// IBMApplicationGatewayClassList contains a list of IBMApplicationGatewayClass
+type IBMApplicationGatewayClassList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []IBMApplicationGatewayClass `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&IBMApplicationGatewayClass{}, &IBMApplicationGatewayClassList{})
+}
@@ -0,0 +1,81 @@
+/*
+ * Copyright contributors to the IBM Application Gateway Operator project
+ */
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The namespace which grants access to every namespace.
+const ConfigGrantAllNamespaces = "*"
+
+// IBMApplicationGatewayConfigGrantSpec defines the configuration sources which
+// may be read by IBMApplicationGateway custom resources in other namespaces.
+type IBMApplicationGatewayConfigGrantSpec struct {
+	// The namespaces of the IBMApplicationGateway custom resources which are
+	// granted access to the configuration sources.
+	// +kubebuilder:validation:MinItems=1
+	From []IBMApplicationGatewayConfigGrantFrom `json:"from"`
+
+	// The configuration sources which may be read.
+	// +kubebuilder:validation:MinItems=1
+	To []IBMApplicationGatewayConfigGrantTo `json:"to"`
+}
+
+// IBMApplicationGatewayConfigGrantFrom identifies the namespace of the custom
+// resources which are granted access.
+type IBMApplicationGatewayConfigGrantFrom struct {
+	// The namespace of the custom resources.  A namespace of "*" grants
+	// access to the custom resources in every namespace.
+	// +kubebuilder:validation:MinLength=1
+	Namespace string `json:"namespace"`
+}
+
+// IBMApplicationGatewayConfigGrantTo identifies the configuration sources which
+// may be read.
+type IBMApplicationGatewayConfigGrantTo struct {
+	// The namespace which contains the configuration sources.
+	// +kubebuilder:validation:MinLength=1
+	Namespace string `json:"namespace"`
+
+	// The kind of the configuration sources.  Only ConfigMap is currently
+	// supported.
+	// +kubebuilder:validation:Enum=ConfigMap
+	// +kubebuilder:default=ConfigMap
+	// +optional
+	Kind string `json:"kind,omitempty"`
+
+	// The name of the configuration source.  If not specified, all of the
+	// configuration sources of the kind in the namespace may be read.
+	// +optional
+	Name string `json:"name,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// IBMApplicationGatewayConfigGrant allows the IBMApplicationGateway custom
+// resources in the listed namespaces to read configuration sources from
+// another namespace.
+type IBMApplicationGatewayConfigGrant struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec IBMApplicationGatewayConfigGrantSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// IBMApplicationGatewayConfigGrantList contains a list of IBMApplicationGatewayConfigGrant
+type IBMApplicationGatewayConfigGrantList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []IBMApplicationGatewayConfigGrant `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&IBMApplicationGatewayConfigGrant{}, &IBMApplicationGatewayConfigGrantList{})
+}
@@ -0,0 +1,230 @@
+/*
+ * Copyright contributors to the IBM Application Gateway Operator project
+ */
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	ibmv1 "github.com/ibm-security/ibm-application-gateway-operator/api/v1"
+)
+
+//+kubebuilder:rbac:groups=ibm.com,resources=ibmapplicationgatewayclasses,verbs=get;list;watch
+
+/*
+ * Function returns true if the passed in class is marked as the default class.
+ */
+func isDefaultGatewayClass(class *ibmv1.IBMApplicationGatewayClass) bool {
+	isDefault, _ := strconv.ParseBool(class.Annotations[ibmv1.DefaultClassAnnotation])
+
+	return isDefault
+}
+
+/*
+ * Function returns the class with the passed in name from the list of classes.
+ * If no name is passed in the default class is returned, or nil if there is
+ * no default class.
+ */
+func findGatewayClass(classes []ibmv1.IBMApplicationGatewayClass, name string) (*ibmv1.IBMApplicationGatewayClass, error) {
+	if name != "" {
+		for i := range classes {
+			if classes[i].Name == name {
+				return &classes[i], nil
+			}
+		}
+
+		return nil, fmt.Errorf("The IBMApplicationGatewayClass %s does not exist.", name)
+	}
+
+	var defaultClass *ibmv1.IBMApplicationGatewayClass
+	for i := range classes {
+		if !isDefaultGatewayClass(&classes[i]) {
+			continue
+		}
+
+		if defaultClass != nil {
+			return nil, fmt.Errorf("More than one IBMApplicationGatewayClass is marked as the default class : %s, %s.",
+				defaultClass.Name, classes[i].Name)
+		}
+		defaultClass = &classes[i]
+	}
+
+	return defaultClass, nil
+}
+
+/*
+ * Function returns the class with the passed in name, or the default class if
+ * no name is passed in.  Nil is returned if no name is passed in and there is
+ * no default class.  The configuration sources of the class are validated, as
+ * the class is not validated when it is created.
+ */
+func getGatewayClass(rclient client.Reader, name string) (*ibmv1.IBMApplicationGatewayClass, error) {
+	classes := &ibmv1.IBMApplicationGatewayClassList{}
+	if err := rclient.List(context.TODO(), classes); err != nil {
+		log.Error(err, "Failed to list the IBMApplicationGatewayClasses.")
+		return nil, err
+	}
+
+	class, err := findGatewayClass(classes.Items, name)
+	if err != nil || class == nil {
+		return nil, err
+	}
+
+	allErrs := validateConfiguration(class.Spec.Configuration, field.NewPath("spec", "configuration"))
+	if len(allErrs) > 0 {
+		return nil, errors.NewInvalid(ibmv1.GroupVersion.WithKind("IBMApplicationGatewayClass").GroupKind(),
+			class.Name, allErrs)
+	}
+
+	return class, nil
+}
+
+/*
+ * Function sets each field of the deployment which has not been specified to
+ * the value of the same field of the passed in defaults.  A field which has
+ * been specified is not merged with the default value.
+ */
+func applyDeploymentDefaults(depl *ibmv1.IBMApplicationGatewayDeployment,
+	defaults *ibmv1.IBMApplicationGatewayDeployment) {
+
+	if defaults == nil {
+		return
+	}
+
+	value := reflect.ValueOf(depl).Elem()
+	defaultValue := reflect.ValueOf(defaults.DeepCopy()).Elem()
+
+	for i := 0; i < value.NumField(); i++ {
+		if value.Field(i).IsZero() {
+			value.Field(i).Set(defaultValue.Field(i))
+		}
+	}
+}
+
+/*
+ * Function returns the passed in configuration sources, prepended with the
+ * configuration sources of the class.
+ */
+func getClassConfiguration(class *ibmv1.IBMApplicationGatewayClass,
+	entries []ibmv1.IBMApplicationGatewayConfiguration) []ibmv1.IBMApplicationGatewayConfiguration {
+
+	if class == nil || len(class.Spec.Configuration) == 0 {
+		return entries
+	}
+
+	merged := make([]ibmv1.IBMApplicationGatewayConfiguration, 0, len(class.Spec.Configuration)+len(entries))
+	for i := range class.Spec.Configuration {
+		merged = append(merged, *class.Spec.Configuration[i].DeepCopy())
+	}
+
+	return append(merged, entries...)
+}
+
+/*
+ * Function applies the class of the custom resource to the custom resource.
+ * The deployment defaults and the configuration sources of the class are
+ * only applied to the in-memory copy of the custom resource, and must never
+ * be written back to the API server.
+ */
+func applyGatewayClass(r *IBMApplicationGatewayReconciler, instance *ibmv1.IBMApplicationGateway) error {
+	class, err := getGatewayClass(r.Client, instance.Spec.ClassName)
+	if err != nil || class == nil {
+		return err
+	}
+
+	applyDeploymentDefaults(&instance.Spec.Deployment, class.Spec.Deployment)
+
+	instance.Spec.Configuration = getClassConfiguration(class, instance.Spec.Configuration)
+
+	return nil
+}
+
+/*
+ * Function returns a reconcile request for each custom resource which uses the
+ * changed class.  A custom resource which does not name a class is always
+ * reconciled, as the change may have changed the default class.
+ */
+func (r *IBMApplicationGatewayReconciler) findGatewaysForClass(ctx context.Context,
+	obj client.Object) []reconcile.Request {
+
+	instanceList := &ibmv1.IBMApplicationGatewayList{}
+	if err := r.Client.List(ctx, instanceList); err != nil {
+		log.Error(err, "Failed to find the custom objects which use the class : "+obj.GetName())
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, inst := range instanceList.Items {
+		if inst.Spec.ClassName == "" || inst.Spec.ClassName == obj.GetName() {
+			log.V(1).Info("Handle class change : " + obj.GetName() + " -> " + inst.Namespace + "/" + inst.Name)
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: inst.Name, Namespace: inst.Namespace},
+			})
+		}
+	}
+
+	return requests
+}
+
+/*
+ * Function returns a reconcile request for each custom resource whose class
+ * has a configuration source which matches the passed in filter.  The filter
+ * is called with the configuration sources of the class and the custom
+ * resource which uses it.  Nothing is returned if no class has any
+ * configuration sources.
+ */
+func (r *IBMApplicationGatewayReconciler) findGatewaysForClassSources(ctx context.Context,
+	filter func([]ibmv1.IBMApplicationGatewayConfiguration, *ibmv1.IBMApplicationGateway) bool) []reconcile.Request {
+
+	classList := &ibmv1.IBMApplicationGatewayClassList{}
+	if err := r.Client.List(ctx, classList); err != nil {
+		log.Error(err, "Failed to list the IBMApplicationGatewayClasses.")
+		return nil
+	}
+
+	hasSources := false
+	for _, class := range classList.Items {
+		if len(class.Spec.Configuration) > 0 {
+			hasSources = true
+			break
+		}
+	}
+
+	if !hasSources {
+		return nil
+	}
+
+	instanceList := &ibmv1.IBMApplicationGatewayList{}
+	if err := r.Client.List(ctx, instanceList); err != nil {
+		log.Error(err, "Failed to list the custom objects.")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for i := range instanceList.Items {
+		inst := &instanceList.Items[i]
+
+		class, err := findGatewayClass(classList.Items, inst.Spec.ClassName)
+		if err != nil || class == nil {
+			continue
+		}
+
+		if filter(class.Spec.Configuration, inst) {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: inst.Name, Namespace: inst.Namespace},
+			})
+		}
+	}
+
+	return requests
+}
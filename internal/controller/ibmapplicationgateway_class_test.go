@@ -0,0 +1,250 @@
+/*
+ * Copyright contributors to the IBM Application Gateway Operator project
+ */
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	corev1 "k8s.io/api/core/v1"
+
+	ibmv1 "github.com/ibm-security/ibm-application-gateway-operator/api/v1"
+)
+
+/*
+ * Function returns a class which supplies an image, a probe and a base
+ * configuration source.
+ */
+func newTestGatewayClass(name string, isDefault bool) *ibmv1.IBMApplicationGatewayClass {
+	class := &ibmv1.IBMApplicationGatewayClass{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: ibmv1.IBMApplicationGatewayClassSpec{
+			Deployment: &ibmv1.IBMApplicationGatewayDeployment{
+				ImageLocation:    "icr.io/ibmappgateway/ibm-application-gateway:24.12",
+				ImagePullPolicy:  "Always",
+				ImagePullSecrets: []corev1.LocalObjectReference{{Name: "pull-secret"}},
+				Lang:             "en_US.utf8",
+				ReadinessProbe:   ibmv1.IBMApplicationGatewayProbe{Command: "/sbin/health_check.sh", Period: 5},
+				Env:              []corev1.EnvVar{{Name: "LOG_LEVEL", Value: "info"}},
+			},
+			Configuration: []ibmv1.IBMApplicationGatewayConfiguration{
+				{Type: "literal", Value: "version: \"24.12\"\n"},
+			},
+		},
+	}
+
+	if isDefault {
+		class.Annotations = map[string]string{ibmv1.DefaultClassAnnotation: "true"}
+	}
+
+	return class
+}
+
+func TestFindGatewayClass(t *testing.T) {
+	classes := []ibmv1.IBMApplicationGatewayClass{
+		*newTestGatewayClass("standard", true),
+		*newTestGatewayClass("hardened", false),
+	}
+
+	tests := []struct {
+		name      string
+		classes   []ibmv1.IBMApplicationGatewayClass
+		className string
+		expected  string
+		expectErr bool
+	}{
+		{name: "a named class", classes: classes, className: "hardened", expected: "hardened"},
+		{name: "the default class", classes: classes, expected: "standard"},
+		{name: "a missing class", classes: classes, className: "missing", expectErr: true},
+		{name: "no default class", classes: classes[1:]},
+		{name: "more than one default class", classes: append([]ibmv1.IBMApplicationGatewayClass{
+			*newTestGatewayClass("other", true)}, classes...), expectErr: true},
+	}
+
+	for _, test := range tests {
+		class, err := findGatewayClass(test.classes, test.className)
+		if test.expectErr != (err != nil) {
+			t.Errorf("%s: unexpected result %v", test.name, err)
+			continue
+		}
+
+		name := ""
+		if class != nil {
+			name = class.Name
+		}
+		if name != test.expected {
+			t.Errorf("%s: expected the class %q but got %q", test.name, test.expected, name)
+		}
+	}
+}
+
+func TestApplyGatewayClass(t *testing.T) {
+	instance := &ibmv1.IBMApplicationGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "iag-instance", Namespace: "default"},
+		Spec: ibmv1.IBMApplicationGatewaySpec{
+			Deployment: ibmv1.IBMApplicationGatewayDeployment{
+				Lang: "C",
+				Env:  []corev1.EnvVar{{Name: "APP_PORT", Value: "8080"}},
+			},
+			Configuration: []ibmv1.IBMApplicationGatewayConfiguration{
+				{Type: "oidc_registration", Secret: "oidc-client"},
+			},
+		},
+	}
+
+	class := newTestGatewayClass("standard", true)
+
+	r := &IBMApplicationGatewayReconciler{Client: newTestClient(t, instance, class)}
+
+	if err := applyGatewayClass(r, instance); err != nil {
+		t.Fatal(err)
+	}
+
+	depl := instance.Spec.Deployment
+
+	// The unspecified fields are taken from the class
+	if depl.ImageLocation != class.Spec.Deployment.ImageLocation || depl.ImagePullPolicy != "Always" ||
+		!reflect.DeepEqual(depl.ReadinessProbe, class.Spec.Deployment.ReadinessProbe) {
+		t.Errorf("The class defaults were not applied : %+v", depl)
+	}
+
+	// The specified fields are not merged with the class
+	if depl.Lang != "C" || !reflect.DeepEqual(depl.Env, []corev1.EnvVar{{Name: "APP_PORT", Value: "8080"}}) {
+		t.Errorf("The custom resource fields were overridden : %+v", depl)
+	}
+
+	// The class configuration sources are merged first
+	if len(instance.Spec.Configuration) != 2 || instance.Spec.Configuration[0].Type != "literal" ||
+		instance.Spec.Configuration[1].Type != "oidc_registration" {
+		t.Errorf("Unexpected configuration sources : %+v", instance.Spec.Configuration)
+	}
+
+	// The class itself is not changed
+	depl.ImagePullSecrets[0].Name = "changed"
+	if class.Spec.Deployment.ImagePullSecrets[0].Name != "pull-secret" {
+		t.Errorf("The class was changed through the custom resource")
+	}
+
+	// The finalizer of the class OIDC registration is written back, but the
+	// class defaults are not
+	if err := ensureFinalizer(r, instance); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := &ibmv1.IBMApplicationGateway{}
+	if err := r.Client.Get(context.TODO(), types.NamespacedName{Name: "iag-instance", Namespace: "default"},
+		stored); err != nil {
+		t.Fatal(err)
+	}
+
+	if !controllerutil.ContainsFinalizer(stored, oidcFinalizer) {
+		t.Errorf("The finalizer was not added")
+	}
+	if stored.Spec.Deployment.ImageLocation != "" || len(stored.Spec.Configuration) != 1 {
+		t.Errorf("The class defaults were written back : %+v", stored.Spec)
+	}
+	if instance.ResourceVersion != stored.ResourceVersion || len(instance.Spec.Configuration) != 2 {
+		t.Errorf("The in-memory custom resource was not kept")
+	}
+}
+
+func TestApplyInvalidGatewayClass(t *testing.T) {
+	class := newTestGatewayClass("standard", false)
+	class.Spec.Configuration = append(class.Spec.Configuration,
+		ibmv1.IBMApplicationGatewayConfiguration{Type: "unknown"})
+
+	instance := &ibmv1.IBMApplicationGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "iag-instance", Namespace: "default"},
+		Spec:       ibmv1.IBMApplicationGatewaySpec{ClassName: "standard"},
+	}
+
+	r := &IBMApplicationGatewayReconciler{Client: newTestClient(t, class)}
+
+	if err := applyGatewayClass(r, instance); err == nil {
+		t.Errorf("Expected an error for the invalid class")
+	}
+}
+
+func TestValidateGatewayWithClass(t *testing.T) {
+	class := newTestGatewayClass("standard", false)
+	class.Spec.Configuration = append(class.Spec.Configuration,
+		ibmv1.IBMApplicationGatewayConfiguration{Type: "oidc_registration", Secret: "class-client"})
+
+	instance := &ibmv1.IBMApplicationGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "iag-instance", Namespace: "default"},
+		Spec: ibmv1.IBMApplicationGatewaySpec{
+			ClassName: "standard",
+			Configuration: []ibmv1.IBMApplicationGatewayConfiguration{
+				{Type: "oidc_registration", Secret: "instance-client"},
+			},
+		},
+	}
+
+	v := &IBMApplicationGatewayValidator{Client: newTestClient(t, class)}
+
+	warnings, err := v.ValidateCreate(context.TODO(), instance)
+	if len(warnings) != 0 {
+		t.Errorf("Unexpected warnings : %v", warnings)
+	}
+	if err == nil || !strings.Contains(err.Error(), "spec.configuration[0]") ||
+		!strings.Contains(err.Error(), "IBMApplicationGatewayClass[standard].spec.configuration[1]") {
+		t.Errorf("Expected an error for the second oidc_registration source but got %v", err)
+	}
+
+	// A class which cannot be found only results in a warning.
+	instance.Spec.ClassName = "missing"
+
+	warnings, err = v.ValidateCreate(context.TODO(), instance)
+	if len(warnings) != 1 || err != nil {
+		t.Errorf("Expected a single warning but got %v : %v", warnings, err)
+	}
+}
+
+func TestGetClassAnnotations(t *testing.T) {
+	rclient := newTestClient(t, newTestGatewayClass("standard", true), newTestGatewayClass("hardened", false))
+
+	annots := map[string]string{
+		imagePullPolicyAnnot:        "Never",
+		envPrefix + "APP_PORT":      "8080",
+		confPrefix + "version.type": "literal",
+	}
+
+	classAnnots, class, err := getClassAnnotations(rclient, annots)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if class == nil || class.Name != "standard" {
+		t.Fatalf("Expected the default class but got %v", class)
+	}
+
+	expected := map[string]string{
+		imageAnnot:                  "icr.io/ibmappgateway/ibm-application-gateway:24.12",
+		imagePullPolicyAnnot:        "Never",
+		envPrefix + "APP_PORT":      "8080",
+		envPrefix + "LOG_LEVEL":     "info",
+		confPrefix + "version.type": "literal",
+	}
+	if !reflect.DeepEqual(classAnnots, expected) {
+		t.Errorf("Expected %v but got %v", expected, classAnnots)
+	}
+
+	// The annotations of the target resource are not changed
+	if _, ok := annots[imageAnnot]; ok {
+		t.Errorf("The annotations of the target resource were changed")
+	}
+
+	annots[classAnnot] = "missing"
+	if _, _, err := getClassAnnotations(rclient, annots); err == nil {
+		t.Errorf("Expected an error for a missing class")
+	}
+}
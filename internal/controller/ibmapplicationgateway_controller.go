@@ -10,26 +10,33 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	goerrors "errors"
 	"fmt"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
 	"net/http"
-	"reflect"
+	"strconv"
 	"strings"
 	"time"
 
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
 	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/structured-merge-diff/v4/fieldpath"
 
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -67,8 +74,10 @@ type AccessTokenStruct struct {
 }
 
 type ClientDataStruct struct {
-	Client_id     string
-	Client_secret string
+	Client_id                 string
+	Client_secret             string
+	Registration_client_uri   string
+	Registration_access_token string
 }
 
 const (
@@ -78,6 +87,18 @@ const (
 	langLabelKey          = "ibm-application-gateway.operator.security.ibm.com/lang"
 )
 
+// The file which contains the service CA certificate of the service account,
+// which is trusted when the operator makes a request.
+const serviceCAFile = "/var/run/secrets/kubernetes.io/serviceaccount/service-ca.crt"
+
+// The change cause annotation which is used to record the reason for a change
+// in the revision history of the deployment.
+const changeCauseAnnotationKey = "kubernetes.io/change-cause"
+
+// The field manager which is used when applying the objects which are owned by
+// the operator.
+var fieldOwner = client.FieldOwner("ibm-application-gateway-operator")
+
 // Logger
 var log = logf.Log.WithName("controller_ibmapplicationgateway")
 
@@ -89,7 +110,10 @@ type IBMApplicationGatewayReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
 	record.EventRecorder
-	Leader string
+
+	// An optional reader which reads directly from the API server, rather
+	// than from the cache.
+	APIReader client.Reader
 }
 
 //+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
@@ -113,776 +137,789 @@ func (r *IBMApplicationGatewayReconciler) Reconcile(ctx context.Context, request
 	err := r.Client.Get(context.TODO(), request.NamespacedName, instance)
 
 	if err != nil {
-
-		// It was not an IBMApplicationGateway object change
-		// so check to see if it was a change to a config map
-		// that an IBMApplicationGateway object is referencing
-		configMap := &corev1.ConfigMap{}
-		err = r.Client.Get(context.TODO(), request.NamespacedName, configMap)
-
-		if err == nil {
-			// Its a config map, check to see if we need to do anything
-			err = handleConfigMapChange(r, instance, request)
-			if err != nil {
-				reqLogger.Error(err, "Failed to update custom objects for config map change.")
-				return ctrl.Result{}, err
-			}
-
+		if errors.IsNotFound(err) {
+			// Request object not found, could have been deleted after reconcile request.
+			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
+			// Forget any cached web configuration and don't requeue
+			forgetWebSources(request.NamespacedName)
 			return ctrl.Result{}, nil
-		} else {
-
-			if errors.IsNotFound(err) {
-				// Request object not found, could have been deleted after reconcile request.
-				// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
-				// Return and don't requeue
-				return ctrl.Result{}, nil
+		}
+		// Error reading the object - requeue the request.
+		return ctrl.Result{}, err
+	} else if !instance.DeletionTimestamp.IsZero() {
+		// The custom resource is being deleted so clean up anything which
+		// will not be garbage collected.  The secret of the OIDC registration
+		// is recorded when the finalizer is added, so the class, which may
+		// have been changed or deleted since, is only needed if the secret
+		// was not recorded.  The class must not prevent the deletion.
+		if instance.Annotations[oidcSecretAnnotationKey] == "" {
+			if err := applyGatewayClass(r, instance); err != nil {
+				reqLogger.Error(err, "Failed to apply the IBMApplicationGatewayClass.")
 			}
-			// Error reading the object - requeue the request.
-			return ctrl.Result{}, err
 		}
+		return finalizeGateway(r, instance)
 	} else {
 		reqLogger.Info("Reconciling IBMApplicationGateway")
 
+		// Apply the deployment defaults and configuration sources of the
+		// class before anything else uses the specification
+		err = applyGatewayClass(r, instance)
+		if err == nil && instance.Spec.Deployment.ImageLocation == "" {
+			err = fmt.Errorf("No IBM Application Gateway image has been specified by the custom resource or its class.")
+		}
+		if err != nil {
+			return manageError(r, instance, err)
+		}
+
+		// Make sure the finalizer is present if it is required
+		err = ensureFinalizer(r, instance)
+		if err != nil {
+			return manageError(r, instance, err)
+		}
+
 		// Its an IBMApplicationGateway object that has changed
 		// First check to see if the deployment exists for this custom resource
 		dply := &appsv1.Deployment{}
 		errD := r.Client.Get(context.TODO(), request.NamespacedName, dply)
+		if errD != nil && !errors.IsNotFound(errD) {
+			// Error reading the deployment - requeue the request.
+			return ctrl.Result{}, errD
+		}
+
+		// Changes to a paused custom resource are only previewed
+		if instance.Spec.Paused {
+			return previewGateway(r, instance, request, dply)
+		}
+
+		clearPreview(instance)
+
+		// Roll back a configuration revision which has failed to become ready
+		if errD == nil {
+			checkConfigRollout(r, instance, dply)
+		}
 
 		// Get the current config map version (update if necessary)
 		cmVersion := ""
 		cmName := ""
-		cmName, cmVersion, err = createNewConfigMap(r, instance, request, dply)
+		cmStorage := ""
+		cmName, cmVersion, cmStorage, err = createNewConfigMap(r, instance, request, dply)
 		if err != nil || cmVersion == "" {
+			if err == nil {
+				err = fmt.Errorf("The generated config map does not have a version.")
+			}
 			reqLogger.Error(err, "Failed to handle the config map.")
+
+			// The existing configuration remains in use if the merged
+			// configuration is not valid
+			reason := reasonConfigMergeFailed
+			var validationErr *configValidationError
+			if goerrors.As(err, &validationErr) {
+				reason = reasonConfigInvalid
+			}
+
+			setCondition(instance, ibmv1.ConditionConfigMerged, metav1.ConditionFalse,
+				reason, err.Error())
 			return manageError(r, instance, err)
 		}
 
-		// If the deplyment did not exist then create it
-		if errD != nil {
-			if errors.IsNotFound(errD) {
+		// The condition is set when the configuration sources are merged,
+		// as the merged configuration may not have been validated
+		if instance.Spec.ConfigurationRevision > 0 {
+			setCondition(instance, ibmv1.ConditionConfigMerged, metav1.ConditionTrue, reasonConfigRevisionPinned,
+				fmt.Sprintf("The configuration is pinned to revision %d.", instance.Spec.ConfigurationRevision))
+		}
 
-				// Need to create it
-				reqLogger.Info("Creating a new deployment.")
-				_, errD = createNewDeployment(r, instance, request, cmVersion, cmName)
-				if errD != nil {
-					reqLogger.Error(errD, "Failed to create the new deployment.")
-					return manageError(r, instance, errD)
-				}
+		// Make sure the service and ingress which expose the deployment
+		// are up to date
+		err = reconcileService(r, instance)
+		if err != nil {
+			return manageError(r, instance, err)
+		}
 
-				return ctrl.Result{}, nil
-			}
-		} else {
+		err = reconcileIngress(r, instance)
+		if err != nil {
+			return manageError(r, instance, err)
+		}
 
-			// Deployment exists
-			// Next check to make sure the replica count is correct
-			if instance.Spec.Replicas != *dply.Spec.Replicas {
-				dply.Spec.Replicas = &instance.Spec.Replicas
+		// Hash the referenced secrets so that the pods are reloaded if any of
+		// the secrets change
+		secretHash, err := getSecretHash(r, instance)
+		if err != nil {
+			reqLogger.Error(err, "Failed to hash the referenced secrets.")
+			return manageError(r, instance, err)
+		}
 
-				reqLogger.Info("Updating deployment replica count.")
-				err := r.Client.Update(context.TODO(), dply)
-				if err != nil {
-					reqLogger.Error(err, "Failed to update the deployment.")
-					return manageError(r, instance, err)
-				}
+		// Rebuild the deployment from the custom resource and apply it.  This
+		// will create the deployment if it does not exist, roll out any
+		// changes and revert any changes made directly to the deployment.
+		err = applyDeployment(r, instance, dply, errD == nil, cmVersion, cmName, cmStorage, secretHash)
+		if err != nil {
+			reqLogger.Error(err, "Failed to apply the deployment.")
+			return manageError(r, instance, err)
+		}
 
-				// Update was successful
-				return ctrl.Result{}, nil
-			} else {
+		// If any web configuration sources are to be refreshed make sure that
+		// the custom resource is reconciled again when the next refresh is due
+		refreshInterval := getRefreshInterval(instance)
 
-				// Replicas are correct so check other deployment options are up to date
-				updateReq := false
-				changeCause := ""
+		// The status of the deployment may have changed, even if the
+		// deployment has not
+		if err := updateStatus(r, instance, dply, cmName); err != nil {
+			return ctrl.Result{}, err
+		}
 
-				// Config version
-				if dply.Spec.Template.Labels[configVersionLabelKey] != cmVersion {
-					updateReq = true
-					changeCause = "Configuration change"
-					reqLogger.Info(changeCause)
-				}
+		// The owned objects and the referenced objects are watched, so the
+		// custom resource only needs to be reconciled again when the next
+		// refresh is due
+		return ctrl.Result{RequeueAfter: refreshInterval}, nil
+	}
+}
 
-				// Language
-				if dply.Spec.Template.Labels[langLabelKey] != instance.Spec.Deployment.Lang {
-					updateReq = true
-					if changeCause == "" {
-						changeCause = fmt.Sprintf("Language changed from %s to %s", dply.Spec.Template.Labels[langLabelKey], instance.Spec.Deployment.Lang)
-					} else {
-						changeCause = changeCause + ", language change"
-					}
-					reqLogger.Info(changeCause)
-				}
+/*
+ * Function reads the configured config locations from the custom object yaml and sequentially
+ * merges each of them to produce a single configuration string in YAML format.  The provenance
+ * report of the merged configuration is also returned if it has been requested.  If the
+ * configuration is only being previewed the OIDC client is not registered.
+ */
+func getMergedConfig(r *IBMApplicationGatewayReconciler, instance *ibmv1.IBMApplicationGateway, request ctrl.Request,
+	preview bool) (string, configProvenance, error) {
+	reqLogger := log.WithValues("Request.Namespace", request.Namespace, "Request.Name", request.Name)
+	reqLogger.Info("Merging IBMApplicationGateway config")
 
-				// Service account
-				if dply.Spec.Template.Spec.ServiceAccountName != instance.Spec.Deployment.ServiceAccountName {
-					updateReq = true
-					if changeCause == "" {
-						changeCause = fmt.Sprintf("Service account changed from %s to %s", dply.Spec.Template.Spec.ServiceAccountName, instance.Spec.Deployment.ServiceAccountName)
-					} else {
-						changeCause = changeCause + ", service account change"
-					}
-					reqLogger.Info(changeCause)
-				}
+	// The provenance report is only recorded if it has been requested, or
+	// if it is needed to redact the secret values from the preview
+	var provenance configProvenance
+	if instance.Spec.ConfigurationProvenance || preview {
+		provenance = make(configProvenance)
+	}
 
-				// Image location
-				if dply.Spec.Template.Spec.Containers[0].Image != instance.Spec.Deployment.ImageLocation {
-					updateReq = true
-					if changeCause == "" {
-						changeCause = fmt.Sprintf("Image changed from %s to %s", dply.Spec.Template.Spec.Containers[0].Image, instance.Spec.Deployment.ImageLocation)
-					} else {
-						changeCause = changeCause + ", image change"
-					}
-					reqLogger.Info(changeCause)
-				}
+	ctx := &sourceContext{
+		client:  r.Client,
+		owner:   request.NamespacedName,
+		preview: preview,
+		values:  getTemplateValues(instance),
+	}
 
-				// Make the changes and update if required
-				if updateReq == true {
+	master, err := mergeConfigurationSources(ctx, instance.Spec.Configuration, nil, provenance)
 
-					reqLogger.Info("Updating deployment due to " + changeCause)
+	// Forget the data of any web configuration source which has been removed
+	pruneWebSources(request.NamespacedName, instance.Spec.Configuration)
 
-					// Set the new values in the deployment spec
-					dply.Spec.Template.Labels[configVersionLabelKey] = cmVersion
-					dply.Spec.Template.Labels[langLabelKey] = instance.Spec.Deployment.Lang
-					dply.Spec.Template.Spec.ServiceAccountName = instance.Spec.Deployment.ServiceAccountName
-					dply.Spec.Template.Spec.Containers[0].Image = instance.Spec.Deployment.ImageLocation
+	// The OIDC condition is only reported if a registration has been requested
+	if getOidcEntry(instance) == nil {
+		meta.RemoveStatusCondition(&instance.Status.Conditions, ibmv1.ConditionOIDCRegistered)
+	}
 
-					// Set the new env
-					dply.Spec.Template.Spec.Containers[0].Env = []corev1.EnvVar{
-						{
-							Name:  "LANG",
-							Value: instance.Spec.Deployment.Lang,
-						},
-					}
+	if err != nil {
+		var sourceErr *configurationSourceError
+		if goerrors.As(err, &sourceErr) && sourceErr.sourceType == "oidc_registration" {
+			reqLogger.Error(err, "Error encountered while attempting to register a new OIDC client.")
+			setCondition(instance, ibmv1.ConditionOIDCRegistered, metav1.ConditionFalse,
+				reasonOidcRegistrationFail, err.Error())
+		}
+		return "", nil, err
+	}
 
-					// Update the revision history with the reason for this change
-					dply.Annotations["kubernetes.io/change-cause"] = changeCause
+	if !preview && getOidcEntry(instance) != nil {
+		setCondition(instance, ibmv1.ConditionOIDCRegistered, metav1.ConditionTrue,
+			reasonOidcRegistered, "The OIDC client has been registered.")
+	}
 
-					// Update the deployment
-					err := r.Client.Update(context.TODO(), dply)
-					if err != nil {
-						reqLogger.Error(err, "Failed to update the deployment.")
-						return manageError(r, instance, err)
-					}
+	// Remember the objects which were read by the templates, which may
+	// only be known once the data of a source has been fetched, so that
+	// they are watched along with the objects named by the sources
+	if !preview {
+		instance.Status.TemplateConfigMaps = getReferenceNames(ctx.references, sourceReferenceConfigMap,
+			instance.Namespace)
+		instance.Status.TemplateSecrets = getReferenceNames(ctx.references, sourceReferenceSecret,
+			instance.Namespace)
+	}
 
-					// Update was successful
-					return ctrl.Result{}, nil
-				}
-			}
+	// Make sure that the merged configuration is valid before it is rolled out
+	warning, err := validateMergedConfig(master)
+	if err != nil {
+		reqLogger.Error(err, "The merged configuration is not valid.")
+		return "", nil, err
+	}
+
+	if !preview {
+		if warning != "" {
+			reqLogger.Info(warning)
+			setCondition(instance, ibmv1.ConditionConfigMerged, metav1.ConditionTrue,
+				reasonConfigNotValidated, warning)
+		} else {
+			setCondition(instance, ibmv1.ConditionConfigMerged, metav1.ConditionTrue,
+				reasonConfigMerged, "The configuration sources have been merged.")
 		}
 	}
 
-	return ctrl.Result{Requeue: true}, nil
+	// Marshal the object to a yaml byte array
+	masterYaml, err := yaml.Marshal(master)
+	if err != nil {
+		reqLogger.Error(err, "failed to marshal the YAML master configuration.")
+		return "", nil, err
+	}
+
+	// Return the string representation of the merged config
+	return string(masterYaml), provenance, nil
 }
 
 /*
- * Function will handle a config map change. It will loop through all the deployed IAG custom objects
- * to see if they reference the modified config map. If so then a noop update will be made to that
- * object which will in turn fire a reconcile change for that object. This will result in the configuration
- * being checked and possibly updating the IAG pods if required.
+ * Handle dynamic client registration for the passed in OIDC registration.
  */
-func handleConfigMapChange(r *IBMApplicationGatewayReconciler, instance *ibmv1.IBMApplicationGateway, request ctrl.Request) error {
-	reqLogger := log.WithValues("Request.Namespace", request.Namespace, "Request.Name", request.Name)
+func registerOidcEntry(rclient client.Client, entry IAGOidcReg, ns string) error {
 
-	// To help improve performance we ignore our operator 'leader' configmap as
-	// this is updated frequently and we know that it does not contain any
-	// IAG configuration data.
-	if request.Name == r.Leader {
-		return nil
-	}
-
-	// Fetch the instances
-	instanceList := &ibmv1.IBMApplicationGatewayList{}
-	err := r.Client.List(context.TODO(),
-		instanceList,
-		&client.ListOptions{
-			Namespace: request.Namespace,
-		})
-
-	// Update (touch) any IBMApplicationGateway custom objects that use the changed config map
-	for _, inst := range instanceList.Items {
-		for _, entry := range inst.Spec.Configuration {
-			if entry.Type == "configmap" {
-				mapName := entry.Name
-
-				if mapName == request.Name {
-					reqLogger.Info("Handle configmap change : updating")
-
-					iagNamespaceName := request.NamespacedName
-					iagNamespaceName.Name = inst.Name
+	logger := log.WithName("registerOidcEntry")
+	logger.Info("Entry")
 
-					err = r.Client.Get(context.TODO(), iagNamespaceName, instance)
-					if err != nil {
-						return err
-					}
+	// Secret is mandatory
+	if entry.Secret == "" {
+		return fmt.Errorf("The OIDC registration configuration source is missing the secret name.")
+	}
 
-					err = r.Client.Status().Update(context.TODO(), instance)
-					if err != nil {
-						return err
-					}
-				}
-			}
-		}
+	// Register the client (if necessary)
+	err := handleOidcRegistration(&entry, rclient, ns)
+	if err != nil {
+		logger.Error(err, "Failed to handle the OIDC registration.")
+		return err
 	}
 
+	logger.Info("Exit")
+
 	return nil
 }
 
 /*
- * Function reads the configured config locations from the custom object yaml and sequentially
- * merges each of them to produce a single configuration string in YAML format.
+ * Function returns the OIDC identity settings for the registered client, to be merged into the
+ * master config.
  */
-func getMergedConfig(r *IBMApplicationGatewayReconciler, instance *ibmv1.IBMApplicationGateway, request ctrl.Request) (string, error) {
-	reqLogger := log.WithValues("Request.Namespace", request.Namespace, "Request.Name", request.Name)
-	reqLogger.Info("Merging IBMApplicationGateway config")
-
-	master := make(map[string]interface{})
-	var err error
-
-	var oidcRegs []ibmv1.IBMApplicationGatewayConfiguration
+func getOidcIdentity(entry IAGOidcReg) map[string]interface{} {
+	clientIdStr := "secret:" + entry.Secret + "/client_id"
+	clientSecretStr := "secret:" + entry.Secret + "/client_secret"
 
-	for _, entry := range instance.Spec.Configuration {
+	// If the identity/oidc YAML already exists the discoveryURL and client
+	// id/secret are updated:
+	// identity:
+	//   oidc:
+	//     discovery_endpoint: <discovery_url>
+	//     client_id: secret:<secret>/client_id
+	//     client_secret: secret:<secret>/client_secret
+	return map[string]interface{}{
+		"identity": map[string]interface{}{
+			"oidc": map[string]interface{}{
+				"discovery_endpoint": entry.DiscoveryEndpoint,
+				"client_id":          clientIdStr,
+				"client_secret":      clientSecretStr,
+			},
+		},
+	}
+}
 
-		if entry.Type == "literal" {
-			litConfig := entry.Value
+/**
+ * This function will handle the conversion of new config data to a yaml map
+ * and then merge that map with the existing master config, using the passed
+ * in merge options.
+ */
+func handleYamlDataMerge(newConfig string, masterConfig map[string]interface{},
+	opts mergeOptions) (map[string]interface{}, error) {
 
-			master, err = handleYamlDataMerge(litConfig, master)
-			if err != nil {
-				return "", err
-			}
+	currentYaml, err := parseConfigTree(newConfig)
+	if err != nil {
+		return nil, err
+	}
 
-		} else if entry.Type == "configmap" {
-			cmName := entry.Name
-			cmDataKey := entry.DataKey
+	return mergeConfigTree(currentYaml, masterConfig, opts), nil
+}
 
-			if cmName == "" {
-				return "", fmt.Errorf("Configuration configmap entry is missing the Name.")
-			}
-			if cmDataKey == "" {
-				return "", fmt.Errorf("Configuration configmap entry is missing the DataKey.")
-			}
+/*
+ * Function merges a normalised configuration tree with the master config and
+ * records the source of the merged values, if a provenance report is being
+ * recorded.
+ */
+func mergeConfigTree(currentYaml map[string]interface{}, masterConfig map[string]interface{},
+	opts mergeOptions) map[string]interface{} {
 
-			// Fetch the config map
-			configMapFound := &corev1.ConfigMap{}
-			err := r.Client.Get(context.TODO(), types.NamespacedName{Name: cmName, Namespace: instance.Namespace}, configMapFound)
-			if err != nil {
-				return "", err
-			}
+	// The master config is merged in place, so the values which were set
+	// before the merge need to be saved first
+	before := opts.provenance.getLeaves(masterConfig, getProvenanceListKey(opts))
 
-			// Get the config map data pointed at by the data key
-			cmData := configMapFound.Data[cmDataKey]
+	var merged map[string]interface{}
 
-			master, err = handleYamlDataMerge(cmData, master)
-			if err != nil {
-				return "", err
-			}
-		} else if entry.Type == "web" {
+	// A directive at the top level applies to the whole master config
+	switch getPatchDirective(currentYaml) {
+	case patchReplace:
+		merged = removePatchDirectives(currentYaml, opts)
+	case patchDelete:
+		merged = make(map[string]interface{})
+	default:
+		// Merge the current config map with the master config map
+		merged = mergeMapsRecursive(masterConfig, currentYaml, opts)
+	}
 
-			webUrl := entry.Url
-			headers := entry.Headers
+	opts.provenance.record(opts, before, merged, currentYaml)
 
-			var iagHeaders []IAGHeader
+	return merged
+}
 
-			for _, header := range headers {
-				var currHdr IAGHeader
-				currHdr.Name = header.Name
-				currHdr.Type = header.Type
-				currHdr.Value = header.Value
-				currHdr.SecretKey = header.SecretKey
+/*
+ * Function returns the name of the generated config map, or secret, which should be deployed,
+ * along with the version of its content and the type of object.
+ * The configuration sources are merged and a new configuration revision is created if the
+ * merged configuration has changed, unless the configuration has been pinned to a revision.
+ */
+func createNewConfigMap(r *IBMApplicationGatewayReconciler, instance *ibmv1.IBMApplicationGateway, request ctrl.Request, depl *appsv1.Deployment) (string, string, string, error) {
+	reqLogger := log.WithValues("Request.Namespace", request.Namespace, "Request.Name", request.Name)
 
-				iagHeaders = append(iagHeaders, currHdr)
-			}
+	var data map[string]string
 
-			master, err = handleWebEntryMerge(r.Client, request.NamespacedName, webUrl, iagHeaders, master)
-			if err != nil {
-				reqLogger.Error(err, "Error encountered while attempting to merge the web config.")
-				return "", err
-			}
-		} else if entry.Type == "oidc_registration" {
-			oidcRegs = append(oidcRegs, entry)
+	// A pinned revision is deployed as is, so there is nothing to merge
+	if instance.Spec.ConfigurationRevision == 0 {
+		newData, provenance, err := getMergedConfig(r, instance, request, false)
+		if err != nil {
+			reqLogger.Error(err, "Failed to get merged config.")
+			return "", "", "", err
+		}
 
-			// Validate that there is no more than one OIDC registration
-			if len(oidcRegs) > 1 {
-				return "", fmt.Errorf("Only a single oidc_registration configuration source may be specified.")
-			}
+		provenanceData, err := provenance.toJson()
+		if err != nil {
+			reqLogger.Error(err, "Failed to marshal the provenance report.")
+			return "", "", "", err
 		}
-	}
 
-	// Handle the OIDC registration if one exists
-	for _, entry := range oidcRegs {
+		data = newConfigMap(instance, newData, provenanceData).Data
+	}
 
-		// Make sure its the correct type
-		if entry.Type == "oidc_registration" {
+	configMap, err := reconcileConfigRevision(r, instance, data, depl.Spec.Template.Labels[configMapLabelKey])
+	if err != nil {
+		return "", "", "", err
+	}
 
-			// Convert to the required struct
-			var oidcReg IAGOidcReg
-			oidcReg.DiscoveryEndpoint = entry.DiscoveryEndpoint
-			oidcReg.Secret = entry.Secret
+	return configMap.GetName(), getConfigMapVersion(configMap), getObjectConfigStorage(configMap), nil
+}
 
-			// Add Post data to the new struct
-			var postData []IAGPostData
-			for _, elem := range entry.PostData {
-				var currPd IAGPostData
-				currPd.Name = elem.Name
-				currPd.Value = elem.Value
-				currPd.Values = elem.Values
+/*
+ * Function retrieves the current deployed IAG merged config map, or secret
+ */
+func getCurrentConfigMap(r *IBMApplicationGatewayReconciler, instance *ibmv1.IBMApplicationGateway, depl *appsv1.Deployment) (client.Object, error) {
 
-				postData = append(postData, currPd)
-			}
-			oidcReg.PostData = postData
+	configMapName := depl.Spec.Template.Labels[configMapLabelKey]
 
-			// Handle the registration and merge
-			master, err = handleOidcEntryMerge(r.Client, oidcReg, instance.Namespace, master)
-			if err != nil {
-				reqLogger.Error(err, "Error encountered while attempting to register a new OIDC client.")
-				return "", err
-			}
+	if configMapName == "" {
+		return nil, nil
+	}
 
-			// Make sure only 1 registered
-			break
-		}
+	// Get the ConfigMap, or the Secret
+	var foundMap client.Object = &corev1.ConfigMap{}
+	if getDeployedConfigStorage(depl) == configStorageSecret {
+		foundMap = &corev1.Secret{}
 	}
 
-	// Marshal the object to a yaml byte array
-	masterYaml, err := yaml.Marshal(validateStringKeysFromString(master))
+	err := r.Client.Get(context.TODO(), types.NamespacedName{Name: configMapName, Namespace: instance.Namespace}, foundMap)
 	if err != nil {
-		reqLogger.Error(err, "failed to marshal the YAML master configuration.")
-		return "", err
+		return nil, err
 	}
 
-	// Return the string representation of the merged config
-	return string(masterYaml), nil
+	return foundMap, nil
 }
 
 /*
- * Handle dynamic client registration and merge OIDC identity into the current master config.
+ * Function applies the deployment which is generated from the custom resource
+ * using server-side apply.  A dry run of the apply is used to determine whether
+ * anything would change, and if the pod template changes the reason is
+ * recorded in the revision history.  Any containers, environment variables or
+ * annotations which the operator no longer manages, but which would be kept
+ * by the apply, are removed first.  The passed in deployment is updated with
+ * the result.
  */
-func handleOidcEntryMerge(rclient client.Client, entry IAGOidcReg,
-	ns string, master map[string]interface{}) (map[string]interface{}, error) {
+func applyDeployment(r *IBMApplicationGatewayReconciler, instance *ibmv1.IBMApplicationGateway,
+	dply *appsv1.Deployment, exists bool, cmVersion string, cmName string, cmStorage string,
+	secretHash string) error {
+	reqLogger := log.WithValues("Request.Namespace", instance.Namespace, "Request.Name", instance.Name)
 
-	logger := log.WithName("handleOidcEntryMerge")
-	logger.Info("Entry")
+	desired := newDeploymentForCR(instance, cmVersion, cmName, cmStorage)
 
-	// Secret is mandatory
-	if entry.Secret == "" {
-		return master, fmt.Errorf("The OIDC registration configuration source is missing the secret name.")
+	if instance.Status.ConfigRevision > 0 {
+		desired.Spec.Template.Labels[configRevisionLabelKey] = strconv.FormatInt(instance.Status.ConfigRevision, 10)
 	}
 
-	// Register the client (if necessary)
-	err := handleOidcRegistration(&entry, rclient, ns)
-	if err != nil {
-		logger.Error(err, "Failed to handle the OIDC registration.")
-		return master, err
+	if secretHash != "" {
+		desired.Spec.Template.Annotations[secretHashAnnotationKey] = secretHash
 	}
 
-	// Now that the client has been registered, add the oidc identity settings.
-	clientIdStr := "secret:" + entry.Secret + "/client_id"
-	clientSecretStr := "secret:" + entry.Secret + "/client_secret"
+	setPodTemplateHash(desired)
 
-	// If the identity/oidc YAML already exists then update the discoveryURL and client id/secret
-	oidcUpdated := false
-	if master["identity"] != nil {
+	if exists {
+		// Carry the current change cause forward, otherwise it would be
+		// removed by the apply
+		if changeCause, ok := dply.Annotations[changeCauseAnnotationKey]; ok {
+			desired.Annotations[changeCauseAnnotationKey] = changeCause
+		}
 
-		// Make sure the type is correct
-		switch v := master["identity"].(type) {
-		case map[interface{}]interface{}:
-			masterIdentity := convertInterfaceKeysToString(v)
-			if masterIdentity["oidc"] != nil {
+		// The apply does not remove a field which the operator no longer
+		// manages if another field manager also manages it
+		err := removeStaleFields(r, dply, desired)
+		if err != nil {
+			return err
+		}
 
-				// Make sure the type is correct
-				switch v2 := masterIdentity["oidc"].(type) {
-				case map[interface{}]interface{}:
-					masterOidc := convertInterfaceKeysToString(v2)
+		// Determine what the deployment would look like after the apply
+		dryRun := desired.DeepCopy()
+		err = r.Client.Patch(context.TODO(), dryRun, client.Apply, fieldOwner, client.ForceOwnership, client.DryRunAll)
+		if err != nil {
+			return err
+		}
 
-					// Update the values
-					masterOidc["discovery_endpoint"] = entry.DiscoveryEndpoint
-					masterOidc["client_id"] = clientIdStr
-					masterOidc["client_secret"] = clientSecretStr
+		if equality.Semantic.DeepEqual(dply.Spec, dryRun.Spec) &&
+			equality.Semantic.DeepEqual(dply.Labels, dryRun.Labels) &&
+			equality.Semantic.DeepEqual(dply.Annotations, dryRun.Annotations) &&
+			equality.Semantic.DeepEqual(dply.OwnerReferences, dryRun.OwnerReferences) {
+			// Nothing else to do
+			return nil
+		}
 
-					// Set the master maps
-					masterIdentity["oidc"] = masterOidc
-					master["identity"] = masterIdentity
+		// Update the revision history with the reason for a pod template change
+		if !equality.Semantic.DeepEqual(dply.Spec.Template, dryRun.Spec.Template) {
+			changeCause := getChangeCause(dply, dryRun)
+			desired.Annotations[changeCauseAnnotationKey] = changeCause
 
-					// Flag as handled
-					oidcUpdated = true
-				}
-			}
+			reqLogger.Info("Updating deployment due to " + changeCause)
+		} else {
+			reqLogger.Info("Updating deployment.")
 		}
+	} else {
+		reqLogger.Info("Creating a new deployment.")
+	}
+
+	err := r.Client.Patch(context.TODO(), desired, client.Apply, fieldOwner, client.ForceOwnership)
+	if err != nil {
+		return err
 	}
 
-	// If its not already handled, add the OIDC identity
-	if !oidcUpdated {
-		// Need to make:
-		// identity:
-		//   oidc:
-		//     discovery_endpoint: <discovery_url>
-		//     client_id: secret:<secret>/client_id
-		//     client_secret: secret:<secret>/client_secret
+	desired.DeepCopyInto(dply)
 
-		var oidcStr = "identity:\n" +
-			"  oidc:\n" +
-			"    discovery_endpoint: " + entry.DiscoveryEndpoint + "\n" +
-			"    client_id: " + clientIdStr + "\n" +
-			"    client_secret: " + clientSecretStr
+	return nil
+}
 
-		master, err = handleYamlDataMerge(oidcStr, master)
-		if err != nil {
-			return master, err
+/*
+ * Function returns the fields of the passed in deployment which are managed
+ * by the operator, according to the managed fields of the deployment.
+ */
+func getOperatorManagedFields(dply *appsv1.Deployment) *fieldpath.Set {
+	managed := &fieldpath.Set{}
+
+	for _, entry := range dply.ManagedFields {
+		if entry.Manager != string(fieldOwner) || entry.FieldsV1 == nil {
+			continue
 		}
-	}
 
-	logger.Info("Exit")
+		fields := &fieldpath.Set{}
+		if err := fields.FromJSON(bytes.NewReader(entry.FieldsV1.Raw)); err != nil {
+			log.Error(err, "Failed to parse the managed fields of the deployment : "+dply.Name)
+			continue
+		}
 
-	return master, nil
+		managed = managed.Union(fields)
+	}
+
+	return managed
 }
 
 /*
- * Merge a web config source into the current master config.
+ * Function returns a strategic merge patch which removes the annotations,
+ * containers and environment variables of the current deployment which the
+ * operator has previously managed, but which are not in the desired
+ * deployment.  Server-side apply removes such a field itself, unless another
+ * field manager also manages it, for example because the field was edited
+ * directly.  The fields which the operator has never managed are left alone.
+ * Returns nil if there is nothing to remove.
  */
-func handleWebEntryMerge(rclient client.Client, nsn types.NamespacedName,
-	webUrl string, headers []IAGHeader, master map[string]interface{}) (map[string]interface{}, error) {
+func getStaleFieldsPatch(current *appsv1.Deployment, desired *appsv1.Deployment) map[string]interface{} {
+	managed := getOperatorManagedFields(current)
 
-	if webUrl == "" {
-		return nil, fmt.Errorf("Configuration web entry is missing the Url.")
-	}
+	staleAnnotations := func(currAnnots map[string]string, desiredAnnots map[string]string,
+		path ...interface{}) map[string]interface{} {
 
-	log.V(1).Info("Retrieving config from " + webUrl)
+		stale := make(map[string]interface{})
+		for key := range currAnnots {
+			if _, ok := desiredAnnots[key]; !ok && managed.Has(fieldpath.MakePathOrDie(append(path, key)...)) {
+				stale[key] = nil
+			}
+		}
 
-	// Get the yaml from the given url
-	client := &http.Client{}
+		return stale
+	}
 
-	req, err := http.NewRequest("GET", webUrl, nil)
+	patch := make(map[string]interface{})
 
-	// Add the headers if there are any
-	for _, header := range headers {
+	if stale := staleAnnotations(current.Annotations, desired.Annotations,
+		"metadata", "annotations"); len(stale) > 0 {
+		patch["metadata"] = map[string]interface{}{"annotations": stale}
+	}
 
-		if header.Name == "" {
-			return nil, fmt.Errorf("Configuration web header entry is missing the required name.")
-		}
-		if header.Value == "" {
-			return nil, fmt.Errorf("Configuration web header entry is missing the required value.")
-		}
+	template := make(map[string]interface{})
 
-		switch header.Type {
-		case "literal":
-			log.V(1).Info("Adding literal header : " + header.Name)
-			req.Header.Add(header.Name, header.Value)
-		case "secret":
-			// Retrieve the header value from the secret
-			secretNamespaceName := nsn
-			secretNamespaceName.Name = header.Value
-
-			secret := &corev1.Secret{}
-			err = rclient.Get(context.TODO(), secretNamespaceName, secret)
-			if err != nil {
-				log.Error(err, "Failed to retrieve the authorization secret : "+header.Value)
-				return nil, err
-			} else {
+	if stale := staleAnnotations(current.Spec.Template.Annotations, desired.Spec.Template.Annotations,
+		"spec", "template", "metadata", "annotations"); len(stale) > 0 {
+		template["metadata"] = map[string]interface{}{"annotations": stale}
+	}
 
-				// Extract the raw secret. k8s automatically decodes it from base64
-				hdrValue := string(secret.Data[header.SecretKey])
+	desiredContainers := make(map[string]*corev1.Container)
+	for i := range desired.Spec.Template.Spec.Containers {
+		desiredContainers[desired.Spec.Template.Spec.Containers[i].Name] = &desired.Spec.Template.Spec.Containers[i]
+	}
 
-				if hdrValue != "" {
-					log.V(1).Info("Adding secret header : " + header.Name)
-					req.Header.Add(header.Name, hdrValue)
-				} else {
-					return nil, fmt.Errorf("The authorization secret : " + header.Value + " does not have the required key : " + header.SecretKey)
-				}
+	var containers []interface{}
+	for _, container := range current.Spec.Template.Spec.Containers {
+		containerPath := []interface{}{"spec", "template", "spec", "containers",
+			fieldpath.KeyByFields("name", container.Name)}
+
+		desiredContainer, ok := desiredContainers[container.Name]
+		if !ok {
+			if managed.Has(fieldpath.MakePathOrDie(containerPath...)) {
+				containers = append(containers, map[string]interface{}{"name": container.Name, "$patch": "delete"})
 			}
-		default:
-			// Invalid
-			return nil, fmt.Errorf("Configuration web header entry has an invalid type : " + header.Type)
+			continue
 		}
-	}
 
-	// Make the request
-	resp, err := client.Do(req)
-
-	// Handle the response
-	if err != nil {
-		log.Error(err, "Failed to get web config : "+webUrl)
-		return nil, err
-	} else {
-		defer resp.Body.Close()
-		if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
-			body, err := ioutil.ReadAll(resp.Body)
-			if err != nil {
-				log.Error(err, "Failed to get web config data")
-				return nil, err
-			} else {
-				webData := string(body)
-				log.V(1).Info("Found web config " + webData)
+		desiredEnv := make(map[string]bool)
+		for _, envVar := range desiredContainer.Env {
+			desiredEnv[envVar.Name] = true
+		}
 
-				master, err = handleYamlDataMerge(webData, master)
-				if err != nil {
-					return nil, err
-				}
+		var env []interface{}
+		for _, envVar := range container.Env {
+			envPath := append(containerPath, "env", fieldpath.KeyByFields("name", envVar.Name))
+			if !desiredEnv[envVar.Name] && managed.Has(fieldpath.MakePathOrDie(envPath...)) {
+				env = append(env, map[string]interface{}{"name": envVar.Name, "$patch": "delete"})
 			}
-		} else {
-			// Error response code
-			err = fmt.Errorf("Error response from the remote config source.")
-			log.Error(err, "HTTP Response Status:", fmt.Sprintf("%v", resp.StatusCode), fmt.Sprintf("%v", http.StatusText(resp.StatusCode)))
-			return nil, err
 		}
-	}
 
-	return master, nil
-}
+		if len(env) > 0 {
+			containers = append(containers, map[string]interface{}{"name": container.Name, "env": env})
+		}
+	}
 
-/**
- * This function will handle the conversion of new config data to a yaml map
- * and then merge that map with the existing master config.
- */
-func handleYamlDataMerge(newConfig string, masterConfig map[string]interface{}) (map[string]interface{}, error) {
+	if len(containers) > 0 {
+		template["spec"] = map[string]interface{}{"containers": containers}
+	}
 
-	// Unmarshal the new config data string into a Map
-	var currentYaml map[string]interface{}
-	err := yaml.Unmarshal([]byte(newConfig), &currentYaml)
-	if err != nil {
-		return nil, err
+	if len(template) > 0 {
+		patch["spec"] = map[string]interface{}{"template": template}
 	}
 
-	// At this point the YAML literal object is a recursed map
-	// Could be map[string][string]
-	// or map[string][map[string][string]]
-	// or map[string][map[string][map[string][string]]]
-	// etc
-	// Need to recursively merge literal with master
+	if len(patch) == 0 {
+		return nil
+	}
 
-	// Merge the current config map with the master config map
-	return mergeMapsRecursive(masterConfig, currentYaml), nil
+	return patch
 }
 
 /*
- * Function converts a map of interface --> interface to a map of string --> interface
+ * Function removes the fields of the current deployment which the operator
+ * has previously managed, but which are not in the desired deployment, using
+ * a strategic merge patch.  If the pod template changes the reason is
+ * recorded in the revision history.  The passed in deployment is updated
+ * with the result.
  */
-func convertInterfaceKeysToString(inputMap map[interface{}]interface{}) map[string]interface{} {
+func removeStaleFields(r *IBMApplicationGatewayReconciler, dply *appsv1.Deployment,
+	desired *appsv1.Deployment) error {
 
-	retVal := make(map[string]interface{})
+	patch := getStaleFieldsPatch(dply, desired)
+	if patch == nil {
+		return nil
+	}
 
-	for key, value := range inputMap {
-		strKey := fmt.Sprintf("%v", key)
+	log.Info("Removing the fields which are no longer managed by the operator from the deployment : " + dply.Name)
 
-		retVal[strKey] = value
+	// Determine whether the pod template changes, so that the reason can be
+	// recorded along with the change
+	pruned, err := applyStrategicPatch(dply, patch)
+	if err != nil {
+		return err
 	}
 
-	return retVal
-}
+	if !equality.Semantic.DeepEqual(dply.Spec.Template, pruned.Spec.Template) {
+		changeCause := getChangeCause(dply, pruned)
+		desired.Annotations[changeCauseAnnotationKey] = changeCause
 
-/*
- * Function validates that all map keys are of type string recursively.
- * This almost mirrors validateStringKeysFromInterface but golang doesn't seem to allow
- * a generic arg that can be casted.
- */
-func validateStringKeysFromString(inputMap map[string]interface{}) map[string]interface{} {
-	log.V(1).Info("convertMaster")
-
-	retVal := make(map[string]interface{})
-
-	for key, value := range inputMap {
-		switch value2 := value.(type) {
-		case map[string]interface{}:
-			log.V(1).Info("String : " + key)
-			retVal[key] = validateStringKeysFromString(value2)
-		case map[interface{}]interface{}:
-			log.V(1).Info("interface : " + key)
-			retVal[fmt.Sprint(key)] = validateStringKeysFromInterface(value2)
-		case []interface{}:
-			// Handle array of interfaces
-			var arry []interface{}
-			for _, elem := range value2 {
-				switch value3 := elem.(type) {
-				case map[string]interface{}:
-					arry = append(arry, validateStringKeysFromString(value3))
-				case map[interface{}]interface{}:
-					arry = append(arry, validateStringKeysFromInterface(value3))
-				default:
-					arry = append(arry, value3)
-				}
-			}
+		metadata, _ := patch["metadata"].(map[string]interface{})
+		if metadata == nil {
+			metadata = make(map[string]interface{})
+			patch["metadata"] = metadata
+		}
 
-			retVal[fmt.Sprint(key)] = arry
-		default:
-			retVal[fmt.Sprint(key)] = value
+		annotations, _ := metadata["annotations"].(map[string]interface{})
+		if annotations == nil {
+			annotations = make(map[string]interface{})
+			metadata["annotations"] = annotations
 		}
+
+		annotations[changeCauseAnnotationKey] = changeCause
 	}
 
-	return retVal
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+
+	return r.Client.Patch(context.TODO(), dply, client.RawPatch(types.StrategicMergePatchType, data), fieldOwner)
 }
 
 /*
- * Function validates that all map keys are of type string recursively.
- * This almost mirrors validateStringKeysFromString but golang doesn't seem to allow
- * a generic arg that can be casted.
+ * Function returns a copy of the passed in deployment with the passed in
+ * strategic merge patch applied.
  */
-func validateStringKeysFromInterface(inputMap map[interface{}]interface{}) map[string]interface{} {
-	log.V(1).Info("convertInterfaceToString")
-
-	retVal := make(map[string]interface{})
-
-	for key, value := range inputMap {
-		switch value2 := value.(type) {
-		case map[interface{}]interface{}:
-			retVal[fmt.Sprint(key)] = validateStringKeysFromInterface(value2)
-		case map[string]interface{}:
-			retVal[fmt.Sprint(key)] = validateStringKeysFromString(value2)
-		case []interface{}:
-			// Handle array of interfaces
-			var arry []interface{}
-			for _, elem := range value2 {
-				switch value3 := elem.(type) {
-				case map[string]interface{}:
-					arry = append(arry, validateStringKeysFromString(value3))
-				case map[interface{}]interface{}:
-					arry = append(arry, validateStringKeysFromInterface(value3))
-				default:
-					arry = append(arry, value3)
-				}
-			}
+func applyStrategicPatch(dply *appsv1.Deployment, patch map[string]interface{}) (*appsv1.Deployment, error) {
+	original, err := json.Marshal(dply)
+	if err != nil {
+		return nil, err
+	}
 
-			retVal[fmt.Sprint(key)] = arry
-		default:
-			retVal[fmt.Sprint(key)] = value
-		}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return nil, err
 	}
 
-	return retVal
+	merged, err := strategicpatch.StrategicMergePatch(original, data, &appsv1.Deployment{})
+	if err != nil {
+		return nil, err
+	}
+
+	patched := &appsv1.Deployment{}
+	if err := json.Unmarshal(merged, patched); err != nil {
+		return nil, err
+	}
+
+	return patched, nil
 }
 
 /*
- * Function merges to maps to a single map.
- * Entries in the 2nd map will overwrite entries in the 1st map
+ * Function returns a human readable description of the changes between the
+ * pod templates of the current and updated deployments.
  */
-func mergeMapsRecursive(inputMap1 map[string]interface{}, inputMap2 map[string]interface{}) map[string]interface{} {
-
-	var retVal = inputMap1
-
-	for key, value := range inputMap2 {
-		if !reflect.DeepEqual(inputMap1[key], inputMap2[key]) {
-			switch v := value.(type) {
-			case map[interface{}]interface{}:
-				switch v2 := inputMap1[key].(type) {
-				case map[interface{}]interface{}:
-					retVal[key] = mergeMapsRecursive(convertInterfaceKeysToString(v2), convertInterfaceKeysToString(v))
-				default:
-					retVal[key] = inputMap2[key]
-				}
-			case []interface{}:
-				// Its an array of entries
-				// All we do here is to add all new elements to the existing elements
-				switch v2 := inputMap1[key].(type) {
-				case []interface{}:
-
-					// New container for all elements
-					var allVals []interface{}
-
-					// Add the existing elements
-					for _, element := range v {
-						allVals = append(allVals, element)
-					}
-
-					// Add the new elements
-					for _, element := range v2 {
-						allVals = append(allVals, element)
-					}
-
-					// Set the new array containing both
-					retVal[key] = allVals
-				default:
-					// Not both arrays. This is not valid. Just set as 2nd value
-					retVal[key] = inputMap2[key]
-				}
+func getChangeCause(current *appsv1.Deployment, updated *appsv1.Deployment) string {
 
-			default:
-				retVal[key] = inputMap2[key]
-			}
-		} else {
-			retVal[key] = value
-		}
+	var changes []string
+
+	currTemp := &current.Spec.Template
+	updTemp := &updated.Spec.Template
+
+	// Config version
+	if currTemp.Labels[configVersionLabelKey] != updTemp.Labels[configVersionLabelKey] {
+		changes = append(changes, "Configuration change")
 	}
 
-	return retVal
-}
+	// Language
+	if currTemp.Labels[langLabelKey] != updTemp.Labels[langLabelKey] {
+		changes = append(changes, fmt.Sprintf("Language changed from %s to %s",
+			currTemp.Labels[langLabelKey], updTemp.Labels[langLabelKey]))
+	}
 
-/*
- * Function creates a new config map from the merged definitions but does not deploy it
- */
-func createNewConfigMap(r *IBMApplicationGatewayReconciler, instance *ibmv1.IBMApplicationGateway, request ctrl.Request, depl *appsv1.Deployment) (string, string, error) {
-	reqLogger := log.WithValues("Request.Namespace", "request.Namespace", "Request.Name", "request.Name")
+	// Service account
+	if currTemp.Spec.ServiceAccountName != updTemp.Spec.ServiceAccountName {
+		changes = append(changes, fmt.Sprintf("Service account changed from %s to %s",
+			currTemp.Spec.ServiceAccountName, updTemp.Spec.ServiceAccountName))
+	}
 
-	// Check to see if the config has changed
-	newData, err := getMergedConfig(r, instance, request)
-	if err != nil {
-		reqLogger.Error(err, "Failed to get merged config.")
-		return "", "", err
+	if !equality.Semantic.DeepEqual(currTemp.Spec.ImagePullSecrets, updTemp.Spec.ImagePullSecrets) {
+		changes = append(changes, "Image pull secrets changed")
+	}
+
+	if currTemp.Spec.NodeSelector == nil && updTemp.Spec.NodeSelector == nil {
+		// No op.
+	} else if !equality.Semantic.DeepEqual(currTemp.Spec.NodeSelector, updTemp.Spec.NodeSelector) {
+		changes = append(changes, "Node selector changed")
 	}
 
-	configMap := newConfigMap(instance, newData)
+	if !equality.Semantic.DeepEqual(currTemp.Spec.Tolerations, updTemp.Spec.Tolerations) ||
+		!equality.Semantic.DeepEqual(currTemp.Spec.Affinity, updTemp.Spec.Affinity) ||
+		!equality.Semantic.DeepEqual(currTemp.Spec.TopologySpreadConstraints, updTemp.Spec.TopologySpreadConstraints) ||
+		currTemp.Spec.PriorityClassName != updTemp.Spec.PriorityClassName {
+		changes = append(changes, "Scheduling changed")
+	}
 
-	// Set Presentation instance as the owner and controller
-	if err = controllerutil.SetControllerReference(instance, configMap, r.Scheme); err != nil {
-		return "", "", err
+	if !equality.Semantic.DeepEqual(currTemp.Spec.SecurityContext, updTemp.Spec.SecurityContext) {
+		changes = append(changes, "Pod security context changed")
 	}
 
-	// Check if this ConfigMap already exists
-	foundMap, err := getCurrentConfigMap(r, instance, depl)
-	if foundMap == nil || (err != nil && errors.IsNotFound(err)) {
-		err = r.Client.Create(context.TODO(), configMap)
-		if err != nil {
-			return "", "", err
+	if !equality.Semantic.DeepEqual(currTemp.Spec.Volumes, updTemp.Spec.Volumes) {
+		changes = append(changes, "Volumes changed")
+	}
+
+	// The IAG container is always the first container
+	if len(currTemp.Spec.Containers) > 0 && len(updTemp.Spec.Containers) > 0 {
+		currCont := &currTemp.Spec.Containers[0]
+		updCont := &updTemp.Spec.Containers[0]
+
+		// Image location
+		if currCont.Image != updCont.Image {
+			changes = append(changes, fmt.Sprintf("Image changed from %s to %s", currCont.Image, updCont.Image))
 		}
 
-		return configMap.Name, configMap.ResourceVersion, nil
+		if currCont.ImagePullPolicy != updCont.ImagePullPolicy {
+			changes = append(changes, fmt.Sprintf("Image pull policy changed from %s to %s",
+				currCont.ImagePullPolicy, updCont.ImagePullPolicy))
+		}
 
-	} else if err != nil {
-		return "", "", err
-	}
+		if !equality.Semantic.DeepEqual(currCont.ReadinessProbe, updCont.ReadinessProbe) {
+			changes = append(changes, "Readiness probe changed")
+		}
 
-	if foundMap.Data[configMapMasterKey] != configMap.Data[configMapMasterKey] {
-		reqLogger.Info("Config has changed so recreate the master configmap.")
-		foundMap.Data[configMapMasterKey] = configMap.Data[configMapMasterKey]
-		err = r.Client.Update(context.TODO(), foundMap)
-		if err != nil {
-			return "", "", err
+		if !equality.Semantic.DeepEqual(currCont.LivenessProbe, updCont.LivenessProbe) {
+			changes = append(changes, "Liveness probe changed")
 		}
-	}
-	return foundMap.Name, foundMap.ResourceVersion, nil
-}
 
-/*
- * Function retrieves the current deployed IAG merged config map
- */
-func getCurrentConfigMap(r *IBMApplicationGatewayReconciler, instance *ibmv1.IBMApplicationGateway, depl *appsv1.Deployment) (*corev1.ConfigMap, error) {
+		if !equality.Semantic.DeepEqual(currCont.Resources, updCont.Resources) {
+			changes = append(changes, "Resources changed")
+		}
 
-	configMapName := depl.Spec.Template.Labels[configMapLabelKey]
+		if !equality.Semantic.DeepEqual(currCont.SecurityContext, updCont.SecurityContext) {
+			changes = append(changes, "Security context changed")
+		}
 
-	if configMapName == "" {
-		return nil, nil
+		if !equality.Semantic.DeepEqual(currCont.Env, updCont.Env) ||
+			!equality.Semantic.DeepEqual(currCont.EnvFrom, updCont.EnvFrom) {
+			changes = append(changes, "Environment changed")
+		}
+
+		if !equality.Semantic.DeepEqual(currCont.VolumeMounts, updCont.VolumeMounts) {
+			changes = append(changes, "Volume mounts changed")
+		}
 	}
 
-	// Get the ConfigMap
-	foundMap := &corev1.ConfigMap{}
-	err := r.Client.Get(context.TODO(), types.NamespacedName{Name: configMapName, Namespace: instance.Namespace}, foundMap)
-	if err != nil {
-		return nil, err
+	// Referenced secrets
+	if currTemp.Annotations[secretHashAnnotationKey] != updTemp.Annotations[secretHashAnnotationKey] {
+		changes = append(changes, "Referenced secret change")
 	}
 
-	return foundMap, nil
+	if !equality.Semantic.DeepEqual(withoutKeys(currTemp.Annotations, secretHashAnnotationKey, podTemplateHashAnnotationKey),
+		withoutKeys(updTemp.Annotations, secretHashAnnotationKey, podTemplateHashAnnotationKey)) {
+		changes = append(changes, "Pod annotations changed")
+	}
+
+	if len(changes) == 0 {
+		// Must be a change which is not explicitly described, such as a
+		// manual edit of the deployment
+		return "Deployment template change"
+	}
+
+	return strings.Join(changes, ", ")
 }
 
 /*
- * Function creates a new deployment
+ * Function returns a copy of the passed in map without the specified keys.
  */
-func createNewDeployment(r *IBMApplicationGatewayReconciler, instance *ibmv1.IBMApplicationGateway,
-	request ctrl.Request, cmVersion string, cmName string) (string, error) {
-	reqLogger := log.WithValues("Request.Namespace", request.Namespace, "Request.Name", request.Name)
-
-	deployment := newDeploymentForCR(instance, cmVersion, cmName)
+func withoutKeys(input map[string]string, keys ...string) map[string]string {
+	output := make(map[string]string, len(input))
+	for k, v := range input {
+		output[k] = v
+	}
 
-	err := r.Client.Create(context.TODO(), deployment)
-	if err != nil {
-		reqLogger.Error(err, "Failed to create a new deployment.")
-		return "", err
+	for _, key := range keys {
+		delete(output, key)
 	}
 
-	return deployment.GetObjectMeta().GetName(), nil
+	return output
 }
 
 /*
@@ -892,6 +929,17 @@ func getDeploymentName(cr *ibmv1.IBMApplicationGateway) string {
 	return cr.Name
 }
 
+/*
+ * Function returns the labels which are used to select the IAG pods for the
+ * passed in IAG instance
+ */
+func getSelectorLabels(cr *ibmv1.IBMApplicationGateway) map[string]string {
+	return map[string]string{
+		"app":     cr.Name,
+		"version": "v0.1",
+	}
+}
+
 /*
  * Function returns the master configmap name using the passed in IAG instance
  */
@@ -908,34 +956,35 @@ func getConfigMapName(cr *ibmv1.IBMApplicationGateway) string {
  * Function creates and returns a new IAG pod with the same name/namespace as the cr
  * Note that at this point the POD is not created in K8s. This is just a container.
  */
-func newDeploymentForCR(cr *ibmv1.IBMApplicationGateway, cmVersion string, cmName string) *appsv1.Deployment {
+func newDeploymentForCR(cr *ibmv1.IBMApplicationGateway, cmVersion string, cmName string, cmStorage string) *appsv1.Deployment {
 
 	reqLogger := log.WithValues("Request.Namespace", "IBMApplicationGateway", "Request.Name", cr.Name)
 	reqLogger.Info("newPodForCR")
 
 	// These are the main k8s labels to use for the selector
-	labelsSel := map[string]string{
-		"app":     cr.Name,
-		"version": "v0.1",
-	}
+	labelsSel := getSelectorLabels(cr)
 
 	// Exract the deployment values from the custom resource yaml
 	serviceAccountName := cr.Spec.Deployment.ServiceAccountName
 	lang := cr.Spec.Deployment.Lang
+	if lang == "" {
+		lang = "C"
+	}
 	imageName := cr.Spec.Deployment.ImageLocation
 	imagePullSecrets := cr.Spec.Deployment.ImagePullSecrets
 	configMapName := cmName
 	podName := getDeploymentName(cr)
 	specPullPolicy := cr.Spec.Deployment.ImagePullPolicy
 
-	// These are the template labels
-	labelsTemp := map[string]string{
+	// These are the template labels.  The operator labels are added last
+	// so that they cannot be overridden by the custom pod labels.
+	labelsTemp := mergeStringMaps(cr.Spec.Deployment.PodLabels, map[string]string{
 		"app":                 cr.Name,
 		"version":             "v0.1",
 		configVersionLabelKey: cmVersion,
 		langLabelKey:          lang,
 		configMapLabelKey:     cmName,
-	}
+	})
 
 	var imagePullPolicy corev1.PullPolicy
 	switch strings.ToLower(specPullPolicy) {
@@ -1033,12 +1082,47 @@ func newDeploymentForCR(cr *ibmv1.IBMApplicationGateway, cmVersion string, cmNam
 		}
 	}
 
+	// The config volume is always first, followed by any additional volumes
+	volumes := []corev1.Volume{
+		{
+			Name:         configVolumeName,
+			VolumeSource: getConfigVolumeSource(configMapName, cmStorage),
+		},
+	}
+	volumes = append(volumes, cr.Spec.Deployment.Volumes...)
+
+	volumeMounts := []corev1.VolumeMount{
+		{
+			Name:      configVolumeName,
+			MountPath: "/var/iag/config",
+		},
+	}
+	volumeMounts = append(volumeMounts, cr.Spec.Deployment.VolumeMounts...)
+
+	// The LANG variable is always first, followed by any additional variables
+	env := []corev1.EnvVar{
+		{
+			Name:  "LANG",
+			Value: lang,
+		},
+	}
+	for _, envVar := range cr.Spec.Deployment.Env {
+		if envVar.Name != "LANG" {
+			env = append(env, envVar)
+		}
+	}
+
 	// Create the new deployment
 	return &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: appsv1.SchemeGroupVersion.String(),
+			Kind:       "Deployment",
+		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      podName,
-			Namespace: cr.Namespace,
-			Labels:    labelsSel,
+			Name:        podName,
+			Namespace:   cr.Namespace,
+			Labels:      labelsSel,
+			Annotations: map[string]string{},
 			OwnerReferences: []metav1.OwnerReference{
 				*metav1.NewControllerRef(cr, ibmv1.GroupVersion.WithKind("IBMApplicationGateway")),
 			},
@@ -1054,37 +1138,25 @@ func newDeploymentForCR(cr *ibmv1.IBMApplicationGateway, cmVersion string, cmNam
 					Annotations: annotations,
 				},
 				Spec: corev1.PodSpec{
-					ServiceAccountName: serviceAccountName,
-					Volumes: []corev1.Volume{
-						{
-							Name: "iag-config",
-							VolumeSource: corev1.VolumeSource{
-								ConfigMap: &corev1.ConfigMapVolumeSource{
-									LocalObjectReference: corev1.LocalObjectReference{
-										Name: configMapName,
-									},
-								},
-							},
-						},
-					},
-					ImagePullSecrets: ipSecrets,
+					ServiceAccountName:        serviceAccountName,
+					Volumes:                   volumes,
+					ImagePullSecrets:          ipSecrets,
+					NodeSelector:              cr.Spec.Deployment.NodeSelector,
+					Tolerations:               cr.Spec.Deployment.Tolerations,
+					Affinity:                  cr.Spec.Deployment.Affinity,
+					TopologySpreadConstraints: cr.Spec.Deployment.TopologySpreadConstraints,
+					PriorityClassName:         cr.Spec.Deployment.PriorityClassName,
+					SecurityContext:           cr.Spec.Deployment.PodSecurityContext,
 					Containers: []corev1.Container{
 						{
 							Name:            podName,
 							Image:           imageName, // icr.io/ibmappgateway/ibm-application-gateway:19.12
 							ImagePullPolicy: imagePullPolicy,
-							VolumeMounts: []corev1.VolumeMount{
-								{
-									Name:      "iag-config",
-									MountPath: "/var/iag/config",
-								},
-							},
-							Env: []corev1.EnvVar{
-								{
-									Name:  "LANG",
-									Value: lang,
-								},
-							},
+							VolumeMounts:    volumeMounts,
+							Env:             env,
+							EnvFrom:         cr.Spec.Deployment.EnvFrom,
+							Resources:       cr.Spec.Deployment.Resources,
+							SecurityContext: cr.Spec.Deployment.SecurityContext,
 							ReadinessProbe: &corev1.Probe{
 								InitialDelaySeconds: readinessInitDelay,
 								PeriodSeconds:       readinessPeriod,
@@ -1125,22 +1197,24 @@ func newDeploymentForCR(cr *ibmv1.IBMApplicationGateway, cmVersion string, cmNam
  * Function creates a new master ConfigMap with the passed in data.
  * Note that at this point the POD is not created in K8s. This is just a container.
  */
-func newConfigMap(cr *ibmv1.IBMApplicationGateway, newData string) *corev1.ConfigMap {
+func newConfigMap(cr *ibmv1.IBMApplicationGateway, newData string, provenanceData string) *corev1.ConfigMap {
 
 	configMapName := getConfigMapName(cr)
 
-	return getNewConfigMap(configMapName, cr.Name, cr.Namespace, newData)
+	return getNewConfigMap(configMapName, cr.Name, cr.Namespace, newData, provenanceData)
 }
 
 /*
- * Function populates a new ConfigMap object.
+ * Function populates a new ConfigMap object.  The provenance report is only
+ * added if one has been recorded.
  */
-func getNewConfigMap(configMapName string, appName string, ns string, newData string) *corev1.ConfigMap {
+func getNewConfigMap(configMapName string, appName string, ns string, newData string,
+	provenanceData string) *corev1.ConfigMap {
 
 	labels := map[string]string{
 		"app": appName,
 	}
-	return &corev1.ConfigMap{
+	configMap := &corev1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
 			GenerateName: configMapName,
 			Namespace:    ns,
@@ -1150,6 +1224,12 @@ func getNewConfigMap(configMapName string, appName string, ns string, newData st
 			configMapMasterKey: newData,
 		},
 	}
+
+	if provenanceData != "" {
+		configMap.Data[configMapProvenanceKey] = provenanceData
+	}
+
+	return configMap
 }
 
 /*
@@ -1394,9 +1474,18 @@ func handleOidcRegistration(entry *IAGOidcReg, rclient client.Client, ns string)
 			return fmt.Errorf("The OIDC registration did not return a valid client ID or secret.")
 		}
 
-		// Add the values to the secret
+		// Add the values to the secret.  The client configuration endpoint
+		// and access token are saved so that the client can be deleted when
+		// the custom resource is deleted.
 		secret.Data["client_id"] = []byte(clientData.Client_id)
 		secret.Data["client_secret"] = []byte(clientData.Client_secret)
+		if clientData.Registration_client_uri != "" && clientData.Registration_access_token != "" {
+			secret.Data[registrationClientUriKey] = []byte(clientData.Registration_client_uri)
+			secret.Data[registrationAccessTokenKey] = []byte(clientData.Registration_access_token)
+		} else {
+			delete(secret.Data, registrationClientUriKey)
+			delete(secret.Data, registrationAccessTokenKey)
+		}
 		err = rclient.Update(context.TODO(), secret)
 		if err != nil {
 			reqLogger.Error(err, "Failed to update the Kubernetes secret with the client ID and secret.")
@@ -1412,8 +1501,20 @@ func handleOidcRegistration(entry *IAGOidcReg, rclient client.Client, ns string)
 	return nil
 }
 
+// The error which is returned when the response to a request has an
+// unexpected status code.
+type requestStatusError struct {
+	statusCode int
+	message    string
+}
+
+func (e *requestStatusError) Error() string {
+	return e.message
+}
+
 /*
  * Function makes an HTTP request and returns the resulting data as a string.
+ * A response with an unexpected status code results in a requestStatusError.
  */
 func doRequest(url string, method string, data []byte, insecure bool, baUser string, baPwd string, bearerToken string) (string, error) {
 
@@ -1428,7 +1529,7 @@ func doRequest(url string, method string, data []byte, insecure bool, baUser str
 
 	// Add service account CA to rootCAs
 	if !insecure {
-		cert, err := ioutil.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/service-ca.crt")
+		cert, err := ioutil.ReadFile(serviceCAFile)
 		if err == nil {
 			rootCAs.AppendCertsFromPEM(cert)
 		} else {
@@ -1487,8 +1588,8 @@ func doRequest(url string, method string, data []byte, insecure bool, baUser str
 		return "", err
 	}
 
-	if resp.StatusCode != 200 && resp.StatusCode != 201 {
-		err = fmt.Errorf("%v", resp)
+	if resp.StatusCode != 200 && resp.StatusCode != 201 && resp.StatusCode != 204 {
+		err = &requestStatusError{statusCode: resp.StatusCode, message: fmt.Sprintf("%v", resp)}
 		logger.Error(err, "The request to the OIDC provider failed.")
 		return "", err
 	}
@@ -1505,7 +1606,12 @@ func manageError(r *IBMApplicationGatewayReconciler, instance *ibmv1.IBMApplicat
 	logger := log.WithName("manageError")
 	logger.Info("Entry")
 
-	instance.Status.Status = false
+	instance.Status.ObservedGeneration = instance.Generation
+	setCondition(instance, ibmv1.ConditionDegraded, metav1.ConditionTrue,
+		reasonReconcileFailed, issue.Error())
+	setCondition(instance, ibmv1.ConditionReady, metav1.ConditionFalse,
+		reasonReconcileFailed, issue.Error())
+
 	r.EventRecorder.Event(instance, "Warning", "Failed", issue.Error())
 	err := r.Client.Status().Update(context.Background(), instance)
 	if err != nil {
@@ -1514,13 +1620,54 @@ func manageError(r *IBMApplicationGatewayReconciler, instance *ibmv1.IBMApplicat
 	}
 	logger.Info("Exit")
 
-	return ctrl.Result{}, nil
+	// Keep refreshing any web configuration sources, as the error may have
+	// been caused by the content of one of the sources
+	return ctrl.Result{RequeueAfter: getRefreshInterval(instance)}, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *IBMApplicationGatewayReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	// Index the custom resources by the config maps and secrets which they
+	// reference so that a change to a config map or secret can be mapped back
+	// to the custom resources
+	err := mgr.GetFieldIndexer().IndexField(context.Background(), &ibmv1.IBMApplicationGateway{},
+		configMapIndexField, indexReferencedConfigMaps)
+	if err != nil {
+		return err
+	}
+
+	err = mgr.GetFieldIndexer().IndexField(context.Background(), &ibmv1.IBMApplicationGateway{},
+		secretIndexField, indexReferencedSecrets)
+	if err != nil {
+		return err
+	}
+
+	bldr := ctrl.NewControllerManagedBy(mgr).
 		For(&ibmv1.IBMApplicationGateway{}).
-		Watches(&corev1.ConfigMap{}, &handler.EnqueueRequestForObject{}).
+		Owns(&appsv1.Deployment{}).
+		Owns(&corev1.Service{}).
+		Owns(&networkingv1.Ingress{})
+
+	// The OpenShift route is only watched if routes are supported by the
+	// cluster, as the watch cannot be started otherwise
+	if _, err := mgr.GetRESTMapper().RESTMapping(routeGVK.GroupKind(), routeGVK.Version); err == nil {
+		route := &unstructured.Unstructured{}
+		route.SetGroupVersionKind(routeGVK)
+		bldr = bldr.Owns(route)
+	} else if !meta.IsNoMatchError(err) {
+		return err
+	}
+
+	return bldr.
+		Watches(&corev1.ConfigMap{},
+			handler.EnqueueRequestsFromMapFunc(r.findGatewaysForIndex(configMapIndexField, sourceReferenceConfigMap)),
+			builder.WithPredicates(referencedDataPredicate())).
+		Watches(&corev1.Secret{},
+			handler.EnqueueRequestsFromMapFunc(r.findGatewaysForIndex(secretIndexField, sourceReferenceSecret)),
+			builder.WithPredicates(referencedDataPredicate())).
+		Watches(&ibmv1.IBMApplicationGatewayConfigGrant{},
+			handler.EnqueueRequestsFromMapFunc(r.findGatewaysForGrant)).
+		Watches(&ibmv1.IBMApplicationGatewayClass{},
+			handler.EnqueueRequestsFromMapFunc(r.findGatewaysForClass)).
 		Complete(r)
 }
@@ -0,0 +1,370 @@
+/*
+ * Copyright contributors to the IBM Application Gateway Operator project
+ */
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/structured-merge-diff/v4/fieldpath"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ibmv1 "github.com/ibm-security/ibm-application-gateway-operator/api/v1"
+)
+
+/*
+ * Function returns the deployment which is rendered for the passed in custom
+ * resource.
+ */
+func newTestDeployment(instance *ibmv1.IBMApplicationGateway) *appsv1.Deployment {
+	return newDeploymentForCR(instance, "0123456789abcdef", "iag-instance-config", configStorageConfigMap)
+}
+
+func TestNewDeploymentForCR(t *testing.T) {
+	nonRoot := true
+	privileged := false
+
+	resources := corev1.ResourceRequirements{
+		Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("512Mi")},
+		Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("250m")},
+	}
+	nodeSelector := map[string]string{"kubernetes.io/os": "linux"}
+	tolerations := []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "gateway",
+		Effect: corev1.TaintEffectNoSchedule}}
+	affinity := &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+			NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchExpressions: []corev1.NodeSelectorRequirement{
+				{Key: "zone", Operator: corev1.NodeSelectorOpIn, Values: []string{"a"}},
+			}}},
+		},
+	}}
+	spread := []corev1.TopologySpreadConstraint{{MaxSkew: 1, TopologyKey: "topology.kubernetes.io/zone",
+		WhenUnsatisfiable: corev1.ScheduleAnyway}}
+	podSecurityContext := &corev1.PodSecurityContext{RunAsNonRoot: &nonRoot}
+	securityContext := &corev1.SecurityContext{Privileged: &privileged}
+	envFrom := []corev1.EnvFromSource{{ConfigMapRef: &corev1.ConfigMapEnvSource{
+		LocalObjectReference: corev1.LocalObjectReference{Name: "iag-env"}}}}
+	volume := corev1.Volume{Name: "certs", VolumeSource: corev1.VolumeSource{
+		Secret: &corev1.SecretVolumeSource{SecretName: "iag-certs"}}}
+	volumeMount := corev1.VolumeMount{Name: "certs", MountPath: "/var/iag/certs"}
+
+	tests := []struct {
+		name   string
+		update func(depl *ibmv1.IBMApplicationGatewayDeployment)
+		check  func(t *testing.T, spec *corev1.PodSpec, container *corev1.Container)
+	}{
+		{name: "resources",
+			update: func(depl *ibmv1.IBMApplicationGatewayDeployment) { depl.Resources = resources },
+			check: func(t *testing.T, spec *corev1.PodSpec, container *corev1.Container) {
+				if !reflect.DeepEqual(container.Resources, resources) {
+					t.Errorf("Unexpected resources : %v", container.Resources)
+				}
+			}},
+		{name: "node selector",
+			update: func(depl *ibmv1.IBMApplicationGatewayDeployment) { depl.NodeSelector = nodeSelector },
+			check: func(t *testing.T, spec *corev1.PodSpec, container *corev1.Container) {
+				if !reflect.DeepEqual(spec.NodeSelector, nodeSelector) {
+					t.Errorf("Unexpected node selector : %v", spec.NodeSelector)
+				}
+			}},
+		{name: "tolerations",
+			update: func(depl *ibmv1.IBMApplicationGatewayDeployment) { depl.Tolerations = tolerations },
+			check: func(t *testing.T, spec *corev1.PodSpec, container *corev1.Container) {
+				if !reflect.DeepEqual(spec.Tolerations, tolerations) {
+					t.Errorf("Unexpected tolerations : %v", spec.Tolerations)
+				}
+			}},
+		{name: "affinity",
+			update: func(depl *ibmv1.IBMApplicationGatewayDeployment) { depl.Affinity = affinity },
+			check: func(t *testing.T, spec *corev1.PodSpec, container *corev1.Container) {
+				if !reflect.DeepEqual(spec.Affinity, affinity) {
+					t.Errorf("Unexpected affinity : %v", spec.Affinity)
+				}
+			}},
+		{name: "topology spread constraints",
+			update: func(depl *ibmv1.IBMApplicationGatewayDeployment) { depl.TopologySpreadConstraints = spread },
+			check: func(t *testing.T, spec *corev1.PodSpec, container *corev1.Container) {
+				if !reflect.DeepEqual(spec.TopologySpreadConstraints, spread) {
+					t.Errorf("Unexpected topology spread constraints : %v", spec.TopologySpreadConstraints)
+				}
+			}},
+		{name: "priority class",
+			update: func(depl *ibmv1.IBMApplicationGatewayDeployment) { depl.PriorityClassName = "high" },
+			check: func(t *testing.T, spec *corev1.PodSpec, container *corev1.Container) {
+				if spec.PriorityClassName != "high" {
+					t.Errorf("Unexpected priority class : %s", spec.PriorityClassName)
+				}
+			}},
+		{name: "pod security context",
+			update: func(depl *ibmv1.IBMApplicationGatewayDeployment) { depl.PodSecurityContext = podSecurityContext },
+			check: func(t *testing.T, spec *corev1.PodSpec, container *corev1.Container) {
+				if !reflect.DeepEqual(spec.SecurityContext, podSecurityContext) {
+					t.Errorf("Unexpected pod security context : %v", spec.SecurityContext)
+				}
+			}},
+		{name: "container security context",
+			update: func(depl *ibmv1.IBMApplicationGatewayDeployment) { depl.SecurityContext = securityContext },
+			check: func(t *testing.T, spec *corev1.PodSpec, container *corev1.Container) {
+				if !reflect.DeepEqual(container.SecurityContext, securityContext) {
+					t.Errorf("Unexpected container security context : %v", container.SecurityContext)
+				}
+			}},
+		{name: "environment variables",
+			update: func(depl *ibmv1.IBMApplicationGatewayDeployment) {
+				depl.Env = []corev1.EnvVar{{Name: "LANG", Value: "fr_FR"}, {Name: "TZ", Value: "UTC"}}
+				depl.EnvFrom = envFrom
+			},
+			check: func(t *testing.T, spec *corev1.PodSpec, container *corev1.Container) {
+				expected := []corev1.EnvVar{{Name: "LANG", Value: "C"}, {Name: "TZ", Value: "UTC"}}
+				if !reflect.DeepEqual(container.Env, expected) {
+					t.Errorf("Expected the environment %v but got %v", expected, container.Env)
+				}
+				if !reflect.DeepEqual(container.EnvFrom, envFrom) {
+					t.Errorf("Unexpected environment sources : %v", container.EnvFrom)
+				}
+			}},
+		{name: "volumes",
+			update: func(depl *ibmv1.IBMApplicationGatewayDeployment) {
+				depl.Volumes = []corev1.Volume{volume}
+				depl.VolumeMounts = []corev1.VolumeMount{volumeMount}
+			},
+			check: func(t *testing.T, spec *corev1.PodSpec, container *corev1.Container) {
+				if len(spec.Volumes) != 2 || spec.Volumes[0].Name != "iag-config" ||
+					!reflect.DeepEqual(spec.Volumes[1], volume) {
+					t.Errorf("Expected the configuration volume followed by the additional volume but got %v",
+						spec.Volumes)
+				}
+				if len(container.VolumeMounts) != 2 || container.VolumeMounts[0].Name != "iag-config" ||
+					!reflect.DeepEqual(container.VolumeMounts[1], volumeMount) {
+					t.Errorf("Expected the configuration mount followed by the additional mount but got %v",
+						container.VolumeMounts)
+				}
+			}},
+		{name: "pod labels",
+			update: func(depl *ibmv1.IBMApplicationGatewayDeployment) {
+				depl.PodLabels = map[string]string{"team": "edge", "app": "override"}
+			},
+			check: func(t *testing.T, spec *corev1.PodSpec, container *corev1.Container) {}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			instance := &ibmv1.IBMApplicationGateway{
+				ObjectMeta: metav1.ObjectMeta{Name: "iag-instance", Namespace: "default"},
+				Spec: ibmv1.IBMApplicationGatewaySpec{
+					Replicas: 1,
+					Deployment: ibmv1.IBMApplicationGatewayDeployment{
+						ImageLocation: "icr.io/ibmappgateway/ibm-application-gateway:24.12",
+						Lang:          "C",
+					},
+				},
+			}
+			test.update(&instance.Spec.Deployment)
+
+			dply := newTestDeployment(instance)
+			test.check(t, &dply.Spec.Template.Spec, &dply.Spec.Template.Spec.Containers[0])
+
+			labels := dply.Spec.Template.Labels
+			if labels["app"] != "iag-instance" {
+				t.Errorf("Expected the operator labels not to be overridden but got %v", labels)
+			}
+			if instance.Spec.Deployment.PodLabels != nil && labels["team"] != "edge" {
+				t.Errorf("Expected the custom pod labels to be added but got %v", labels)
+			}
+		})
+	}
+}
+
+func TestNewDeploymentForCROmittedFields(t *testing.T) {
+	instance := &ibmv1.IBMApplicationGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "iag-instance", Namespace: "default"},
+		Spec: ibmv1.IBMApplicationGatewaySpec{
+			Replicas: 1,
+			Deployment: ibmv1.IBMApplicationGatewayDeployment{
+				ImageLocation: "icr.io/ibmappgateway/ibm-application-gateway:24.12",
+				Lang:          "C",
+			},
+		},
+	}
+
+	dply := newTestDeployment(instance)
+	spec := &dply.Spec.Template.Spec
+	container := &spec.Containers[0]
+
+	if spec.NodeSelector != nil || spec.Tolerations != nil || spec.Affinity != nil ||
+		spec.TopologySpreadConstraints != nil || spec.PriorityClassName != "" || spec.SecurityContext != nil {
+		t.Errorf("Expected the scheduling and security settings of the pod to be unset but got %+v", spec)
+	}
+	if container.SecurityContext != nil || container.EnvFrom != nil ||
+		!reflect.DeepEqual(container.Resources, corev1.ResourceRequirements{}) {
+		t.Errorf("Expected the resources and security settings of the container to be unset but got %+v", container)
+	}
+	if !reflect.DeepEqual(container.Env, []corev1.EnvVar{{Name: "LANG", Value: "C"}}) {
+		t.Errorf("Expected only the LANG environment variable but got %v", container.Env)
+	}
+	if len(spec.Volumes) != 1 || len(container.VolumeMounts) != 1 {
+		t.Errorf("Expected only the configuration volume but got %v and %v", spec.Volumes, container.VolumeMounts)
+	}
+}
+
+/*
+ * Function returns a managed fields entry for the passed in field manager
+ * which manages the passed in fields.
+ */
+func newTestManagedFields(t *testing.T, manager string, operation metav1.ManagedFieldsOperationType,
+	paths ...fieldpath.Path) metav1.ManagedFieldsEntry {
+	t.Helper()
+
+	raw, err := fieldpath.NewSet(paths...).ToJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return metav1.ManagedFieldsEntry{
+		Manager:    manager,
+		Operation:  operation,
+		APIVersion: "apps/v1",
+		FieldsType: "FieldsV1",
+		FieldsV1:   &metav1.FieldsV1{Raw: raw},
+	}
+}
+
+func TestRemoveStaleFields(t *testing.T) {
+	instance := &ibmv1.IBMApplicationGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "iag-instance", Namespace: "default"},
+		Spec: ibmv1.IBMApplicationGatewaySpec{
+			Deployment: ibmv1.IBMApplicationGatewayDeployment{
+				ImageLocation: "icr.io/ibmappgateway/ibm-application-gateway:24.12",
+				Env:           []corev1.EnvVar{{Name: "LOG_LEVEL", Value: "info"}},
+			},
+		},
+	}
+
+	desired := newTestDeployment(instance)
+	iag := desired.Spec.Template.Spec.Containers[0].Name
+
+	// The operator has stopped managing some fields, which have also been
+	// edited directly, and other fields have only been added directly
+	current := desired.DeepCopy()
+	current.Annotations = map[string]string{"operator-old": "annotation", "manual": "annotation"}
+	current.Spec.Template.Annotations["operator-old"] = "annotation"
+	current.Spec.Template.Annotations["manual"] = "annotation"
+	current.Spec.Template.Spec.Containers[0].Env = append(current.Spec.Template.Spec.Containers[0].Env,
+		corev1.EnvVar{Name: "OPERATOR_OLD", Value: "true"}, corev1.EnvVar{Name: "MANUAL", Value: "true"})
+	current.Spec.Template.Spec.Containers = append(current.Spec.Template.Spec.Containers,
+		corev1.Container{Name: "operator-old", Image: "busybox"}, corev1.Container{Name: "manual", Image: "busybox"})
+
+	containerPath := func(name string, rest ...interface{}) fieldpath.Path {
+		return fieldpath.MakePathOrDie(append([]interface{}{"spec", "template", "spec", "containers",
+			fieldpath.KeyByFields("name", name)}, rest...)...)
+	}
+
+	operatorPaths := []fieldpath.Path{
+		fieldpath.MakePathOrDie("metadata", "annotations", "operator-old"),
+		fieldpath.MakePathOrDie("spec", "template", "metadata", "annotations", "operator-old"),
+		containerPath(iag),
+		containerPath(iag, "env", fieldpath.KeyByFields("name", "LOG_LEVEL")),
+		containerPath(iag, "env", fieldpath.KeyByFields("name", "OPERATOR_OLD")),
+		containerPath("operator-old"),
+	}
+
+	editorPaths := []fieldpath.Path{
+		fieldpath.MakePathOrDie("metadata", "annotations", "operator-old"),
+		fieldpath.MakePathOrDie("metadata", "annotations", "manual"),
+		fieldpath.MakePathOrDie("spec", "template", "metadata", "annotations", "manual"),
+		containerPath(iag, "env", fieldpath.KeyByFields("name", "OPERATOR_OLD")),
+		containerPath(iag, "env", fieldpath.KeyByFields("name", "MANUAL")),
+		containerPath("operator-old"),
+		containerPath("manual"),
+	}
+
+	current.ManagedFields = []metav1.ManagedFieldsEntry{
+		newTestManagedFields(t, string(fieldOwner), metav1.ManagedFieldsOperationApply, operatorPaths...),
+		newTestManagedFields(t, "kubectl-edit", metav1.ManagedFieldsOperationUpdate, editorPaths...),
+	}
+
+	// Nothing is removed from a deployment which the operator does not manage
+	unmanaged := current.DeepCopy()
+	unmanaged.ManagedFields = unmanaged.ManagedFields[1:]
+	if patch := getStaleFieldsPatch(unmanaged, desired); patch != nil {
+		t.Errorf("Expected nothing to be removed but got %v", patch)
+	}
+
+	r := &IBMApplicationGatewayReconciler{Client: newTestClient(t, current.DeepCopy())}
+
+	dply := current.DeepCopy()
+	if err := removeStaleFields(r, dply, desired); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := &appsv1.Deployment{}
+	if err := r.Client.Get(context.TODO(), types.NamespacedName{Name: current.Name,
+		Namespace: current.Namespace}, stored); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		expected bool
+		actual   bool
+	}{
+		{name: "the stale annotation is removed", actual: stored.Annotations["operator-old"] != ""},
+		{name: "the manual annotation is kept", expected: true, actual: stored.Annotations["manual"] != ""},
+		{name: "the stale pod annotation is removed",
+			actual: stored.Spec.Template.Annotations["operator-old"] != ""},
+		{name: "the manual pod annotation is kept", expected: true,
+			actual: stored.Spec.Template.Annotations["manual"] != ""},
+		{name: "the change cause is recorded", expected: true,
+			actual: stored.Annotations[changeCauseAnnotationKey] != ""},
+	}
+
+	containers := make(map[string][]string)
+	for _, container := range stored.Spec.Template.Spec.Containers {
+		for _, envVar := range container.Env {
+			containers[container.Name] = append(containers[container.Name], envVar.Name)
+		}
+		if _, ok := containers[container.Name]; !ok {
+			containers[container.Name] = nil
+		}
+	}
+
+	_, oldContainer := containers["operator-old"]
+	_, manualContainer := containers["manual"]
+
+	tests = append(tests, []struct {
+		name     string
+		expected bool
+		actual   bool
+	}{
+		{name: "the stale container is removed", actual: oldContainer},
+		{name: "the manual container is kept", expected: true, actual: manualContainer},
+		{name: "the stale environment variable is removed", actual: slices.Contains(containers[iag], "OPERATOR_OLD")},
+		{name: "the manual environment variable is kept", expected: true,
+			actual: slices.Contains(containers[iag], "MANUAL")},
+		{name: "the desired environment variable is kept", expected: true,
+			actual: slices.Contains(containers[iag], "LOG_LEVEL")},
+	}...)
+
+	for _, test := range tests {
+		if test.actual != test.expected {
+			t.Errorf("Expected that %s", test.name)
+		}
+	}
+
+	if !equality.Semantic.DeepEqual(dply.Spec.Template, stored.Spec.Template) {
+		t.Errorf("Expected the passed in deployment to be updated")
+	}
+	if desired.Annotations[changeCauseAnnotationKey] != stored.Annotations[changeCauseAnnotationKey] {
+		t.Errorf("Expected the change cause to be carried forward to the apply")
+	}
+}
@@ -0,0 +1,238 @@
+/*
+ * Copyright contributors to the IBM Application Gateway Operator project
+ */
+
+package controllers
+
+import (
+	"context"
+	goerrors "errors"
+	"net/http"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	corev1 "k8s.io/api/core/v1"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	ibmv1 "github.com/ibm-security/ibm-application-gateway-operator/api/v1"
+)
+
+// The finalizer which is added to a custom resource which has an OIDC
+// registration configuration source, so that the registered client can be
+// deleted from the OIDC provider when the custom resource is deleted.
+const oidcFinalizer = "ibm-application-gateway.operator.security.ibm.com/oidc-client"
+
+// The secret keys which contain the client configuration endpoint and the
+// registration access token which are returned by the OIDC provider when a
+// client is registered (RFC 7591).
+const (
+	registrationClientUriKey   = "registration_client_uri"
+	registrationAccessTokenKey = "registration_access_token"
+)
+
+// The annotation which records the name of the secret of the OIDC
+// registration configuration source of a custom resource.  This allows the
+// registered client to be deleted after the configuration source has been
+// removed from the custom resource, or its secret has been changed.
+const oidcSecretAnnotationKey = "ibm-application-gateway.operator.security.ibm.com/oidcSecret"
+
+/*
+ * Function returns the OIDC registration configuration source of the custom
+ * resource, or nil if there isn't one.
+ */
+func getOidcEntry(instance *ibmv1.IBMApplicationGateway) *ibmv1.IBMApplicationGatewayConfiguration {
+	for i := range instance.Spec.Configuration {
+		if instance.Spec.Configuration[i].Type == "oidc_registration" {
+			return &instance.Spec.Configuration[i]
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Function adds the finalizer to the custom resource if it has an OIDC
+ * registration configuration source, and removes it if it does not.  The
+ * secret of the configuration source is recorded in an annotation.  If the
+ * configuration source has been removed, or its secret has been changed, the
+ * client which was registered using the previous secret is deleted first.
+ * Nothing is changed while the custom resource is paused, as changes to a
+ * paused custom resource are only previewed.  The previous client is deleted
+ * when the custom resource is resumed.
+ */
+func ensureFinalizer(r *IBMApplicationGatewayReconciler, instance *ibmv1.IBMApplicationGateway) error {
+	if instance.Spec.Paused {
+		return nil
+	}
+
+	original := instance.DeepCopy()
+
+	secretName := ""
+	entry := getOidcEntry(instance)
+	if entry != nil {
+		secretName = entry.Secret
+	}
+
+	registered := instance.Annotations[oidcSecretAnnotationKey]
+	if registered != "" && registered != secretName {
+		err := deregisterOidcClient(r, instance.Namespace, registered)
+		if err != nil {
+			log.Error(err, "Failed to delete the OIDC client which was registered using the secret : "+registered)
+			return err
+		}
+	}
+
+	if entry != nil {
+		controllerutil.AddFinalizer(instance, oidcFinalizer)
+	} else {
+		controllerutil.RemoveFinalizer(instance, oidcFinalizer)
+	}
+
+	if secretName != "" {
+		instance.Annotations = mergeStringMaps(instance.Annotations, map[string]string{
+			oidcSecretAnnotationKey: secretName,
+		})
+	} else {
+		delete(instance.Annotations, oidcSecretAnnotationKey)
+	}
+
+	if equality.Semantic.DeepEqual(original.ObjectMeta, instance.ObjectMeta) {
+		return nil
+	}
+
+	return patchFinalizers(r, instance, original)
+}
+
+/*
+ * Function writes the finalizers and annotations of the custom resource to the
+ * API server.  Only the metadata is patched, as the in-memory specification
+ * includes the defaults of the class, which must not be written back.
+ */
+func patchFinalizers(r *IBMApplicationGatewayReconciler, instance *ibmv1.IBMApplicationGateway,
+	original *ibmv1.IBMApplicationGateway) error {
+
+	patched := instance.DeepCopy()
+
+	err := r.Client.Patch(context.TODO(), patched, client.MergeFrom(original))
+	if err != nil {
+		return err
+	}
+
+	instance.ResourceVersion = patched.ResourceVersion
+
+	return nil
+}
+
+/*
+ * Function handles the deletion of a custom resource.  Any OIDC client which
+ * was registered for the custom resource is deleted from the OIDC provider
+ * and the finalizer is then removed.
+ */
+func finalizeGateway(r *IBMApplicationGatewayReconciler, instance *ibmv1.IBMApplicationGateway) (ctrl.Result, error) {
+	reqLogger := log.WithValues("Request.Namespace", instance.Namespace, "Request.Name", instance.Name)
+
+	forgetWebSources(types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace})
+
+	if !controllerutil.ContainsFinalizer(instance, oidcFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	// The secret which was recorded when the finalizer was added is used in
+	// preference to the configuration source, as the source may have changed
+	secretName := instance.Annotations[oidcSecretAnnotationKey]
+	if entry := getOidcEntry(instance); secretName == "" && entry != nil {
+		secretName = entry.Secret
+	}
+
+	if secretName != "" {
+		err := deregisterOidcClient(r, instance.Namespace, secretName)
+		if err != nil {
+			// Try again later.  The finalizer will need to be removed
+			// manually if the client can never be deleted.
+			reqLogger.Error(err, "Failed to delete the registered OIDC client.")
+			r.EventRecorder.Event(instance, "Warning", "Failed", err.Error())
+			return ctrl.Result{}, err
+		}
+	}
+
+	original := instance.DeepCopy()
+	controllerutil.RemoveFinalizer(instance, oidcFinalizer)
+
+	return ctrl.Result{}, patchFinalizers(r, instance, original)
+}
+
+/*
+ * Function deletes the OIDC client which was registered using the passed in
+ * secret, using the client configuration endpoint (RFC 7592).  The client
+ * details are then removed from the secret.
+ */
+func deregisterOidcClient(r *IBMApplicationGatewayReconciler, ns string, secretName string) error {
+
+	reqLogger := log.WithName("deregisterOidcClient")
+	reqLogger.Info("Entry")
+
+	// Retrieve the secret
+	secret := &corev1.Secret{}
+	err := r.Client.Get(context.TODO(), types.NamespacedName{Name: secretName, Namespace: ns}, secret)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// Nothing can be done without the secret
+			reqLogger.Info("The OIDC registration secret no longer exists : " + secretName)
+			return nil
+		}
+		return err
+	}
+
+	clientUri := string(secret.Data[registrationClientUriKey])
+	accessToken := string(secret.Data[registrationAccessTokenKey])
+
+	if clientUri == "" || accessToken == "" {
+		// The OIDC provider did not return the details which are required
+		// to delete the client, or the client was registered by an earlier
+		// version of the operator.
+		reqLogger.Info("The registered OIDC client cannot be deleted as the client configuration endpoint is not known.")
+		return nil
+	}
+
+	// Has insecure been set
+	insecure := false
+	insTlsStr := string(secret.Data["insecureTLS"])
+	insTlsStr = strings.TrimSuffix(insTlsStr, "\n")
+	if strings.ToUpper(insTlsStr) == "TRUE" {
+		insecure = true
+		reqLogger.Info("Insecure TLS has been set to true")
+	}
+
+	// Delete the client.  A client which no longer exists has already been
+	// deleted.
+	_, err = doRequest(clientUri, "DELETE", nil, insecure, "", "", accessToken)
+	var statusErr *requestStatusError
+	if goerrors.As(err, &statusErr) &&
+		(statusErr.statusCode == http.StatusNotFound || statusErr.statusCode == http.StatusGone) {
+		reqLogger.Info("The registered OIDC client no longer exists.")
+	} else if err != nil {
+		return err
+	}
+
+	// Remove the client from the secret
+	delete(secret.Data, "client_id")
+	delete(secret.Data, "client_secret")
+	delete(secret.Data, registrationClientUriKey)
+	delete(secret.Data, registrationAccessTokenKey)
+
+	err = r.Client.Update(context.TODO(), secret)
+	if err != nil {
+		reqLogger.Error(err, "Failed to remove the client from the Kubernetes secret.")
+		return err
+	}
+
+	reqLogger.Info("Exit")
+
+	return nil
+}
@@ -0,0 +1,201 @@
+/*
+ * Copyright contributors to the IBM Application Gateway Operator project
+ */
+
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	corev1 "k8s.io/api/core/v1"
+
+	ibmv1 "github.com/ibm-security/ibm-application-gateway-operator/api/v1"
+)
+
+func TestRemovedOidcEntry(t *testing.T) {
+	deleted := 0
+
+	// The OIDC provider no longer knows about the client
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == "DELETE" && req.Header.Get("Authorization") == "Bearer registration-token" {
+			deleted++
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	r, instance := newRevisionTestReconciler(t)
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "oidc-client", Namespace: "default"},
+		Data: map[string][]byte{
+			"insecureTLS":              []byte("true"),
+			"client_id":                []byte("client"),
+			"client_secret":            []byte("secret"),
+			registrationClientUriKey:   []byte(server.URL + "/register/client"),
+			registrationAccessTokenKey: []byte("registration-token"),
+		},
+	}
+	if err := r.Client.Create(context.TODO(), secret); err != nil {
+		t.Fatal(err)
+	}
+
+	instance.Spec.Configuration = []ibmv1.IBMApplicationGatewayConfiguration{
+		{Type: "oidc_registration", Secret: "oidc-client"},
+	}
+
+	if err := ensureFinalizer(r, instance); err != nil {
+		t.Fatal(err)
+	}
+	if !controllerutil.ContainsFinalizer(instance, oidcFinalizer) ||
+		instance.Annotations[oidcSecretAnnotationKey] != "oidc-client" {
+		t.Fatalf("The finalizer and secret were not recorded : %v", instance.ObjectMeta)
+	}
+
+	// The client is deleted when the configuration source is removed
+	instance.Spec.Configuration = nil
+
+	if err := ensureFinalizer(r, instance); err != nil {
+		t.Fatal(err)
+	}
+
+	if deleted != 1 {
+		t.Errorf("Expected the client to be deleted but got %d requests", deleted)
+	}
+	if controllerutil.ContainsFinalizer(instance, oidcFinalizer) || instance.Annotations[oidcSecretAnnotationKey] != "" {
+		t.Errorf("The finalizer and secret were not removed : %v", instance.ObjectMeta)
+	}
+
+	if err := r.Client.Get(context.TODO(), types.NamespacedName{Name: "oidc-client", Namespace: "default"},
+		secret); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := secret.Data["client_id"]; ok {
+		t.Errorf("The client was not removed from the secret")
+	}
+}
+
+func TestPausedOidcEntry(t *testing.T) {
+	deleted := 0
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == "DELETE" {
+			deleted++
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	r, instance := newRevisionTestReconciler(t)
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "oidc-client", Namespace: "default"},
+		Data: map[string][]byte{
+			"insecureTLS":              []byte("true"),
+			"client_id":                []byte("client"),
+			registrationClientUriKey:   []byte(server.URL + "/register/client"),
+			registrationAccessTokenKey: []byte("registration-token"),
+		},
+	}
+	if err := r.Client.Create(context.TODO(), secret); err != nil {
+		t.Fatal(err)
+	}
+
+	instance.Spec.Configuration = []ibmv1.IBMApplicationGatewayConfiguration{
+		{Type: "oidc_registration", Secret: "oidc-client"},
+	}
+
+	if err := ensureFinalizer(r, instance); err != nil {
+		t.Fatal(err)
+	}
+
+	// The configuration source of the paused custom resource is changed
+	instance.Spec.Paused = true
+	instance.Spec.Configuration[0].Secret = "other-client"
+
+	if err := ensureFinalizer(r, instance); err != nil {
+		t.Fatal(err)
+	}
+
+	if deleted != 0 {
+		t.Errorf("Expected no client to be deleted but got %d requests", deleted)
+	}
+	if !controllerutil.ContainsFinalizer(instance, oidcFinalizer) ||
+		instance.Annotations[oidcSecretAnnotationKey] != "oidc-client" {
+		t.Errorf("The finalizer and secret were changed : %v", instance.ObjectMeta)
+	}
+
+	if err := r.Client.Get(context.TODO(), types.NamespacedName{Name: "oidc-client", Namespace: "default"},
+		secret); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := secret.Data["client_id"]; !ok {
+		t.Errorf("The client was removed from the secret")
+	}
+
+	// The previous client is deleted once the custom resource is resumed
+	instance.Spec.Paused = false
+
+	if err := ensureFinalizer(r, instance); err != nil {
+		t.Fatal(err)
+	}
+
+	if deleted != 1 {
+		t.Errorf("Expected the client to be deleted but got %d requests", deleted)
+	}
+}
+
+func TestFinalizeClassOidcEntry(t *testing.T) {
+	deleted := 0
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == "DELETE" {
+			deleted++
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	// The class which defined the OIDC registration has been deleted
+	now := metav1.Now()
+	instance := &ibmv1.IBMApplicationGateway{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "iag-instance",
+			Namespace:         "default",
+			DeletionTimestamp: &now,
+			Finalizers:        []string{oidcFinalizer},
+			Annotations:       map[string]string{oidcSecretAnnotationKey: "oidc-client"},
+		},
+		Spec: ibmv1.IBMApplicationGatewaySpec{ClassName: "standard"},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "oidc-client", Namespace: "default"},
+		Data: map[string][]byte{
+			"insecureTLS":              []byte("true"),
+			registrationClientUriKey:   []byte(server.URL + "/register/client"),
+			registrationAccessTokenKey: []byte("registration-token"),
+		},
+	}
+
+	rclient := newTestClient(t, instance, secret)
+	r := &IBMApplicationGatewayReconciler{Client: rclient, Scheme: rclient.Scheme()}
+
+	if _, err := finalizeGateway(r, instance); err != nil {
+		t.Fatal(err)
+	}
+
+	if deleted != 1 {
+		t.Errorf("Expected the client to be deleted but got %d requests", deleted)
+	}
+	if controllerutil.ContainsFinalizer(instance, oidcFinalizer) {
+		t.Errorf("The finalizer was not removed")
+	}
+}
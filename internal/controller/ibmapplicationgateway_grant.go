@@ -0,0 +1,127 @@
+/*
+ * Copyright contributors to the IBM Application Gateway Operator project
+ */
+
+package controllers
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	ibmv1 "github.com/ibm-security/ibm-application-gateway-operator/api/v1"
+)
+
+// The kind of configuration source which can be granted to other namespaces.
+const configGrantKindConfigMap = "ConfigMap"
+
+//+kubebuilder:rbac:groups=ibm.com,resources=ibmapplicationgatewayconfiggrants,verbs=get;list;watch
+
+/*
+ * Function returns true if the passed in grant allows the custom resources in
+ * the from namespace to read the passed in configuration source.
+ */
+func isConfigGrantMatch(grant *ibmv1.IBMApplicationGatewayConfigGrant, from string, to types.NamespacedName,
+	kind string) bool {
+
+	fromMatched := false
+	for _, grantFrom := range grant.Spec.From {
+		if grantFrom.Namespace == from || grantFrom.Namespace == ibmv1.ConfigGrantAllNamespaces {
+			fromMatched = true
+			break
+		}
+	}
+
+	if !fromMatched {
+		return false
+	}
+
+	for _, grantTo := range grant.Spec.To {
+		grantKind := grantTo.Kind
+		if grantKind == "" {
+			grantKind = configGrantKindConfigMap
+		}
+
+		if grantTo.Namespace == to.Namespace && grantKind == kind &&
+			(grantTo.Name == "" || grantTo.Name == to.Name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+ * Function returns true if the custom resources in the from namespace may
+ * read the passed in configuration source.  A configuration source in the
+ * same namespace may always be read, otherwise it must have been granted by
+ * an IBMApplicationGatewayConfigGrant.
+ */
+func isConfigGranted(rclient client.Reader, from string, to types.NamespacedName, kind string) (bool, error) {
+	if from == to.Namespace {
+		return true, nil
+	}
+
+	grants := &ibmv1.IBMApplicationGatewayConfigGrantList{}
+	if err := rclient.List(context.TODO(), grants); err != nil {
+		log.Error(err, "Failed to list the IBMApplicationGatewayConfigGrants.")
+		return false, err
+	}
+
+	for i := range grants.Items {
+		if isConfigGrantMatch(&grants.Items[i], from, to, kind) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+/*
+ * Function returns true if any of the configuration sources of a custom
+ * resource in the passed in namespace is in another namespace.
+ */
+func hasCrossNamespaceSource(entries []ibmv1.IBMApplicationGatewayConfiguration, namespace string) bool {
+	for _, entry := range entries {
+		if entry.Namespace != "" && entry.Namespace != namespace {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+ * Function returns a reconcile request for each custom resource which has a
+ * configuration source in another namespace, either directly or through its
+ * class.  It is called when a grant changes, as the change may grant, or
+ * revoke, access to the source.
+ */
+func (r *IBMApplicationGatewayReconciler) findGatewaysForGrant(ctx context.Context,
+	obj client.Object) []reconcile.Request {
+
+	instanceList := &ibmv1.IBMApplicationGatewayList{}
+	if err := r.Client.List(ctx, instanceList); err != nil {
+		log.Error(err, "Failed to find the custom objects which are affected by the grant : "+obj.GetName())
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, inst := range instanceList.Items {
+		if hasCrossNamespaceSource(inst.Spec.Configuration, inst.Namespace) {
+			log.V(1).Info("Handle grant change : " + obj.GetName() + " -> " + inst.Namespace + "/" + inst.Name)
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: inst.Name, Namespace: inst.Namespace},
+			})
+		}
+	}
+
+	requests = append(requests, r.findGatewaysForClassSources(ctx,
+		func(entries []ibmv1.IBMApplicationGatewayConfiguration, inst *ibmv1.IBMApplicationGateway) bool {
+			return hasCrossNamespaceSource(entries, inst.Namespace)
+		})...)
+
+	return requests
+}
@@ -0,0 +1,100 @@
+/*
+ * Copyright contributors to the IBM Application Gateway Operator project
+ */
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	corev1 "k8s.io/api/core/v1"
+
+	ibmv1 "github.com/ibm-security/ibm-application-gateway-operator/api/v1"
+)
+
+func TestCrossNamespaceConfigMapSource(t *testing.T) {
+	baseline := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "iag-baseline", Namespace: "iag-platform"},
+		Data:       map[string]string{"config": "version: \"23.04\"\n"},
+	}
+	other := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "iag-platform"},
+		Data:       map[string]string{"config": "version: \"22.07\"\n"},
+	}
+	grant := &ibmv1.IBMApplicationGatewayConfigGrant{
+		ObjectMeta: metav1.ObjectMeta{Name: "iag-baseline"},
+		Spec: ibmv1.IBMApplicationGatewayConfigGrantSpec{
+			From: []ibmv1.IBMApplicationGatewayConfigGrantFrom{{Namespace: "app-team-a"}},
+			To: []ibmv1.IBMApplicationGatewayConfigGrantTo{
+				{Namespace: "iag-platform", Name: "iag-baseline"},
+			},
+		},
+	}
+
+	rclient := newTestClient(t, baseline, other, grant)
+
+	tests := []struct {
+		name      string
+		namespace string
+		configMap string
+		expectErr bool
+	}{
+		{name: "a granted config map can be read", namespace: "app-team-a", configMap: "iag-baseline"},
+		{name: "another config map has not been granted", namespace: "app-team-a", configMap: "other", expectErr: true},
+		{name: "another namespace has not been granted", namespace: "app-team-b", configMap: "iag-baseline", expectErr: true},
+		{name: "a config map in the same namespace can be read", namespace: "iag-platform", configMap: "other"},
+	}
+
+	for _, test := range tests {
+		ctx := &sourceContext{
+			client: rclient,
+			owner:  types.NamespacedName{Name: "iag-instance", Namespace: test.namespace},
+		}
+
+		entry := ibmv1.IBMApplicationGatewayConfiguration{
+			Type: "configmap", Namespace: "iag-platform", Name: test.configMap, DataKey: "config",
+		}
+
+		_, err := configMapSource{}.Fetch(ctx, &entry)
+		if test.expectErr != (err != nil) {
+			t.Errorf("%s: unexpected result %v", test.name, err)
+		}
+	}
+
+	// A wildcard grants access to every namespace
+	grant.Spec.From = []ibmv1.IBMApplicationGatewayConfigGrantFrom{{Namespace: ibmv1.ConfigGrantAllNamespaces}}
+
+	to := types.NamespacedName{Name: "iag-baseline", Namespace: "iag-platform"}
+	if !isConfigGrantMatch(grant, "app-team-b", to, configGrantKindConfigMap) {
+		t.Errorf("Expected the wildcard grant to match")
+	}
+}
+
+func TestCrossNamespaceReferences(t *testing.T) {
+	entries := []ibmv1.IBMApplicationGatewayConfiguration{
+		{Type: "configmap", Namespace: "iag-platform", Name: "iag-baseline", DataKey: "config"},
+		{Type: "configmap", Namespace: "app-team-a", Name: "app-config", DataKey: "config"},
+		{Type: "configmap", Name: "local-config", DataKey: "config"},
+	}
+
+	expected := []string{"iag-platform/iag-baseline", "app-config", "local-config"}
+	if refs := getSourceReferences(entries, sourceReferenceConfigMap, "app-team-a"); !reflect.DeepEqual(refs, expected) {
+		t.Errorf("Expected %v but got %v", expected, refs)
+	}
+
+	// Only a configmap source may specify a namespace
+	entries = append(entries, ibmv1.IBMApplicationGatewayConfiguration{
+		Type: "secret", Namespace: "iag-platform", Name: "credentials", DataKey: "config",
+	})
+
+	allErrs := validateConfiguration(entries, field.NewPath("spec", "configuration"))
+	if len(allErrs) != 1 || allErrs[0].Field != "spec.configuration[3].namespace" {
+		t.Errorf("Expected an error for the secret namespace but got %v", allErrs)
+	}
+}
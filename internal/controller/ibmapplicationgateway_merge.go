@@ -0,0 +1,334 @@
+/*
+ * Copyright contributors to the IBM Application Gateway Operator project
+ */
+
+package controllers
+
+import (
+	"fmt"
+	"reflect"
+
+	ibmv1 "github.com/ibm-security/ibm-application-gateway-operator/api/v1"
+)
+
+// The strategies which may be used to merge the arrays of a configuration
+// source with the arrays of the earlier configuration sources.
+const (
+	mergeStrategyMerge      = "merge"
+	mergeStrategyReplace    = "replace"
+	mergeStrategyMergeByKey = "mergeByKey"
+)
+
+// The directive which may be used within a configuration source to control
+// how a single map or array is merged, along with the supported values.
+const (
+	patchDirectiveKey = "$patch"
+	patchReplace      = "replace"
+	patchDelete       = "delete"
+)
+
+// The options which control how a configuration source is merged.
+type mergeOptions struct {
+	strategy string
+	key      string
+
+	// The provenance report, if one is being recorded, and the source which
+	// is being merged.
+	provenance configProvenance
+	source     provenanceSource
+}
+
+// The options which are used if a configuration source does not specify a
+// merge strategy.
+var defaultMergeOptions = mergeOptions{strategy: mergeStrategyMerge}
+
+/*
+ * Function returns the merge options for the passed in configuration source.
+ */
+func getMergeOptions(entry *ibmv1.IBMApplicationGatewayConfiguration) mergeOptions {
+	if entry.MergeStrategy == "" {
+		return defaultMergeOptions
+	}
+
+	return mergeOptions{strategy: entry.MergeStrategy, key: entry.MergeKey}
+}
+
+/*
+ * Function returns a copy of the merge options which records the source of
+ * the merged values in the passed in provenance report.
+ */
+func (opts mergeOptions) withProvenance(provenance configProvenance, source provenanceSource) mergeOptions {
+	opts.provenance = provenance
+	opts.source = source
+
+	return opts
+}
+
+/*
+ * Function converts a parsed YAML value to the normalised tree which is used
+ * by the merge engine.  Every map in the tree has string keys, so a nested
+ * map is always a map[string]interface{}, no matter which parser produced it
+ * or how deep it is nested.  Arrays are []interface{} and all other values
+ * are left unchanged.
+ */
+func normaliseYaml(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		retVal := make(map[string]interface{}, len(v))
+		for key, entry := range v {
+			retVal[fmt.Sprint(key)] = normaliseYaml(entry)
+		}
+		return retVal
+	case map[string]interface{}:
+		retVal := make(map[string]interface{}, len(v))
+		for key, entry := range v {
+			retVal[key] = normaliseYaml(entry)
+		}
+		return retVal
+	case []interface{}:
+		retVal := make([]interface{}, len(v))
+		for idx, entry := range v {
+			retVal[idx] = normaliseYaml(entry)
+		}
+		return retVal
+	}
+
+	return value
+}
+
+/*
+ * Function returns the passed in value of the normalised tree as a map, if it
+ * is a map.
+ */
+func asStringMap(value interface{}) (map[string]interface{}, bool) {
+	v, ok := value.(map[string]interface{})
+
+	return v, ok
+}
+
+/*
+ * Function returns the value of the patch directive in the passed in map, or
+ * an empty string if there isn't one.
+ */
+func getPatchDirective(inputMap map[string]interface{}) string {
+	directive, _ := inputMap[patchDirectiveKey].(string)
+
+	return directive
+}
+
+/*
+ * Function returns a copy of the passed in map without the patch directive,
+ * along with any patch directives in the nested maps and arrays.
+ */
+func removePatchDirectives(inputMap map[string]interface{}, opts mergeOptions) map[string]interface{} {
+	return mergeMapsRecursive(make(map[string]interface{}), inputMap, opts)
+}
+
+/*
+ * Function returns the passed in value without any patch directives.
+ */
+func removePatchDirectivesFromValue(value interface{}, opts mergeOptions) interface{} {
+	if valueMap, ok := asStringMap(value); ok {
+		return removePatchDirectives(valueMap, opts)
+	}
+
+	if valueList, ok := value.([]interface{}); ok {
+		return mergeLists(nil, valueList, opts)
+	}
+
+	return value
+}
+
+/*
+ * Function merges to maps to a single map.  Both maps must be normalised
+ * trees (see normaliseYaml).
+ * Entries in the 2nd map will overwrite entries in the 1st map, nested maps
+ * are merged and arrays are merged using the passed in merge options.  A
+ * nested map in the 2nd map may contain a patch directive:
+ *   $patch: replace - the map replaces the map in the 1st map
+ *   $patch: delete  - the entry is removed from the 1st map
+ */
+func mergeMapsRecursive(inputMap1 map[string]interface{}, inputMap2 map[string]interface{},
+	opts mergeOptions) map[string]interface{} {
+
+	var retVal = inputMap1
+
+	for key, value := range inputMap2 {
+		if key == patchDirectiveKey {
+			continue
+		}
+
+		// Maps are merged, unless a directive says otherwise
+		if newMap, ok := asStringMap(value); ok {
+			switch getPatchDirective(newMap) {
+			case patchDelete:
+				delete(retVal, key)
+				continue
+			case patchReplace:
+				retVal[key] = removePatchDirectives(newMap, opts)
+				continue
+			}
+
+			if existingMap, ok := asStringMap(inputMap1[key]); ok {
+				retVal[key] = mergeMapsRecursive(existingMap, newMap, opts)
+			} else {
+				retVal[key] = removePatchDirectives(newMap, opts)
+			}
+
+			continue
+		}
+
+		// Arrays are merged using the merge strategy.  If the existing entry
+		// is not an array it is replaced.
+		if newList, ok := value.([]interface{}); ok {
+			existingList, _ := inputMap1[key].([]interface{})
+			retVal[key] = mergeLists(existingList, newList, opts)
+
+			continue
+		}
+
+		retVal[key] = value
+	}
+
+	return retVal
+}
+
+/*
+ * Function merges two arrays using the passed in merge options:
+ *   merge      - the new elements are added after the existing elements, even
+ *                if the same elements already exist
+ *   replace    - the new elements replace the existing elements
+ *   mergeByKey - a new element which has the same value for the merge key as
+ *                an existing element is merged with the existing element,
+ *                the other new elements are added after the existing elements
+ * An element of the new array may contain a patch directive:
+ *   $patch: replace - the new array replaces the existing array
+ *   $patch: delete  - existing elements which match the remaining entries of
+ *                     the element are removed
+ */
+func mergeLists(existingList []interface{}, newList []interface{}, opts mergeOptions) []interface{} {
+
+	strategy := opts.strategy
+
+	var deletes []map[string]interface{}
+	var additions []interface{}
+
+	// Handle the directives
+	for _, element := range newList {
+		if elementMap, ok := asStringMap(element); ok {
+			switch getPatchDirective(elementMap) {
+			case patchReplace:
+				strategy = mergeStrategyReplace
+				continue
+			case patchDelete:
+				deletes = append(deletes, removePatchDirectives(elementMap, opts))
+				continue
+			}
+		}
+
+		additions = append(additions, element)
+	}
+
+	// Start with the existing elements which have not been deleted
+	var retVal []interface{}
+
+	if strategy != mergeStrategyReplace {
+		for _, element := range existingList {
+			if !matchesAny(element, deletes) {
+				retVal = append(retVal, element)
+			}
+		}
+	}
+
+	// Add the new elements
+	for _, element := range additions {
+		if strategy == mergeStrategyMergeByKey {
+			if idx := findElementByKey(retVal, element, opts.key); idx >= 0 {
+				existingMap, _ := asStringMap(retVal[idx])
+				newMap, _ := asStringMap(element)
+
+				retVal[idx] = mergeMapsRecursive(existingMap, newMap, opts)
+				continue
+			}
+
+			// Don't duplicate simple values
+			if _, isMap := asStringMap(element); !isMap && containsElement(retVal, element) {
+				continue
+			}
+		}
+
+		retVal = append(retVal, removePatchDirectivesFromValue(element, opts))
+	}
+
+	return retVal
+}
+
+/*
+ * Function returns the index of the map in the array which has the same value
+ * for the key as the passed in element, or -1 if there isn't one.
+ */
+func findElementByKey(list []interface{}, element interface{}, key string) int {
+	elementMap, ok := asStringMap(element)
+	if !ok || key == "" {
+		return -1
+	}
+
+	keyValue, ok := elementMap[key]
+	if !ok {
+		return -1
+	}
+
+	for idx, candidate := range list {
+		if candidateMap, ok := asStringMap(candidate); ok {
+			if candidateValue, ok := candidateMap[key]; ok && reflect.DeepEqual(candidateValue, keyValue) {
+				return idx
+			}
+		}
+	}
+
+	return -1
+}
+
+/*
+ * Function returns true if the array contains the passed in element.
+ */
+func containsElement(list []interface{}, element interface{}) bool {
+	for _, candidate := range list {
+		if reflect.DeepEqual(candidate, element) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+ * Function returns true if the element is a map which contains all of the
+ * entries of one of the passed in delete directives.
+ */
+func matchesAny(element interface{}, deletes []map[string]interface{}) bool {
+	elementMap, ok := asStringMap(element)
+	if !ok {
+		return false
+	}
+
+	for _, directive := range deletes {
+		if len(directive) == 0 {
+			continue
+		}
+
+		matched := true
+		for key, value := range directive {
+			if !reflect.DeepEqual(elementMap[key], value) {
+				matched = false
+				break
+			}
+		}
+
+		if matched {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,284 @@
+/*
+ * Copyright contributors to the IBM Application Gateway Operator project
+ */
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+// A single configuration source which is merged by a merge test case.
+type mergeTestSource struct {
+	config string
+	opts   mergeOptions
+}
+
+/*
+ * Function merges the passed in configuration sources, in order, and returns
+ * the merged configuration.
+ */
+func mergeTestSources(t *testing.T, sources []mergeTestSource) map[string]interface{} {
+	t.Helper()
+
+	master := make(map[string]interface{})
+
+	for idx, source := range sources {
+		var err error
+
+		master, err = handleYamlDataMerge(source.config, master, source.opts)
+		if err != nil {
+			t.Fatalf("Failed to merge source %d: %v", idx, err)
+		}
+	}
+
+	return master
+}
+
+/*
+ * Function parses the passed in YAML document into a normalised tree.
+ */
+func parseTestYaml(t *testing.T, config string) map[string]interface{} {
+	t.Helper()
+
+	var parsed map[string]interface{}
+	if err := yaml.Unmarshal([]byte(config), &parsed); err != nil {
+		t.Fatalf("Failed to parse the expected configuration: %v", err)
+	}
+
+	normalised, _ := asStringMap(normaliseYaml(parsed))
+
+	return normalised
+}
+
+func TestMergeConfigurationSources(t *testing.T) {
+	byPath := mergeOptions{strategy: mergeStrategyMergeByKey, key: "path"}
+	replace := mergeOptions{strategy: mergeStrategyReplace}
+
+	tests := []struct {
+		name     string
+		sources  []mergeTestSource
+		expected string
+	}{
+		{
+			name: "scalar values are overridden by later sources",
+			sources: []mergeTestSource{
+				{config: "version: \"22.07\"\nserver:\n  worker_threads: 100\n", opts: defaultMergeOptions},
+				{config: "server:\n  worker_threads: 200\n", opts: defaultMergeOptions},
+			},
+			expected: "version: \"22.07\"\nserver:\n  worker_threads: 200\n",
+		},
+		{
+			name: "nested maps are merged across three sources",
+			sources: []mergeTestSource{
+				{config: "a:\n  b:\n    c:\n      one: 1\n", opts: defaultMergeOptions},
+				{config: "a:\n  b:\n    c:\n      two: 2\n", opts: defaultMergeOptions},
+				{config: "a:\n  b:\n    c:\n      three: 3\n    d: 4\n", opts: defaultMergeOptions},
+			},
+			expected: "a:\n  b:\n    c:\n      one: 1\n      two: 2\n      three: 3\n    d: 4\n",
+		},
+		{
+			name: "maps nested within arrays are normalised",
+			sources: []mergeTestSource{
+				{config: "a:\n- b:\n    c: 1\n", opts: defaultMergeOptions},
+				{config: "a:\n- b:\n    c: 2\n", opts: byPath},
+			},
+			expected: "a:\n- b:\n    c: 1\n- b:\n    c: 2\n",
+		},
+		{
+			name: "arrays are appended in source order",
+			sources: []mergeTestSource{
+				{config: "list: [a, b]\n", opts: defaultMergeOptions},
+				{config: "list: [c]\n", opts: defaultMergeOptions},
+				{config: "list: [d, e]\n", opts: defaultMergeOptions},
+			},
+			expected: "list: [a, b, c, d, e]\n",
+		},
+		{
+			name: "identical arrays are appended",
+			sources: []mergeTestSource{
+				{config: "list: [a, b]\n", opts: defaultMergeOptions},
+				{config: "list: [a, b]\n", opts: defaultMergeOptions},
+			},
+			expected: "list: [a, b, a, b]\n",
+		},
+		{
+			name: "partially overlapping arrays are appended",
+			sources: []mergeTestSource{
+				{config: "list: [a, b]\n", opts: defaultMergeOptions},
+				{config: "list: [b, c]\n", opts: defaultMergeOptions},
+			},
+			expected: "list: [a, b, b, c]\n",
+		},
+		{
+			name: "a scalar is replaced by a map",
+			sources: []mergeTestSource{
+				{config: "a: 1\n", opts: defaultMergeOptions},
+				{config: "a:\n  b: 2\n", opts: defaultMergeOptions},
+			},
+			expected: "a:\n  b: 2\n",
+		},
+		{
+			name: "the replace strategy replaces arrays",
+			sources: []mergeTestSource{
+				{config: "list: [a, b]\nother: [x]\n", opts: defaultMergeOptions},
+				{config: "list: [c]\n", opts: replace},
+			},
+			expected: "list: [c]\nother: [x]\n",
+		},
+		{
+			name: "the mergeByKey strategy merges matching elements",
+			sources: []mergeTestSource{
+				{config: "resource_servers:\n- path: /app\n  connection_type: tcp\n- path: /other\n", opts: defaultMergeOptions},
+				{config: "resource_servers:\n- path: /app\n  connection_type: ssl\n- path: /new\n", opts: byPath},
+			},
+			expected: "resource_servers:\n- path: /app\n  connection_type: ssl\n- path: /other\n- path: /new\n",
+		},
+		{
+			name: "the mergeByKey strategy does not duplicate scalars",
+			sources: []mergeTestSource{
+				{config: "list: [a, b]\n", opts: defaultMergeOptions},
+				{config: "list: [b, c]\n", opts: byPath},
+			},
+			expected: "list: [a, b, c]\n",
+		},
+		{
+			name: "a map replace directive replaces the map",
+			sources: []mergeTestSource{
+				{config: "a:\n  b: 1\n  c: 2\n", opts: defaultMergeOptions},
+				{config: "a:\n  $patch: replace\n  d: 3\n", opts: defaultMergeOptions},
+			},
+			expected: "a:\n  d: 3\n",
+		},
+		{
+			name: "a map delete directive removes the map",
+			sources: []mergeTestSource{
+				{config: "a:\n  b: 1\nc: 2\n", opts: defaultMergeOptions},
+				{config: "a:\n  $patch: delete\n", opts: defaultMergeOptions},
+			},
+			expected: "c: 2\n",
+		},
+		{
+			name: "an array replace directive replaces the array",
+			sources: []mergeTestSource{
+				{config: "list: [a, b]\n", opts: defaultMergeOptions},
+				{config: "list:\n- $patch: replace\n- c\n", opts: defaultMergeOptions},
+			},
+			expected: "list: [c]\n",
+		},
+		{
+			name: "an array delete directive removes matching elements",
+			sources: []mergeTestSource{
+				{config: "list:\n- path: /a\n- path: /b\n", opts: defaultMergeOptions},
+				{config: "list:\n- $patch: delete\n  path: /a\n- path: /c\n", opts: defaultMergeOptions},
+			},
+			expected: "list:\n- path: /b\n- path: /c\n",
+		},
+		{
+			name: "directives are removed from new entries",
+			sources: []mergeTestSource{
+				{config: "a: 1\n", opts: defaultMergeOptions},
+				{config: "b:\n  $patch: replace\n  c:\n    $patch: replace\n    d: 1\n", opts: defaultMergeOptions},
+			},
+			expected: "a: 1\nb:\n  c:\n    d: 1\n",
+		},
+		{
+			name: "a root replace directive replaces the configuration",
+			sources: []mergeTestSource{
+				{config: "a: 1\nb: 2\n", opts: defaultMergeOptions},
+				{config: "$patch: replace\nc: 3\n", opts: defaultMergeOptions},
+			},
+			expected: "c: 3\n",
+		},
+		{
+			name: "a root delete directive clears the configuration",
+			sources: []mergeTestSource{
+				{config: "a: 1\n", opts: defaultMergeOptions},
+				{config: "$patch: delete\n", opts: defaultMergeOptions},
+				{config: "b: 2\n", opts: defaultMergeOptions},
+			},
+			expected: "b: 2\n",
+		},
+		{
+			name: "an empty source does not change the configuration",
+			sources: []mergeTestSource{
+				{config: "a: 1\n", opts: defaultMergeOptions},
+				{config: "", opts: defaultMergeOptions},
+			},
+			expected: "a: 1\n",
+		},
+		{
+			name: "non-string keys are converted to strings",
+			sources: []mergeTestSource{
+				{config: "a:\n  1: one\n  true: yes\n", opts: defaultMergeOptions},
+				{config: "a:\n  \"1\": uno\n", opts: defaultMergeOptions},
+			},
+			expected: "a:\n  \"1\": uno\n  \"true\": yes\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			merged := mergeTestSources(t, test.sources)
+			expected := parseTestYaml(t, test.expected)
+
+			if !reflect.DeepEqual(merged, expected) {
+				mergedYaml, _ := yaml.Marshal(merged)
+				expectedYaml, _ := yaml.Marshal(expected)
+				t.Errorf("Unexpected merged configuration.\ngot:\n%s\nwant:\n%s", mergedYaml, expectedYaml)
+			}
+		})
+	}
+}
+
+func TestMergeOidcIdentity(t *testing.T) {
+	master := mergeTestSources(t, []mergeTestSource{
+		{config: "identity:\n  oidc:\n    discovery_endpoint: https://old/\n    client_id: old\n", opts: defaultMergeOptions},
+		{config: "identity:\n  oidc:\n    scopes: [openid]\n", opts: defaultMergeOptions},
+	})
+
+	// The OIDC identity of a merged configuration is always found, no matter
+	// which source it came from
+	identity, ok := asStringMap(master["identity"])
+	if !ok {
+		t.Fatalf("The identity entry is not a map: %T", master["identity"])
+	}
+
+	oidc, ok := asStringMap(identity["oidc"])
+	if !ok {
+		t.Fatalf("The oidc entry is not a map: %T", identity["oidc"])
+	}
+
+	oidc["client_id"] = "new"
+
+	expected := parseTestYaml(t, "identity:\n  oidc:\n    discovery_endpoint: https://old/\n"+
+		"    client_id: new\n    scopes: [openid]\n")
+
+	if !reflect.DeepEqual(master, expected) {
+		t.Errorf("Unexpected merged configuration: %v", master)
+	}
+}
+
+func TestMergedConfigurationIsDeterministic(t *testing.T) {
+	sources := []mergeTestSource{
+		{config: "zeta: 1\nalpha:\n  gamma: 2\n  beta: 3\nlist:\n- z: 1\n  a: 2\n", opts: defaultMergeOptions},
+		{config: "mid:\n  two: 1\n  one: 2\nalpha:\n  delta: 4\n", opts: defaultMergeOptions},
+	}
+
+	expected := "alpha:\n  beta: 3\n  delta: 4\n  gamma: 2\nlist:\n- a: 2\n  z: 1\nmid:\n  one: 2\n  two: 1\nzeta: 1\n"
+
+	// Map iteration order is random, so merge a number of times
+	for i := 0; i < 20; i++ {
+		merged, err := yaml.Marshal(mergeTestSources(t, sources))
+		if err != nil {
+			t.Fatalf("Failed to marshal the merged configuration: %v", err)
+		}
+
+		if string(merged) != expected {
+			t.Fatalf("Unexpected merged configuration.\ngot:\n%s\nwant:\n%s", merged, expected)
+		}
+	}
+}
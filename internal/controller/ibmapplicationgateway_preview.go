@@ -0,0 +1,260 @@
+/*
+ * Copyright contributors to the IBM Application Gateway Operator project
+ */
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"gopkg.in/yaml.v2"
+	"strconv"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	ibmv1 "github.com/ibm-security/ibm-application-gateway-operator/api/v1"
+)
+
+// The reason which is used for the paused condition.
+const reasonPaused = "RolloutPaused"
+
+// The file name which is used for the merged configuration in the diff.
+const previewFileName = "preview/" + configMapMasterKey
+
+// The value which replaces a secret value in the preview.
+const redactedValue = "<redacted>"
+
+// The types of configuration source whose values are redacted from the
+// preview, as they contain secret data.
+var secretSourceTypes = map[string]bool{
+	"secret":            true,
+	"oidc_registration": true,
+}
+
+// The fragments of the configuration keys whose values are always redacted
+// from the preview, no matter which configuration source set them.
+var sensitiveKeyFragments = []string{"secret", "password", "passphrase", "token"}
+
+/*
+ * Function handles a paused custom resource.  The configuration sources are
+ * merged, using the same code path as a rollout, and the result is saved in
+ * the status along with a unified diff from the deployed configuration.
+ * Nothing else is changed.
+ */
+func previewGateway(r *IBMApplicationGatewayReconciler, instance *ibmv1.IBMApplicationGateway,
+	request ctrl.Request, dply *appsv1.Deployment) (ctrl.Result, error) {
+	reqLogger := log.WithValues("Request.Namespace", request.Namespace, "Request.Name", request.Name)
+	reqLogger.Info("Previewing the IBMApplicationGateway configuration")
+
+	preview := &ibmv1.IBMApplicationGatewayConfigPreview{
+		ObservedGeneration: instance.Generation,
+	}
+
+	newData, provenance, err := getMergedConfig(r, instance, request, true)
+	if err == nil {
+		preview.Configuration, preview.Diff, err = getConfigPreview(r, instance, dply, newData, provenance)
+	}
+
+	if err != nil {
+		reqLogger.Error(err, "Failed to preview the merged config.")
+		r.EventRecorder.Event(instance, "Warning", "PreviewFailed", err.Error())
+
+		preview.Configuration = ""
+		preview.Diff = ""
+		preview.Error = err.Error()
+	}
+
+	instance.Status.Preview = preview
+
+	setCondition(instance, ibmv1.ConditionPaused, metav1.ConditionTrue, reasonPaused,
+		"The rollout of changes is paused.  The merged configuration is available in the preview.")
+
+	err = r.Client.Status().Update(context.TODO(), instance)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Keep the preview of any web configuration sources up to date
+	return ctrl.Result{RequeueAfter: getRefreshInterval(instance)}, nil
+}
+
+/*
+ * Function removes the preview from the status of a custom resource which is
+ * no longer paused.
+ */
+func clearPreview(instance *ibmv1.IBMApplicationGateway) {
+	instance.Status.Preview = nil
+	meta.RemoveStatusCondition(&instance.Status.Conditions, ibmv1.ConditionPaused)
+}
+
+/*
+ * Function returns the redacted merged configuration, along with a unified
+ * diff from the redacted configuration which is currently deployed, or an
+ * empty diff if they are the same.  A value is redacted if it was set by a
+ * configuration source which contains secret data, or by a template which
+ * read a secret, according to the provenance report of either configuration,
+ * or if its key is sensitive.  If
+ * either configuration is stored in a secret every value is redacted, and the
+ * merged configuration is not returned.
+ */
+func getConfigPreview(r *IBMApplicationGatewayReconciler, instance *ibmv1.IBMApplicationGateway,
+	dply *appsv1.Deployment, newData string, provenance configProvenance) (string, string, error) {
+
+	currentData := ""
+	fromFile := "/dev/null"
+
+	redacted := make(map[string]bool)
+	addSecretPointers(provenance, redacted)
+
+	redactAll := getConfigStorage(instance) == configStorageSecret
+
+	configMap, err := getCurrentConfigMap(r, instance, dply)
+	if err != nil && !errors.IsNotFound(err) {
+		return "", "", err
+	}
+
+	if err == nil && configMap != nil {
+		data := getConfigData(configMap)
+		currentData = data[configMapMasterKey]
+		fromFile = configMap.GetName() + "/" + configMapMasterKey
+
+		if getObjectConfigStorage(configMap) == configStorageSecret {
+			redactAll = true
+		}
+
+		// The deployed configuration only has a provenance report if one
+		// was requested
+		if data[configMapProvenanceKey] != "" {
+			current := make(configProvenance)
+			if err := json.Unmarshal([]byte(data[configMapProvenanceKey]), &current); err == nil {
+				addSecretPointers(current, redacted)
+			}
+		}
+	}
+
+	newData, err = redactConfig(newData, redacted, redactAll)
+	if err != nil {
+		return "", "", err
+	}
+
+	currentData, err = redactConfig(currentData, redacted, redactAll)
+	if err != nil {
+		return "", "", err
+	}
+
+	configuration := newData
+	if redactAll {
+		configuration = ""
+	}
+
+	if currentData == newData {
+		return configuration, "", nil
+	}
+
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(currentData),
+		B:        difflib.SplitLines(newData),
+		FromFile: fromFile,
+		ToFile:   previewFileName,
+		Context:  3,
+	})
+
+	return configuration, diff, err
+}
+
+/*
+ * Function adds the JSON pointer of each value of the provenance report which
+ * was set by a configuration source that contains secret data, or by a
+ * template which read a secret, to the passed in set.
+ */
+func addSecretPointers(provenance configProvenance, pointers map[string]bool) {
+	for pointer, source := range provenance {
+		if secretSourceTypes[source.Type] || source.SecretTemplate {
+			pointers[pointer] = true
+		}
+	}
+}
+
+/*
+ * Function returns true if the passed in configuration key names a value
+ * which is sensitive, such as a password.
+ */
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+
+	for _, fragment := range sensitiveKeyFragments {
+		if strings.Contains(key, fragment) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+ * Function returns the passed in YAML configuration with each value which has
+ * a pointer in the redacted set, or which is within a sensitive key, replaced.
+ * Every value is replaced if redactAll is true.
+ */
+func redactConfig(data string, redacted map[string]bool, redactAll bool) (string, error) {
+	if data == "" {
+		return "", nil
+	}
+
+	var config interface{}
+	if err := yaml.Unmarshal([]byte(data), &config); err != nil {
+		return "", err
+	}
+
+	configMap, ok := asStringMap(normaliseYaml(config))
+	if !ok {
+		return data, nil
+	}
+
+	for key, value := range configMap {
+		configMap[key] = redactValue(toJsonPointerSegment(key), value, redacted, redactAll || isSensitiveKey(key))
+	}
+
+	output, err := yaml.Marshal(configMap)
+	if err != nil {
+		return "", err
+	}
+
+	return string(output), nil
+}
+
+/*
+ * Function returns the passed in value of the normalised tree with each leaf
+ * which is to be redacted replaced.
+ */
+func redactValue(pointer string, value interface{}, redacted map[string]bool, sensitive bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, entry := range v {
+			v[key] = redactValue(pointer+toJsonPointerSegment(key), entry, redacted,
+				sensitive || isSensitiveKey(key))
+		}
+		return v
+
+	case []interface{}:
+		for idx, entry := range v {
+			v[idx] = redactValue(pointer+"/"+strconv.Itoa(idx), entry, redacted, sensitive)
+		}
+		return v
+	}
+
+	if sensitive || redacted[pointer] {
+		return redactedValue
+	}
+
+	return value
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright contributors to the IBM Application Gateway Operator project
+ */
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ibmv1 "github.com/ibm-security/ibm-application-gateway-operator/api/v1"
+)
+
+func TestConfigPreviewRedaction(t *testing.T) {
+	r, instance := newRevisionTestReconciler(t)
+
+	newData := "identity:\n  oidc:\n    client_id: client\n    client_secret: literal-secret\n" +
+		"resource_servers:\n- path: /app\n  servers:\n  - host: backend\n" +
+		"server:\n  worker_threads: 200\nversion: \"24.12\"\n"
+
+	provenance := configProvenance{
+		"/identity/oidc/client_id":           {Type: "oidc_registration", Name: "oidc-client", Index: 1},
+		"/identity/oidc/client_secret":       {Type: "literal", Index: 0},
+		"/resource_servers/0/path":           {Type: "literal", Index: 0},
+		"/resource_servers/0/servers/0/host": {Type: "secret", Name: "backends", Index: 2},
+		"/server/worker_threads":             {Type: "literal", Index: 0},
+		"/version":                           {Type: "literal", Index: 0},
+	}
+
+	configuration, diff, err := getConfigPreview(r, instance, &appsv1.Deployment{}, newData, provenance)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, secret := range []string{"client", "literal-secret", "backend"} {
+		if strings.Contains(configuration, ": "+secret+"\n") || strings.Contains(diff, ": "+secret+"\n") {
+			t.Errorf("The secret value %s was not redacted :\n%s\n%s", secret, configuration, diff)
+		}
+	}
+
+	for _, value := range []string{"path: /app", "worker_threads: 200"} {
+		if !strings.Contains(configuration, value) || !strings.Contains(diff, value) {
+			t.Errorf("The value %s was redacted :\n%s\n%s", value, configuration, diff)
+		}
+	}
+}
+
+func TestConfigPreviewSecretTemplate(t *testing.T) {
+	r, instance := newRevisionTestReconciler(t)
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "backends", Namespace: instance.Namespace},
+		Data:       map[string][]byte{"host": []byte("backend.internal")},
+	}
+	if err := r.Client.Create(context.TODO(), secret); err != nil {
+		t.Fatal(err)
+	}
+
+	// The secret value is rendered under a key which is not sensitive
+	instance.Spec.Configuration = []ibmv1.IBMApplicationGatewayConfiguration{
+		{Type: "literal", Value: "version: \"24.12\"\nserver:\n  worker_threads: 200\n"},
+		{Type: "literal", Template: true, Value: "resource_servers:\n- path: /app\n  servers:\n" +
+			"  - host: {{ secretKey \"backends\" \"host\" | quote }}\n"},
+	}
+
+	request := ctrl.Request{NamespacedName: types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}}
+
+	newData, provenance, err := getMergedConfig(r, instance, request, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configuration, diff, err := getConfigPreview(r, instance, &appsv1.Deployment{}, newData, provenance)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every value which was rendered by the template is redacted
+	if strings.Contains(configuration, "backend.internal") || strings.Contains(diff, "backend.internal") ||
+		strings.Contains(configuration, "/app") {
+		t.Errorf("The values of the template were not redacted :\n%s\n%s", configuration, diff)
+	}
+
+	if !strings.Contains(configuration, "worker_threads: 200") {
+		t.Errorf("The value of the literal source was redacted :\n%s", configuration)
+	}
+}
+
+func TestConfigPreviewSecretStorage(t *testing.T) {
+	r, instance := newRevisionTestReconciler(t)
+	instance.Spec.Deployment.ConfigStorage = configStorageSecret
+
+	newData := "server:\n  worker_threads: 200\nversion: \"24.12\"\n"
+
+	configuration, diff, err := getConfigPreview(r, instance, &appsv1.Deployment{}, newData, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if configuration != "" {
+		t.Errorf("Expected no configuration but got :\n%s", configuration)
+	}
+
+	// The structure of the change is shown, but none of the values
+	if !strings.Contains(diff, "+  worker_threads: "+redactedValue) || strings.Contains(diff, "200") ||
+		strings.Contains(diff, "24.12") {
+		t.Errorf("The values were not masked in the diff :\n%s", diff)
+	}
+}
@@ -0,0 +1,293 @@
+/*
+ * Copyright contributors to the IBM Application Gateway Operator project
+ */
+
+package controllers
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+
+	ibmv1 "github.com/ibm-security/ibm-application-gateway-operator/api/v1"
+)
+
+// The key of the generated config map which contains the provenance report
+// of the merged configuration.
+const configMapProvenanceKey = "provenance.json"
+
+// The configuration source which set a value of the merged configuration.
+type provenanceSource struct {
+	// The type of the configuration source, for example configmap.
+	Type string `json:"type"`
+
+	// The name of the config map or secret, the URL of the web source or the
+	// name of the OIDC registration secret.
+	Name string `json:"name,omitempty"`
+
+	// The ID of the configuration source, if it was defined by annotations.
+	Id string `json:"id,omitempty"`
+
+	// The index of the configuration source, in merge order.
+	Index int `json:"index"`
+
+	// Whether the configuration source is a template which read a secret,
+	// in which case every value which it set is treated as secret data.
+	SecretTemplate bool `json:"secretTemplate,omitempty"`
+}
+
+// The provenance report of a merged configuration, which maps the JSON
+// pointer of each leaf value to the configuration source which last set it.
+// A nil report is not recorded.
+type configProvenance map[string]provenanceSource
+
+// A leaf value of a configuration, along with its JSON pointer.
+type provenanceLeaf struct {
+	pointer string
+	value   interface{}
+}
+
+/*
+ * Function returns the provenance source for the passed in configuration
+ * source of the custom resource.
+ */
+func getProvenanceSource(idx int, entry *ibmv1.IBMApplicationGatewayConfiguration) provenanceSource {
+	source := provenanceSource{Type: entry.Type, Index: idx}
+
+	switch entry.Type {
+	case "configmap", "secret":
+		source.Name = entry.Name
+		if entry.Namespace != "" {
+			source.Name = entry.Namespace + "/" + entry.Name
+		}
+	case "web":
+		source.Name = entry.Url
+	case "oidc_registration":
+		source.Name = entry.Secret
+	}
+
+	return source
+}
+
+/*
+ * Function returns the leaf values of the passed in configuration, keyed by
+ * their identity.  Nothing is returned if the report is not recorded.
+ */
+func (p configProvenance) getLeaves(config map[string]interface{}, key string) map[string]provenanceLeaf {
+	if p == nil {
+		return nil
+	}
+
+	leaves := make(map[string]provenanceLeaf)
+	for name, value := range config {
+		segment := toJsonPointerSegment(name)
+		addIdentifiedLeaves(segment, segment, value, leaves, key)
+	}
+
+	return leaves
+}
+
+/*
+ * Function records the source of the leaf values of the passed in merged
+ * configuration.  A value is attributed to the source if the source added or
+ * changed the value, or if the value is set by the source fragment itself.
+ * Values which no longer exist are removed from the report.
+ */
+func (p configProvenance) record(opts mergeOptions, before map[string]provenanceLeaf,
+	merged map[string]interface{}, fragment map[string]interface{}) {
+
+	if p == nil {
+		return
+	}
+
+	after := p.getLeaves(merged, getProvenanceListKey(opts))
+
+	// The values which are set by the fragment, along with the arrays which
+	// are replaced by the fragment.  The elements of an array which is not
+	// replaced are attributed by value, as they are only set if they change.
+	set := make(map[string]interface{})
+	var replaced []string
+	if getPatchDirective(fragment) == patchReplace {
+		replaced = append(replaced, "")
+	}
+	for key, value := range fragment {
+		if key != patchDirectiveKey {
+			addFragmentLeaves(toJsonPointerSegment(key), value, set, &replaced, opts)
+		}
+	}
+
+	// The position of a value may have changed, so the report is rebuilt
+	previous := make(configProvenance, len(p))
+	for pointer, source := range p {
+		previous[pointer] = source
+		delete(p, pointer)
+	}
+
+	for identity, leaf := range after {
+		old, existed := before[identity]
+		source, found := previous[old.pointer]
+
+		if !existed || !found || isSetByFragment(identity, set, replaced) || !reflect.DeepEqual(old.value, leaf.value) {
+			source = opts.source
+		}
+
+		p[leaf.pointer] = source
+	}
+}
+
+/*
+ * Function returns the report as an indented JSON document, or an empty string
+ * if the report is not recorded.
+ */
+func (p configProvenance) toJson() (string, error) {
+	if p == nil {
+		return "", nil
+	}
+
+	// The keys of the map are sorted when it is marshalled
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+/*
+ * Function returns the merge key which identifies the array elements of a
+ * configuration, or an empty string if the elements are only identified by
+ * their content.
+ */
+func getProvenanceListKey(opts mergeOptions) string {
+	if opts.strategy != mergeStrategyMergeByKey {
+		return ""
+	}
+
+	return opts.key
+}
+
+/*
+ * Function returns the identity of an array element.  A map which has a value
+ * for the merge key is identified by that value, and any other element is
+ * identified by its content.
+ */
+func getElementIdentity(element interface{}, key string) string {
+	if elementMap, ok := asStringMap(element); ok && key != "" {
+		if keyValue, ok := elementMap[key]; ok {
+			data, _ := json.Marshal(keyValue)
+			return "[" + key + "=" + string(data) + "]"
+		}
+	}
+
+	data, _ := json.Marshal(element)
+
+	return "[" + string(data) + "]"
+}
+
+/*
+ * Function adds the leaf values of the passed in value of the normalised tree
+ * to the leaves map, keyed by their identity.  The identity is the JSON
+ * pointer of the value, with the position of each array element replaced by
+ * the identity of the element, so that a value keeps its identity when
+ * earlier elements are deleted.  Empty maps and arrays are leaf values.
+ */
+func addIdentifiedLeaves(identity string, pointer string, value interface{}, leaves map[string]provenanceLeaf,
+	key string) {
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		added := false
+		for name, entry := range v {
+			if name == patchDirectiveKey {
+				continue
+			}
+			segment := toJsonPointerSegment(name)
+			addIdentifiedLeaves(identity+segment, pointer+segment, entry, leaves, key)
+			added = true
+		}
+		if !added {
+			leaves[identity] = provenanceLeaf{pointer: pointer, value: v}
+		}
+
+	case []interface{}:
+		// Identical elements are numbered so that they remain distinct
+		seen := make(map[string]int)
+		for idx, entry := range v {
+			element := getElementIdentity(entry, key)
+			seen[element]++
+			if seen[element] > 1 {
+				element = element + "#" + strconv.Itoa(seen[element])
+			}
+			addIdentifiedLeaves(identity+toJsonPointerSegment(element), pointer+"/"+strconv.Itoa(idx), entry,
+				leaves, key)
+		}
+		if len(v) == 0 {
+			leaves[identity] = provenanceLeaf{pointer: pointer, value: v}
+		}
+
+	default:
+		leaves[identity] = provenanceLeaf{pointer: pointer, value: v}
+	}
+}
+
+/*
+ * Function adds the leaf values which are set by the passed in value of a
+ * fragment to the leaves map.  The elements of an array are not added, but the
+ * pointer of an array, or map, which is replaced by the fragment is added to
+ * the list of replaced values.
+ */
+func addFragmentLeaves(pointer string, value interface{}, leaves map[string]interface{}, replaced *[]string,
+	opts mergeOptions) {
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if getPatchDirective(v) == patchReplace {
+			*replaced = append(*replaced, pointer)
+		}
+
+		added := false
+		for key, entry := range v {
+			if key == patchDirectiveKey {
+				continue
+			}
+			addFragmentLeaves(pointer+toJsonPointerSegment(key), entry, leaves, replaced, opts)
+			added = true
+		}
+		if !added {
+			leaves[pointer] = v
+		}
+
+	case []interface{}:
+		isReplaced := opts.strategy == mergeStrategyReplace
+		for _, entry := range v {
+			if entryMap, ok := asStringMap(entry); ok && getPatchDirective(entryMap) == patchReplace {
+				isReplaced = true
+			}
+		}
+		if isReplaced {
+			*replaced = append(*replaced, pointer)
+		}
+
+	default:
+		leaves[pointer] = v
+	}
+}
+
+/*
+ * Function returns true if the leaf with the passed in identity is set by the
+ * fragment, or is within an array or map which is replaced by the fragment.
+ */
+func isSetByFragment(identity string, set map[string]interface{}, replaced []string) bool {
+	if _, ok := set[identity]; ok {
+		return true
+	}
+
+	for _, prefix := range replaced {
+		if identity == prefix || strings.HasPrefix(identity, prefix+"/") {
+			return true
+		}
+	}
+
+	return false
+}
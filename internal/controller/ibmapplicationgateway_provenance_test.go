@@ -0,0 +1,149 @@
+/*
+ * Copyright contributors to the IBM Application Gateway Operator project
+ */
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConfigProvenance(t *testing.T) {
+	base := provenanceSource{Type: "configmap", Name: "base", Index: 0}
+	override := provenanceSource{Type: "literal", Index: 1}
+
+	tests := []struct {
+		name     string
+		base     string
+		override string
+		opts     mergeOptions
+		expected configProvenance
+	}{
+		{
+			name:     "values are attributed to the source which set them",
+			base:     "version: \"22.07\"\nserver:\n  worker_threads: 100\n  port: 8443\n",
+			override: "server:\n  worker_threads: 200\n",
+			opts:     defaultMergeOptions,
+			expected: configProvenance{
+				"/version":               base,
+				"/server/worker_threads": override,
+				"/server/port":           base,
+			},
+		},
+		{
+			name:     "a value which is set again is attributed to the later source",
+			base:     "server:\n  port: 8443\n",
+			override: "server:\n  port: 8443\n",
+			opts:     defaultMergeOptions,
+			expected: configProvenance{
+				"/server/port": override,
+			},
+		},
+		{
+			name:     "appended array elements are attributed to the later source",
+			base:     "resource_servers:\n- path: /app\n",
+			override: "resource_servers:\n- path: /other\n",
+			opts:     defaultMergeOptions,
+			expected: configProvenance{
+				"/resource_servers/0/path": base,
+				"/resource_servers/1/path": override,
+			},
+		},
+		{
+			name:     "merged array elements are attributed by value",
+			base:     "resource_servers:\n- path: /app\n  stateful: false\n  connection_type: tcp\n",
+			override: "resource_servers:\n- path: /app\n  stateful: true\n",
+			opts:     mergeOptions{strategy: mergeStrategyMergeByKey, key: "path"},
+			expected: configProvenance{
+				"/resource_servers/0/path":            base,
+				"/resource_servers/0/stateful":        override,
+				"/resource_servers/0/connection_type": base,
+			},
+		},
+		{
+			name:     "array elements keep their source when an earlier element is deleted",
+			base:     "resource_servers:\n- path: /app\n- path: /other\n  stateful: true\n",
+			override: "resource_servers:\n- path: /app\n  $patch: delete\n",
+			opts:     mergeOptions{strategy: mergeStrategyMergeByKey, key: "path"},
+			expected: configProvenance{
+				"/resource_servers/0/path":     base,
+				"/resource_servers/0/stateful": base,
+			},
+		},
+		{
+			name:     "merged array elements are attributed by key after a delete",
+			base:     "resource_servers:\n- path: /app\n- path: /other\n  stateful: false\n",
+			override: "resource_servers:\n- path: /app\n  $patch: delete\n- path: /other\n  stateful: true\n",
+			opts:     mergeOptions{strategy: mergeStrategyMergeByKey, key: "path"},
+			expected: configProvenance{
+				"/resource_servers/0/path":     base,
+				"/resource_servers/0/stateful": override,
+			},
+		},
+		{
+			name:     "replaced array elements are attributed to the later source",
+			base:     "resource_servers:\n- path: /app\n- path: /other\n",
+			override: "resource_servers:\n- path: /other\n",
+			opts:     mergeOptions{strategy: mergeStrategyReplace},
+			expected: configProvenance{
+				"/resource_servers/0/path": override,
+			},
+		},
+		{
+			name:     "deleted values are removed",
+			base:     "logging:\n  json_logging: true\nversion: \"22.07\"\n",
+			override: "logging:\n  $patch: delete\n",
+			opts:     defaultMergeOptions,
+			expected: configProvenance{
+				"/version": base,
+			},
+		},
+		{
+			name:     "keys are escaped",
+			base:     "a/b:\n  c~d: 1\n",
+			override: "e: []\n",
+			opts:     defaultMergeOptions,
+			expected: configProvenance{
+				"/a~1b/c~0d": base,
+				"/e":         override,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provenance := make(configProvenance)
+
+			master, err := handleYamlDataMerge(test.base, make(map[string]interface{}),
+				defaultMergeOptions.withProvenance(provenance, base))
+			if err != nil {
+				t.Fatalf("Failed to merge the base source: %v", err)
+			}
+
+			_, err = handleYamlDataMerge(test.override, master, test.opts.withProvenance(provenance, override))
+			if err != nil {
+				t.Fatalf("Failed to merge the override source: %v", err)
+			}
+
+			if !reflect.DeepEqual(provenance, test.expected) {
+				t.Errorf("Unexpected provenance report.\ngot:  %v\nwant: %v", provenance, test.expected)
+			}
+		})
+	}
+}
+
+func TestConfigProvenanceNotRecorded(t *testing.T) {
+	var provenance configProvenance
+
+	_, err := handleYamlDataMerge("version: \"22.07\"\n", make(map[string]interface{}),
+		defaultMergeOptions.withProvenance(provenance, provenanceSource{Type: "literal"}))
+	if err != nil {
+		t.Fatalf("Failed to merge the source: %v", err)
+	}
+
+	data, err := provenance.toJson()
+	if err != nil || data != "" {
+		t.Errorf("Expected no provenance report but got %q, %v", data, err)
+	}
+}
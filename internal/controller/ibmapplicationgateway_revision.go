@@ -0,0 +1,447 @@
+/*
+ * Copyright contributors to the IBM Application Gateway Operator project
+ */
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ibmv1 "github.com/ibm-security/ibm-application-gateway-operator/api/v1"
+)
+
+// The annotations of a generated config map which contain the revision number
+// and the hash of the generated configuration.
+const (
+	configRevisionAnnotationKey = "ibm-application-gateway.operator.security.ibm.com/configRevision"
+	configHashAnnotationKey     = "ibm-application-gateway.operator.security.ibm.com/configHash"
+)
+
+// The pod template label which contains the revision number of the deployed
+// configuration.
+const configRevisionLabelKey = "ibm-application-gateway.operator.security.ibm.com/configRevision"
+
+// The number of old configuration revisions which are retained if the custom
+// resource does not specify a limit.
+const defaultRevisionHistoryLimit = 10
+
+// The number of characters of the configuration hash which are used in the
+// name of a generated config map, and as the configuration version.  The
+// version is used as a label value, which cannot exceed 63 characters.
+const (
+	configMapHashLength = 10
+	configVersionLength = 32
+)
+
+// The maximum length of the name of a generated config map, which is also used
+// as a label value.
+const maxConfigMapNameLength = 63
+
+/*
+ * Function returns the SHA-256 hash of the data of a generated config map.
+ */
+func getConfigHash(data map[string]string) string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	hash := sha256.New()
+	for _, key := range keys {
+		hash.Write([]byte(key))
+		hash.Write([]byte{0})
+		hash.Write([]byte(data[key]))
+		hash.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+/*
+ * Function returns the name of the generated config map which contains the
+ * configuration with the passed in hash.  The name is derived from the
+ * content, so the same configuration always has the same name.  The base name
+ * is truncated, and any trailing characters which are not alphanumeric are
+ * removed, so that the name is always a valid DNS-1123 label.
+ */
+func getConfigRevisionName(instance *ibmv1.IBMApplicationGateway, hash string) string {
+	suffix := hash[:configMapHashLength]
+	base := getConfigMapName(instance)
+
+	if len(base) > maxConfigMapNameLength-len(suffix)-1 {
+		base = base[:maxConfigMapNameLength-len(suffix)-1]
+	}
+
+	base = strings.TrimRightFunc(base, func(r rune) bool {
+		return !unicode.IsDigit(r) && !unicode.IsLower(r)
+	})
+
+	if base == "" {
+		return suffix
+	}
+
+	return base + "-" + suffix
+}
+
+/*
+ * Function returns the version of the configuration in a generated config map
+ * or secret, which is derived from the SHA-256 hash of its content.  Unlike
+ * the resource version of the object the version only changes if the content
+ * changes.
+ */
+func getConfigMapVersion(configMap client.Object) string {
+	hash := configMap.GetAnnotations()[configHashAnnotationKey]
+	if len(hash) < configVersionLength {
+		hash = getConfigHash(getConfigData(configMap))
+	}
+
+	return hash[:configVersionLength]
+}
+
+/*
+ * Function returns the revision number of a generated config map or secret,
+ * or 0 if the object was generated before revisions were introduced.
+ */
+func getConfigRevisionNumber(configMap metav1.Object) int64 {
+	revision, err := strconv.ParseInt(configMap.GetAnnotations()[configRevisionAnnotationKey], 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return revision
+}
+
+/*
+ * Function returns the number of old configuration revisions which are
+ * retained for the custom resource.
+ */
+func getRevisionHistoryLimit(instance *ibmv1.IBMApplicationGateway) int {
+	if instance.Spec.RevisionHistoryLimit == nil || *instance.Spec.RevisionHistoryLimit < 0 {
+		return defaultRevisionHistoryLimit
+	}
+
+	return int(*instance.Spec.RevisionHistoryLimit)
+}
+
+/*
+ * Function returns the generated config maps and secrets which are owned by
+ * the custom resource.  The revisions are returned oldest first, and any
+ * config maps which were generated before revisions were introduced are
+ * returned separately.  The API server is read directly, rather than the
+ * cache, so that a revision which was only just created is not created again.
+ */
+func listConfigRevisions(r *IBMApplicationGatewayReconciler, instance *ibmv1.IBMApplicationGateway) (
+	[]client.Object, []client.Object, error) {
+
+	reader := client.Reader(r.Client)
+	if r.APIReader != nil {
+		reader = r.APIReader
+	}
+
+	opts := []client.ListOption{
+		client.InNamespace(instance.Namespace),
+		client.MatchingLabels{"app": instance.Name},
+	}
+
+	configMaps := &corev1.ConfigMapList{}
+	err := reader.List(context.TODO(), configMaps, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	secrets := &corev1.SecretList{}
+	err = reader.List(context.TODO(), secrets, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var objects []client.Object
+	for i := range configMaps.Items {
+		objects = append(objects, &configMaps.Items[i])
+	}
+	for i := range secrets.Items {
+		objects = append(objects, &secrets.Items[i])
+	}
+
+	var revisions []client.Object
+	var legacy []client.Object
+
+	for _, object := range objects {
+		if !metav1.IsControlledBy(object, instance) {
+			continue
+		}
+
+		if getConfigRevisionNumber(object) > 0 {
+			revisions = append(revisions, object)
+		} else if getObjectConfigStorage(object) == configStorageConfigMap {
+			// Secrets were never generated before revisions
+			legacy = append(legacy, object)
+		}
+	}
+
+	sortConfigRevisions(revisions)
+
+	return revisions, legacy, nil
+}
+
+/*
+ * Function sorts the passed in revisions, oldest first.
+ */
+func sortConfigRevisions(revisions []client.Object) {
+	sort.Slice(revisions, func(i, j int) bool {
+		return getConfigRevisionNumber(revisions[i]) < getConfigRevisionNumber(revisions[j])
+	})
+}
+
+/*
+ * Function returns a copy of the revision with the passed in number, or nil if
+ * the revision does not exist.
+ */
+func findConfigRevision(revisions []client.Object, revision int64) client.Object {
+	for i := range revisions {
+		if getConfigRevisionNumber(revisions[i]) == revision {
+			return revisions[i].DeepCopyObject().(client.Object)
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Function returns the generated config map, or secret, which contains the
+ * configuration that should be deployed, creating a new revision if the merged
+ * configuration has changed.  If the merged configuration has already failed to become ready
+ * the last healthy revision is deployed instead.  Old revisions which are no
+ * longer required are deleted and the remaining revisions are recorded in the
+ * status of the custom resource.
+ */
+func reconcileConfigRevision(r *IBMApplicationGatewayReconciler, instance *ibmv1.IBMApplicationGateway,
+	data map[string]string, inUse string) (client.Object, error) {
+
+	reqLogger := log.WithValues("Request.Namespace", instance.Namespace, "Request.Name", instance.Name)
+
+	revisions, legacy, err := listConfigRevisions(r, instance)
+	if err != nil {
+		return nil, err
+	}
+
+	var latest int64
+	if len(revisions) > 0 {
+		latest = getConfigRevisionNumber(revisions[len(revisions)-1])
+	}
+
+	var selected client.Object
+
+	// The last healthy revision is never deleted, so that it can be rolled
+	// back to
+	healthy := findConfigRevision(revisions, instance.Status.HealthyConfigRevision)
+
+	if pinned := instance.Spec.ConfigurationRevision; pinned > 0 {
+		// The configuration has been pinned to an existing revision
+		selected = findConfigRevision(revisions, pinned)
+
+		if selected == nil {
+			return nil, fmt.Errorf("The configuration revision %d does not exist.", pinned)
+		}
+	} else if getConfigHash(data) == instance.Status.FailedConfigHash && healthy != nil {
+		// The merged configuration has not changed since it failed to become
+		// ready, so keep the last healthy revision
+		selected = healthy
+	} else {
+		hash := getConfigHash(data)
+		storage := getConfigStorage(instance)
+
+		// The merged configuration has changed since the last failure
+		if instance.Status.FailedConfigHash != "" && hash != instance.Status.FailedConfigHash {
+			instance.Status.FailedConfigRevision = 0
+			instance.Status.FailedConfigHash = ""
+		}
+
+		// Find an existing revision with the same configuration, which is
+		// stored in the same way.  If it is not the latest revision it
+		// becomes the latest revision again.
+		idx := -1
+		for i := range revisions {
+			if revisions[i].GetAnnotations()[configHashAnnotationKey] == hash &&
+				getObjectConfigStorage(revisions[i]) == storage {
+				idx = i
+			}
+		}
+
+		if idx >= 0 {
+			selected = revisions[idx].DeepCopyObject().(client.Object)
+		}
+
+		if selected != nil && getConfigRevisionNumber(selected) != latest {
+			reqLogger.Info(fmt.Sprintf("Config has changed back to revision %d.", getConfigRevisionNumber(selected)))
+
+			// Only the data of an immutable config map cannot be changed
+			selected.GetAnnotations()[configRevisionAnnotationKey] = strconv.FormatInt(latest+1, 10)
+			err = r.Client.Update(context.TODO(), selected)
+			if err != nil {
+				return nil, err
+			}
+			revisions[idx] = selected.DeepCopyObject().(client.Object)
+		} else if selected == nil {
+			reqLogger.Info(fmt.Sprintf("Config has changed so create configuration revision %d.", latest+1))
+
+			selected, err = createConfigRevision(r, instance, data, hash, latest+1)
+			if err != nil {
+				return nil, err
+			}
+			revisions = append(revisions, selected.DeepCopyObject().(client.Object))
+		}
+
+		sortConfigRevisions(revisions)
+	}
+
+	keep := map[string]bool{selected.GetName(): true, inUse: true}
+	if healthy != nil {
+		keep[healthy.GetName()] = true
+	}
+
+	revisions, err = pruneConfigRevisions(r, instance, revisions, legacy, keep)
+	if err != nil {
+		return nil, err
+	}
+
+	recordConfigRevisions(instance, revisions, selected)
+
+	return selected, nil
+}
+
+/*
+ * Function creates a new immutable config map, or secret, which contains a
+ * revision of the generated configuration.
+ */
+func createConfigRevision(r *IBMApplicationGatewayReconciler, instance *ibmv1.IBMApplicationGateway,
+	data map[string]string, hash string, revision int64) (client.Object, error) {
+
+	configMap := newConfigMap(instance, data[configMapMasterKey], data[configMapProvenanceKey])
+
+	// The config map is named after its content, rather than being given a
+	// generated name
+	configMap.GenerateName = ""
+	configMap.Name = getConfigRevisionName(instance, hash)
+
+	immutable := true
+	configMap.Immutable = &immutable
+	configMap.Annotations = map[string]string{
+		configRevisionAnnotationKey: strconv.FormatInt(revision, 10),
+		configHashAnnotationKey:     hash,
+	}
+
+	var object client.Object = configMap
+	if getConfigStorage(instance) == configStorageSecret {
+		object = newConfigSecret(configMap)
+	}
+
+	// Set Presentation instance as the owner and controller
+	err := controllerutil.SetControllerReference(instance, object, r.Scheme)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.Client.Create(context.TODO(), object)
+	if err != nil {
+		return nil, err
+	}
+
+	return object, nil
+}
+
+/*
+ * Function deletes the oldest configuration revisions which exceed the
+ * revision history limit, along with any config maps which were generated
+ * before revisions were introduced.  The objects which are named in keep,
+ * such as the selected revision and the revision which is used by the
+ * current deployment, are never deleted.  The remaining revisions are
+ * returned.
+ */
+func pruneConfigRevisions(r *IBMApplicationGatewayReconciler, instance *ibmv1.IBMApplicationGateway,
+	revisions []client.Object, legacy []client.Object, keep map[string]bool) (
+	[]client.Object, error) {
+
+	reqLogger := log.WithValues("Request.Namespace", instance.Namespace, "Request.Name", instance.Name)
+
+	deleteConfigMap := func(configMap client.Object) error {
+		reqLogger.Info(fmt.Sprintf("Deleting the old generated %s %s",
+			getObjectConfigStorage(configMap), configMap.GetName()))
+
+		err := r.Client.Delete(context.TODO(), configMap)
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+
+		return nil
+	}
+
+	for i := range legacy {
+		if !keep[legacy[i].GetName()] {
+			if err := deleteConfigMap(legacy[i]); err != nil {
+				return revisions, err
+			}
+		}
+	}
+
+	// The number of old revisions which may be deleted
+	excess := -getRevisionHistoryLimit(instance)
+	for i := range revisions {
+		if !keep[revisions[i].GetName()] {
+			excess++
+		}
+	}
+
+	var retained []client.Object
+
+	for i := range revisions {
+		if excess > 0 && !keep[revisions[i].GetName()] {
+			if err := deleteConfigMap(revisions[i]); err != nil {
+				return revisions, err
+			}
+			excess--
+			continue
+		}
+
+		retained = append(retained, revisions[i])
+	}
+
+	return retained, nil
+}
+
+/*
+ * Function records the configuration revisions in the status of the custom
+ * resource.
+ */
+func recordConfigRevisions(instance *ibmv1.IBMApplicationGateway, revisions []client.Object,
+	selected client.Object) {
+
+	instance.Status.ConfigRevision = getConfigRevisionNumber(selected)
+	instance.Status.ConfigRevisions = nil
+
+	for i := range revisions {
+		instance.Status.ConfigRevisions = append(instance.Status.ConfigRevisions,
+			ibmv1.IBMApplicationGatewayConfigRevision{
+				Revision:          getConfigRevisionNumber(revisions[i]),
+				ConfigMapName:     revisions[i].GetName(),
+				Storage:           getObjectConfigStorage(revisions[i]),
+				Hash:              revisions[i].GetAnnotations()[configHashAnnotationKey],
+				CreationTimestamp: revisions[i].GetCreationTimestamp(),
+			})
+	}
+}
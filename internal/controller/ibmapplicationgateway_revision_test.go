@@ -0,0 +1,373 @@
+/*
+ * Copyright contributors to the IBM Application Gateway Operator project
+ */
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/record"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	ibmv1 "github.com/ibm-security/ibm-application-gateway-operator/api/v1"
+)
+
+/*
+ * Function returns a reconciler which uses a fake client, along with a custom
+ * resource which has been created using the client.
+ */
+func newRevisionTestReconciler(t *testing.T) (*IBMApplicationGatewayReconciler, *ibmv1.IBMApplicationGateway) {
+	t.Helper()
+
+	instance := &ibmv1.IBMApplicationGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "iag-instance", Namespace: "default", UID: "1234"},
+	}
+
+	rclient := newTestClient(t, instance)
+
+	r := &IBMApplicationGatewayReconciler{
+		Client: rclient,
+		Scheme: rclient.Scheme(),
+	}
+
+	return r, instance
+}
+
+func TestReconcileConfigRevision(t *testing.T) {
+	r, instance := newRevisionTestReconciler(t)
+
+	limit := int32(1)
+	instance.Spec.RevisionHistoryLimit = &limit
+
+	steps := []struct {
+		name      string
+		config    string
+		pinned    int64
+		revision  int64
+		retained  []int64
+		expectErr bool
+	}{
+		{name: "the first configuration is revision 1", config: "a: 1\n", revision: 1, retained: []int64{1}},
+		{name: "the same configuration is not a new revision", config: "a: 1\n", revision: 1, retained: []int64{1}},
+		{name: "a changed configuration is a new revision", config: "a: 2\n", revision: 2, retained: []int64{1, 2}},
+		{name: "old revisions beyond the limit are deleted", config: "a: 3\n", revision: 3, retained: []int64{2, 3}},
+		{name: "an old configuration becomes the latest revision", config: "a: 2\n", revision: 4, retained: []int64{3, 4}},
+		{name: "a revision can be pinned", pinned: 3, revision: 3, retained: []int64{3, 4}},
+		{name: "an unknown revision cannot be pinned", pinned: 1, expectErr: true},
+	}
+
+	for _, step := range steps {
+		instance.Spec.ConfigurationRevision = step.pinned
+
+		var data map[string]string
+		if step.config != "" {
+			data = map[string]string{configMapMasterKey: step.config}
+		}
+
+		configMap, err := reconcileConfigRevision(r, instance, data, "")
+		if step.expectErr {
+			if err == nil {
+				t.Fatalf("%s: expected an error", step.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+
+		if revision := getConfigRevisionNumber(configMap); revision != step.revision {
+			t.Errorf("%s: expected revision %d but got %d", step.name, step.revision, revision)
+		}
+		if step.config != "" && getConfigData(configMap)[configMapMasterKey] != step.config {
+			t.Errorf("%s: unexpected configuration %q", step.name, getConfigData(configMap)[configMapMasterKey])
+		}
+		if immutable := configMap.(*corev1.ConfigMap).Immutable; immutable == nil || !*immutable {
+			t.Errorf("%s: the config map is not immutable", step.name)
+		}
+		if hash := getConfigHash(getConfigData(configMap)); configMap.GetName() != getConfigRevisionName(instance, hash) {
+			t.Errorf("%s: the config map %s is not named after its content", step.name, configMap.GetName())
+		}
+
+		var retained []int64
+		for _, revision := range instance.Status.ConfigRevisions {
+			retained = append(retained, revision.Revision)
+		}
+		if len(retained) != len(step.retained) {
+			t.Fatalf("%s: expected revisions %v but got %v", step.name, step.retained, retained)
+		}
+		for i := range retained {
+			if retained[i] != step.retained[i] {
+				t.Fatalf("%s: expected revisions %v but got %v", step.name, step.retained, retained)
+			}
+		}
+
+		// The status must match the config maps which actually exist
+		configMaps := &corev1.ConfigMapList{}
+		if err := r.Client.List(context.TODO(), configMaps); err != nil {
+			t.Fatal(err)
+		}
+		if len(configMaps.Items) != len(step.retained) {
+			t.Errorf("%s: expected %d config maps but found %d", step.name, len(step.retained), len(configMaps.Items))
+		}
+	}
+}
+
+func TestConfigMapVersion(t *testing.T) {
+	r, instance := newRevisionTestReconciler(t)
+
+	first, err := reconcileConfigRevision(r, instance, map[string]string{configMapMasterKey: "a: 1\n"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Changing the metadata of the config map does not change its version
+	version := getConfigMapVersion(first)
+	first.GetLabels()["other"] = "value"
+	if err := r.Client.Update(context.TODO(), first); err != nil {
+		t.Fatal(err)
+	}
+	if getConfigMapVersion(first) != version {
+		t.Errorf("Expected version %s but got %s", version, getConfigMapVersion(first))
+	}
+
+	second, err := reconcileConfigRevision(r, instance, map[string]string{configMapMasterKey: "a: 2\n"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if getConfigMapVersion(second) == version || second.GetName() == first.GetName() {
+		t.Errorf("Expected a new version and name for changed content but got %s, %s", getConfigMapVersion(second), second.GetName())
+	}
+
+	// The name of the config map can be used as a label value
+	instance.Name = strings.Repeat("a", 80)
+	if name := getConfigRevisionName(instance, getConfigHash(getConfigData(second))); len(name) > maxConfigMapNameLength {
+		t.Errorf("The config map name %s is too long", name)
+	}
+}
+
+func TestGetConfigRevisionName(t *testing.T) {
+	hash := getConfigHash(map[string]string{configMapMasterKey: "a: 1\n"})
+	suffix := hash[:configMapHashLength]
+
+	tests := []struct {
+		name     string
+		instance string
+		cmSuffix string
+		expected string
+	}{
+		{name: "the default suffix", instance: "iag-instance",
+			expected: "iag-instance-config-iag-internal-generated-" + suffix},
+		{name: "a custom suffix", instance: "iag-instance", cmSuffix: "-cfg",
+			expected: "iag-instance-cfg-" + suffix},
+		{name: "a custom suffix with a trailing separator", instance: "iag-instance", cmSuffix: "-cfg.-",
+			expected: "iag-instance-cfg-" + suffix},
+		{name: "a long name", instance: strings.Repeat("a", 80),
+			expected: strings.Repeat("a", maxConfigMapNameLength-configMapHashLength-1) + "-" + suffix},
+		{name: "a long name which is truncated at a separator", instance: strings.Repeat("a", 51) + "-b",
+			expected: strings.Repeat("a", 51) + "-" + suffix},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			instance := &ibmv1.IBMApplicationGateway{}
+			instance.Name = test.instance
+			instance.Spec.Deployment.ConfigMapSuffix = test.cmSuffix
+
+			name := getConfigRevisionName(instance, hash)
+			if name != test.expected {
+				t.Errorf("Expected %s but got %s", test.expected, name)
+			}
+
+			if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+				t.Errorf("Expected %s to be a valid name but got %v", name, errs)
+			}
+		})
+	}
+}
+
+func TestPruneConfigRevisionsKeepsInUse(t *testing.T) {
+	r, instance := newRevisionTestReconciler(t)
+
+	limit := int32(0)
+	instance.Spec.RevisionHistoryLimit = &limit
+
+	first, err := reconcileConfigRevision(r, instance, map[string]string{configMapMasterKey: "a: 1\n"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The deployment still uses the first revision while the second rolls out
+	_, err = reconcileConfigRevision(r, instance, map[string]string{configMapMasterKey: "a: 2\n"}, first.GetName())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(instance.Status.ConfigRevisions) != 2 || instance.Status.ConfigRevision != 2 {
+		t.Errorf("Expected both revisions to be retained but got %v", instance.Status.ConfigRevisions)
+	}
+
+	// Once the rollout has completed the first revision can be deleted
+	_, err = reconcileConfigRevision(r, instance, map[string]string{configMapMasterKey: "a: 2\n"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(instance.Status.ConfigRevisions) != 1 || instance.Status.ConfigRevisions[0].Revision != 2 {
+		t.Errorf("Expected only revision 2 to be retained but got %v", instance.Status.ConfigRevisions)
+	}
+}
+
+func TestReconcileConfigRevisionRollsBack(t *testing.T) {
+	r, instance := newRevisionTestReconciler(t)
+	r.EventRecorder = record.NewFakeRecorder(10)
+
+	limit := int32(0)
+	instance.Spec.RevisionHistoryLimit = &limit
+
+	healthy, err := reconcileConfigRevision(r, instance, map[string]string{configMapMasterKey: "a: 1\n"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance.Status.HealthyConfigRevision = 1
+
+	failed, err := reconcileConfigRevision(r, instance, map[string]string{configMapMasterKey: "a: 2\n"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The rollout of the second revision exceeds its progress deadline
+	dply := &appsv1.Deployment{}
+	dply.Spec.Template.Labels = map[string]string{configRevisionLabelKey: "2"}
+	dply.Status.UnavailableReplicas = 1
+	dply.Status.Conditions = []appsv1.DeploymentCondition{{
+		Type:   appsv1.DeploymentProgressing,
+		Status: corev1.ConditionFalse,
+		Reason: progressDeadlineExceededReason,
+	}}
+
+	// The configuration revision is the only change since the healthy
+	// revision was rolled out
+	setPodTemplateHash(dply)
+	instance.Status.HealthyPodTemplateHash = dply.Spec.Template.Annotations[podTemplateHashAnnotationKey]
+
+	checkConfigRollout(r, instance, dply)
+
+	if instance.Status.FailedConfigRevision != 2 ||
+		instance.Status.FailedConfigHash != failed.GetAnnotations()[configHashAnnotationKey] {
+		t.Fatalf("Expected revision 2 to be recorded as failed but got %d", instance.Status.FailedConfigRevision)
+	}
+
+	// The unchanged configuration is rolled back to the healthy revision,
+	// which is retained even though it exceeds the history limit
+	selected, err := reconcileConfigRevision(r, instance, map[string]string{configMapMasterKey: "a: 2\n"}, failed.GetName())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if selected.GetName() != healthy.GetName() || instance.Status.ConfigRevision != 1 {
+		t.Errorf("Expected revision 1 to be selected but got %d", instance.Status.ConfigRevision)
+	}
+
+	// A changed configuration is rolled out as a new revision
+	selected, err = reconcileConfigRevision(r, instance, map[string]string{configMapMasterKey: "a: 3\n"}, healthy.GetName())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if getConfigRevisionNumber(selected) != 3 || instance.Status.FailedConfigHash != "" {
+		t.Errorf("Expected revision 3 to be selected but got %d", getConfigRevisionNumber(selected))
+	}
+
+	for _, revision := range instance.Status.ConfigRevisions {
+		if revision.Revision == 2 {
+			t.Errorf("Expected the failed revision to be deleted but got %v", instance.Status.ConfigRevisions)
+		}
+	}
+}
+
+func TestReconcileConfigRevisionSecretStorage(t *testing.T) {
+	r, instance := newRevisionTestReconciler(t)
+
+	data := map[string]string{configMapMasterKey: "identity:\n  oidc:\n    client_secret: passw0rd\n"}
+
+	configMap, err := reconcileConfigRevision(r, instance, data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Changing the storage creates a new revision, even though the content
+	// has not changed
+	instance.Spec.Deployment.ConfigStorage = configStorageSecret
+
+	secret, err := reconcileConfigRevision(r, instance, data, configMap.GetName())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := secret.(*corev1.Secret); !ok || getConfigRevisionNumber(secret) != 2 {
+		t.Fatalf("Expected revision 2 to be a secret but got %T %d", secret, getConfigRevisionNumber(secret))
+	}
+	if getConfigData(secret)[configMapMasterKey] != data[configMapMasterKey] {
+		t.Errorf("Unexpected configuration %q", getConfigData(secret)[configMapMasterKey])
+	}
+	if getConfigMapVersion(secret) != getConfigMapVersion(configMap) {
+		t.Errorf("Expected the version of the content to be unchanged")
+	}
+
+	// The same content is not stored again
+	again, err := reconcileConfigRevision(r, instance, data, secret.GetName())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if getConfigRevisionNumber(again) != 2 || len(instance.Status.ConfigRevisions) != 2 {
+		t.Errorf("Expected revision 2 to be reused but got %v", instance.Status.ConfigRevisions)
+	}
+	if instance.Status.ConfigRevisions[1].Storage != configStorageSecret {
+		t.Errorf("Expected the storage of revision 2 to be recorded but got %v", instance.Status.ConfigRevisions)
+	}
+
+	// The deployment mounts the secret
+	dply := newDeploymentForCR(instance, getConfigMapVersion(secret), secret.GetName(), configStorageSecret)
+	if getDeployedConfigStorage(dply) != configStorageSecret {
+		t.Errorf("Expected the deployment to mount the secret but got %v", dply.Spec.Template.Spec.Volumes)
+	}
+}
+
+func TestGetConfigStorage(t *testing.T) {
+	tests := []struct {
+		name     string
+		storage  string
+		entry    string
+		expected string
+	}{
+		{name: "the default storage of a literal source", entry: "literal", expected: configStorageConfigMap},
+		{name: "the default storage of a secret source", entry: "secret", expected: configStorageSecret},
+		{name: "the default storage of an oidc_registration source", entry: "oidc_registration",
+			expected: configStorageSecret},
+		{name: "a secret source which is explicitly stored in a config map", storage: configStorageConfigMap,
+			entry: "secret", expected: configStorageConfigMap},
+		{name: "a literal source which is explicitly stored in a secret", storage: configStorageSecret,
+			entry: "literal", expected: configStorageSecret},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			instance := &ibmv1.IBMApplicationGateway{}
+			instance.Spec.Deployment.ConfigStorage = test.storage
+			instance.Spec.Configuration = []ibmv1.IBMApplicationGatewayConfiguration{{Type: test.entry}}
+
+			if storage := getConfigStorage(instance); storage != test.expected {
+				t.Errorf("Expected %s but got %s", test.expected, storage)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+/*
+ * Copyright contributors to the IBM Application Gateway Operator project
+ */
+
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	ibmv1 "github.com/ibm-security/ibm-application-gateway-operator/api/v1"
+)
+
+// The reason which is used for the degraded condition, and the event, when a
+// configuration revision is rolled back.
+const reasonConfigRolledBack = "ConfigRolledBack"
+
+// The reason which is used for the degraded condition when a rollout has
+// failed but the configuration revision is not rolled back.
+const reasonRolloutFailed = "RolloutFailed"
+
+// The reason which is set by the deployment controller when a rollout has not
+// made any progress within the progress deadline.
+const progressDeadlineExceededReason = "ProgressDeadlineExceeded"
+
+// The pod template annotation which contains the hash of the pod template,
+// excluding the generated configuration.  This is used to determine whether
+// the configuration revision is the only change in a failed rollout.
+const podTemplateHashAnnotationKey = "ibm-application-gateway.operator.security.ibm.com/podTemplateHash"
+
+/*
+ * Function returns the hash of the passed in pod template, excluding the
+ * labels and the volume which identify the generated configuration.  Two pod
+ * templates with the same hash only differ in their configuration revision.
+ */
+func getPodTemplateHash(template *corev1.PodTemplateSpec) string {
+	masked := template.DeepCopy()
+
+	delete(masked.Labels, configVersionLabelKey)
+	delete(masked.Labels, configMapLabelKey)
+	delete(masked.Labels, configRevisionLabelKey)
+	delete(masked.Annotations, podTemplateHashAnnotationKey)
+
+	for i := range masked.Spec.Volumes {
+		if masked.Spec.Volumes[i].Name == configVolumeName {
+			masked.Spec.Volumes[i].VolumeSource = corev1.VolumeSource{}
+		}
+	}
+
+	data, err := json.Marshal(masked)
+	if err != nil {
+		return ""
+	}
+
+	hash := sha256.Sum256(data)
+
+	return hex.EncodeToString(hash[:])
+}
+
+/*
+ * Function records the hash of the pod template of the passed in deployment
+ * in the pod template.
+ */
+func setPodTemplateHash(dply *appsv1.Deployment) {
+	hash := getPodTemplateHash(&dply.Spec.Template)
+
+	dply.Spec.Template.Annotations = mergeStringMaps(dply.Spec.Template.Annotations, map[string]string{
+		podTemplateHashAnnotationKey: hash,
+	})
+}
+
+/*
+ * Function returns the configuration revision which is used by the pod
+ * template of the passed in deployment, or 0 if it is not known.
+ */
+func getDeployedConfigRevision(dply *appsv1.Deployment) int64 {
+	revision, err := strconv.ParseInt(dply.Spec.Template.Labels[configRevisionLabelKey], 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return revision
+}
+
+/*
+ * Function returns true if the rollout of the current pod template of the
+ * passed in deployment has exceeded its progress deadline and there are still
+ * unavailable replicas.
+ */
+func isRolloutFailed(dply *appsv1.Deployment) bool {
+	if dply.Status.ObservedGeneration < dply.Generation {
+		return false
+	}
+
+	for _, cond := range dply.Status.Conditions {
+		if cond.Type == appsv1.DeploymentProgressing {
+			return cond.Status == corev1.ConditionFalse &&
+				cond.Reason == progressDeadlineExceededReason &&
+				dply.Status.UnavailableReplicas > 0
+		}
+	}
+
+	return false
+}
+
+/*
+ * Function checks whether the configuration revision which is being rolled
+ * out to the passed in deployment has failed to become ready.  If it has, an
+ * earlier revision was healthy, and the configuration revision is the only
+ * change to the pod template since then, the failed revision is recorded in
+ * the status so that the last healthy revision is deployed instead.  The
+ * failed revision is not deployed again until the merged configuration
+ * changes.  If anything else in the pod template has changed the failure may
+ * not be caused by the configuration, so it is left alone.
+ */
+func checkConfigRollout(r *IBMApplicationGatewayReconciler, instance *ibmv1.IBMApplicationGateway,
+	dply *appsv1.Deployment) {
+
+	// A pinned revision is never rolled back
+	if instance.Spec.ConfigurationRevision > 0 || !isRolloutFailed(dply) {
+		return
+	}
+
+	deployed := getDeployedConfigRevision(dply)
+	healthy := instance.Status.HealthyConfigRevision
+
+	if deployed == 0 || healthy == 0 || deployed == healthy ||
+		deployed == instance.Status.FailedConfigRevision {
+		return
+	}
+
+	hash := ""
+	for _, revision := range instance.Status.ConfigRevisions {
+		if revision.Revision == deployed {
+			hash = revision.Hash
+		}
+	}
+
+	if hash == "" {
+		return
+	}
+
+	reqLogger := log.WithValues("Request.Namespace", instance.Namespace, "Request.Name", instance.Name)
+
+	templateHash := dply.Spec.Template.Annotations[podTemplateHashAnnotationKey]
+	if templateHash == "" || templateHash != instance.Status.HealthyPodTemplateHash {
+		reqLogger.Info(fmt.Sprintf("Configuration revision %d failed to become ready along with other changes "+
+			"to the deployment, not rolling back.", deployed))
+		return
+	}
+
+	message := fmt.Sprintf("Configuration revision %d failed to become ready, rolling back to revision %d.",
+		deployed, healthy)
+
+	reqLogger.Info(message)
+	r.EventRecorder.Event(instance, corev1.EventTypeWarning, reasonConfigRolledBack, message)
+
+	instance.Status.FailedConfigRevision = deployed
+	instance.Status.FailedConfigHash = hash
+}
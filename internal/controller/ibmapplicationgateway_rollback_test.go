@@ -0,0 +1,255 @@
+/*
+ * Copyright contributors to the IBM Application Gateway Operator project
+ */
+
+package controllers
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ibmv1 "github.com/ibm-security/ibm-application-gateway-operator/api/v1"
+)
+
+/*
+ * Function returns the deployment which uses the passed in configuration
+ * revision, and whose rollout has exceeded its progress deadline.
+ */
+func newFailedRolloutDeployment(instance *ibmv1.IBMApplicationGateway, cmName string, cmVersion string,
+	cmStorage string) *appsv1.Deployment {
+
+	dply := newDeploymentForCR(instance, cmVersion, cmName, cmStorage)
+	dply.Spec.Template.Labels[configRevisionLabelKey] = strconv.FormatInt(instance.Status.ConfigRevision, 10)
+	setPodTemplateHash(dply)
+
+	dply.Generation = 2
+	dply.Status.ObservedGeneration = 2
+	dply.Status.UnavailableReplicas = 1
+	dply.Status.Conditions = []appsv1.DeploymentCondition{{
+		Type:   appsv1.DeploymentProgressing,
+		Status: corev1.ConditionFalse,
+		Reason: progressDeadlineExceededReason,
+	}}
+
+	return dply
+}
+
+func TestIsRolloutFailed(t *testing.T) {
+	tests := []struct {
+		name     string
+		update   func(dply *appsv1.Deployment)
+		expected bool
+	}{
+		{name: "the progress deadline has been exceeded", expected: true,
+			update: func(dply *appsv1.Deployment) {}},
+		{name: "all of the replicas are available",
+			update: func(dply *appsv1.Deployment) { dply.Status.UnavailableReplicas = 0 }},
+		{name: "the latest generation has not been observed",
+			update: func(dply *appsv1.Deployment) { dply.Generation = 3 }},
+		{name: "the rollout is progressing",
+			update: func(dply *appsv1.Deployment) {
+				dply.Status.Conditions[0].Status = corev1.ConditionTrue
+				dply.Status.Conditions[0].Reason = "ReplicaSetUpdated"
+			}},
+		{name: "there are no conditions",
+			update: func(dply *appsv1.Deployment) { dply.Status.Conditions = nil }},
+	}
+
+	instance := &ibmv1.IBMApplicationGateway{}
+	instance.Name = "iag-instance"
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dply := newFailedRolloutDeployment(instance, "iag-instance-config", "1", configStorageConfigMap)
+			test.update(dply)
+
+			if failed := isRolloutFailed(dply); failed != test.expected {
+				t.Errorf("Expected %v but got %v", test.expected, failed)
+			}
+		})
+	}
+}
+
+func TestCheckConfigRollout(t *testing.T) {
+	r, instance := newRevisionTestReconciler(t)
+	recorder := record.NewFakeRecorder(10)
+	r.EventRecorder = recorder
+
+	request := ctrl.Request{NamespacedName: types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}}
+
+	// The first revision becomes ready
+	instance.Spec.Configuration = []ibmv1.IBMApplicationGatewayConfiguration{
+		{Type: "literal", Value: "version: \"24.12\"\nserver:\n  worker_threads: 100\n"},
+	}
+
+	healthyName, healthyVersion, healthyStorage, err := createNewConfigMap(r, instance, request, &appsv1.Deployment{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance.Status.HealthyConfigRevision = instance.Status.ConfigRevision
+	instance.Status.HealthyPodTemplateHash = getPodTemplateHash(
+		&newDeploymentForCR(instance, healthyVersion, healthyName, healthyStorage).Spec.Template)
+
+	// The second revision fails to become ready
+	instance.Spec.Configuration[0].Value = "version: \"24.12\"\nserver:\n  worker_threads: 200\n"
+
+	failedName, failedVersion, failedStorage, err := createNewConfigMap(r, instance, request, &appsv1.Deployment{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if instance.Status.ConfigRevision != 2 {
+		t.Fatalf("Expected revision 2 to be rolled out but got %d", instance.Status.ConfigRevision)
+	}
+
+	dply := newFailedRolloutDeployment(instance, failedName, failedVersion, failedStorage)
+
+	// A pinned revision is never rolled back
+	instance.Spec.ConfigurationRevision = 2
+	checkConfigRollout(r, instance, dply)
+
+	if instance.Status.FailedConfigRevision != 0 || len(recorder.Events) != 0 {
+		t.Fatalf("Expected the pinned revision not to be rolled back")
+	}
+
+	instance.Spec.ConfigurationRevision = 0
+	checkConfigRollout(r, instance, dply)
+
+	if instance.Status.FailedConfigRevision != 2 || instance.Status.FailedConfigHash == "" {
+		t.Fatalf("Expected revision 2 to be recorded as failed but got %d", instance.Status.FailedConfigRevision)
+	}
+
+	select {
+	case event := <-recorder.Events:
+		if !strings.HasPrefix(event, corev1.EventTypeWarning+" "+reasonConfigRolledBack+" ") {
+			t.Errorf("Unexpected event : %s", event)
+		}
+	default:
+		t.Errorf("Expected a rollback event")
+	}
+
+	// The failed revision is only rolled back once
+	checkConfigRollout(r, instance, dply)
+
+	if len(recorder.Events) != 0 {
+		t.Errorf("Expected a single rollback event")
+	}
+
+	// The deployment is pointed back at the healthy revision
+	cmName, cmVersion, cmStorage, err := createNewConfigMap(r, instance, request, dply)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cmName != healthyName || instance.Status.ConfigRevision != 1 {
+		t.Fatalf("Expected the healthy revision to be deployed but got %s, revision %d", cmName,
+			instance.Status.ConfigRevision)
+	}
+
+	desired := newDeploymentForCR(instance, cmVersion, cmName, cmStorage)
+	if desired.Spec.Template.Labels[configMapLabelKey] != healthyName {
+		t.Errorf("Expected the deployment to use %s but got %s", healthyName,
+			desired.Spec.Template.Labels[configMapLabelKey])
+	}
+}
+
+func TestCheckConfigRolloutWithOtherChanges(t *testing.T) {
+	r, instance := newRevisionTestReconciler(t)
+	recorder := record.NewFakeRecorder(10)
+	r.EventRecorder = recorder
+
+	request := ctrl.Request{NamespacedName: types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}}
+
+	// The first revision becomes ready
+	instance.Spec.Deployment.ImageLocation = "icr.io/ibmappgateway/ibm-application-gateway:24.12"
+	instance.Spec.Configuration = []ibmv1.IBMApplicationGatewayConfiguration{
+		{Type: "literal", Value: "version: \"24.12\"\nserver:\n  worker_threads: 100\n"},
+	}
+
+	healthyName, healthyVersion, healthyStorage, err := createNewConfigMap(r, instance, request, &appsv1.Deployment{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	healthy := newDeploymentForCR(instance, healthyVersion, healthyName, healthyStorage)
+	instance.Status.HealthyConfigRevision = instance.Status.ConfigRevision
+	instance.Status.HealthyPodTemplateHash = getPodTemplateHash(&healthy.Spec.Template)
+
+	// The image and the configuration change together, and the rollout fails
+	instance.Spec.Deployment.ImageLocation = "icr.io/ibmappgateway/ibm-application-gateway:25.03"
+	instance.Spec.Configuration[0].Value = "version: \"24.12\"\nserver:\n  worker_threads: 200\n"
+
+	failedName, failedVersion, failedStorage, err := createNewConfigMap(r, instance, request, &appsv1.Deployment{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dply := newFailedRolloutDeployment(instance, failedName, failedVersion, failedStorage)
+
+	checkConfigRollout(r, instance, dply)
+
+	if instance.Status.FailedConfigRevision != 0 || instance.Status.FailedConfigHash != "" || len(recorder.Events) != 0 {
+		t.Fatalf("Expected the configuration not to be rolled back but got revision %d",
+			instance.Status.FailedConfigRevision)
+	}
+
+	// The configuration is left alone
+	cmName, _, _, err := createNewConfigMap(r, instance, request, dply)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cmName != failedName || instance.Status.ConfigRevision != 2 {
+		t.Errorf("Expected revision 2 to remain deployed but got %s, revision %d", cmName,
+			instance.Status.ConfigRevision)
+	}
+
+	// The failed rollout is reported in the degraded condition
+	if err := updateStatus(r, instance, dply, cmName); err != nil {
+		t.Fatal(err)
+	}
+
+	degraded := meta.FindStatusCondition(instance.Status.Conditions, ibmv1.ConditionDegraded)
+	if degraded == nil || degraded.Status != metav1.ConditionTrue || degraded.Reason != reasonRolloutFailed {
+		t.Errorf("Expected the resource to be degraded but got %+v", degraded)
+	}
+}
+
+func TestGetPodTemplateHash(t *testing.T) {
+	instance := &ibmv1.IBMApplicationGateway{}
+	instance.Name = "iag-instance"
+
+	first := newDeploymentForCR(instance, "0123456789abcdef", "iag-instance-config-1", configStorageConfigMap)
+	first.Spec.Template.Labels[configRevisionLabelKey] = "1"
+	setPodTemplateHash(first)
+
+	// Only the configuration revision changes
+	second := newDeploymentForCR(instance, "fedcba9876543210", "iag-instance-config-2", configStorageSecret)
+	second.Spec.Template.Labels[configRevisionLabelKey] = "2"
+	setPodTemplateHash(second)
+
+	if first.Spec.Template.Annotations[podTemplateHashAnnotationKey] !=
+		second.Spec.Template.Annotations[podTemplateHashAnnotationKey] {
+		t.Errorf("Expected a configuration change not to change the pod template hash")
+	}
+
+	// The image changes as well
+	instance.Spec.Deployment.ImageLocation = "icr.io/ibmappgateway/ibm-application-gateway:25.03"
+	third := newDeploymentForCR(instance, "fedcba9876543210", "iag-instance-config-2", configStorageConfigMap)
+	setPodTemplateHash(third)
+
+	if third.Spec.Template.Annotations[podTemplateHashAnnotationKey] ==
+		first.Spec.Template.Annotations[podTemplateHashAnnotationKey] {
+		t.Errorf("Expected an image change to change the pod template hash")
+	}
+}
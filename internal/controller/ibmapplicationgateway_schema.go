@@ -0,0 +1,273 @@
+/*
+ * Copyright contributors to the IBM Application Gateway Operator project
+ */
+
+package controllers
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s.io/kube-openapi/pkg/validation/errors"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+	"k8s.io/kube-openapi/pkg/validation/strfmt"
+	"k8s.io/kube-openapi/pkg/validation/validate"
+)
+
+// The JSON schemas of the IBM Application Gateway configuration.  Each schema
+// is named after the first configuration version to which it applies.
+//
+//go:embed schemas/*.json
+var configSchemaFiles embed.FS
+
+// The error which is returned when the merged configuration does not match
+// the schema.  Each problem is prefixed with the JSON pointer of the entry
+// which is invalid.
+type configValidationError struct {
+	schemaVersion string
+	problems      []string
+}
+
+func (e *configValidationError) Error() string {
+	return fmt.Sprintf("The merged configuration does not match the %s configuration schema: %s",
+		e.schemaVersion, strings.Join(e.problems, "; "))
+}
+
+/*
+ * Function returns the version of the passed in configuration, as a string.
+ */
+func getConfigVersion(config map[string]interface{}) string {
+	switch version := config["version"].(type) {
+	case string:
+		return version
+	case float64:
+		// An unquoted version, such as 22.07, is parsed as a number
+		return strconv.FormatFloat(version, 'f', 2, 64)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(version)
+	}
+}
+
+/*
+ * Function compares two configuration versions of the form YY.MM.
+ */
+func compareConfigVersions(v1 string, v2 string) int {
+	f1, err1 := strconv.ParseFloat(v1, 64)
+	f2, err2 := strconv.ParseFloat(v2, 64)
+
+	if err1 != nil || err2 != nil {
+		return strings.Compare(v1, v2)
+	}
+
+	if f1 < f2 {
+		return -1
+	} else if f1 > f2 {
+		return 1
+	}
+	return 0
+}
+
+/*
+ * Function returns the schema which applies to the passed in configuration
+ * version.  This is the schema for the newest version which is not newer than
+ * the passed in version, and the newest schema is used if the version is not
+ * known.  If the version is newer than the newest schema unknown properties
+ * are allowed, as they may have been added by the newer version.  If the
+ * version is older than the oldest schema no schema is returned, as the
+ * structure of the configuration may have been different.
+ */
+func getConfigSchema(version string) (*spec.Schema, string, error) {
+	files, err := configSchemaFiles.ReadDir("schemas")
+	if err != nil {
+		return nil, "", err
+	}
+
+	var versions []string
+	for _, file := range files {
+		versions = append(versions, strings.TrimSuffix(file.Name(), ".json"))
+	}
+
+	if len(versions) == 0 {
+		return nil, "", fmt.Errorf("No configuration schemas are available.")
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return compareConfigVersions(versions[i], versions[j]) < 0
+	})
+
+	if version != "" && compareConfigVersions(version, versions[0]) < 0 {
+		return nil, "", nil
+	}
+
+	selected := versions[len(versions)-1]
+	if version != "" {
+		for _, candidate := range versions {
+			if compareConfigVersions(candidate, version) <= 0 {
+				selected = candidate
+			}
+		}
+	}
+
+	data, err := configSchemaFiles.ReadFile(path.Join("schemas", selected+".json"))
+	if err != nil {
+		return nil, "", err
+	}
+
+	if version != "" && compareConfigVersions(version, versions[len(versions)-1]) > 0 {
+		data, err = allowAdditionalProperties(data)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	schema := &spec.Schema{}
+	err = json.Unmarshal(data, schema)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return schema, selected, nil
+}
+
+/*
+ * Function returns a copy of the passed in JSON schema with each
+ * "additionalProperties": false constraint removed.
+ */
+func allowAdditionalProperties(data []byte) ([]byte, error) {
+	var schema interface{}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, err
+	}
+
+	var relax func(node interface{})
+	relax = func(node interface{}) {
+		switch value := node.(type) {
+		case map[string]interface{}:
+			if allowed, ok := value["additionalProperties"].(bool); ok && !allowed {
+				delete(value, "additionalProperties")
+			}
+			for _, child := range value {
+				relax(child)
+			}
+		case []interface{}:
+			for _, child := range value {
+				relax(child)
+			}
+		}
+	}
+
+	relax(schema)
+
+	return json.Marshal(schema)
+}
+
+/*
+ * Function converts the dotted path which is reported by the schema validator,
+ * for example resource_servers[0].servers, to a JSON pointer.
+ */
+func toJsonPointer(name string) string {
+	name = strings.TrimPrefix(name, ".")
+	if name == "" {
+		return ""
+	}
+
+	name = strings.ReplaceAll(name, "[", ".")
+	name = strings.ReplaceAll(name, "]", "")
+
+	var pointer strings.Builder
+	for _, segment := range strings.Split(name, ".") {
+		pointer.WriteString(toJsonPointerSegment(segment))
+	}
+
+	return pointer.String()
+}
+
+/*
+ * Function returns a single escaped JSON pointer segment.
+ */
+func toJsonPointerSegment(segment string) string {
+	segment = strings.ReplaceAll(segment, "~", "~0")
+	segment = strings.ReplaceAll(segment, "/", "~1")
+
+	return "/" + segment
+}
+
+/*
+ * Function validates the merged configuration against the schema for the
+ * version of the configuration.  A configValidationError is returned if the
+ * configuration is not valid.  If there is no schema for the version of the
+ * configuration it is not validated, and a warning is returned instead.
+ */
+func validateMergedConfig(master map[string]interface{}) (string, error) {
+
+	// The validator works with the JSON representation of the configuration
+	data, err := json.Marshal(master)
+	if err != nil {
+		return "", fmt.Errorf("The merged configuration could not be converted to JSON: %v", err)
+	}
+
+	var config map[string]interface{}
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return "", err
+	}
+
+	version := getConfigVersion(config)
+
+	schema, schemaVersion, err := getConfigSchema(version)
+	if err != nil {
+		return "", err
+	}
+
+	if schema == nil {
+		return fmt.Sprintf("The merged configuration has not been validated, as there is no configuration "+
+			"schema for version %s.", version), nil
+	}
+
+	log.V(1).Info("Validating the merged configuration version " + version +
+		" with the " + schemaVersion + " schema")
+
+	result := validate.NewSchemaValidator(schema, nil, "", strfmt.Default).Validate(config)
+	if result.IsValid() {
+		return "", nil
+	}
+
+	var problems []string
+	for _, resultErr := range result.Errors {
+		validationErr, ok := resultErr.(*errors.Validation)
+		if !ok {
+			problems = append(problems, resultErr.Error())
+			continue
+		}
+
+		pointer := toJsonPointer(validationErr.Name)
+
+		// A forbidden property is reported against its parent
+		if validationErr.Code() == errors.UnallowedPropertyCode {
+			pointer = pointer + toJsonPointerSegment(fmt.Sprint(validationErr.Value))
+		}
+
+		if pointer == "" {
+			pointer = "/"
+		}
+
+		// Remove the dotted path from the start of the message
+		message := validationErr.Error()
+		if idx := strings.Index(message, " in body "); idx >= 0 {
+			message = message[idx+len(" in body "):]
+		}
+
+		problems = append(problems, pointer+" "+message)
+	}
+
+	sort.Strings(problems)
+
+	return "", &configValidationError{schemaVersion: schemaVersion, problems: problems}
+}
@@ -0,0 +1,101 @@
+/*
+ * Copyright contributors to the IBM Application Gateway Operator project
+ */
+
+package controllers
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ibmv1 "github.com/ibm-security/ibm-application-gateway-operator/api/v1"
+)
+
+func TestValidateMergedConfigVersions(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        map[string]interface{}
+		expectErr     bool
+		expectWarning bool
+	}{
+		{name: "a known entry", config: map[string]interface{}{"version": "22.07", "server": map[string]interface{}{}}},
+		{name: "an unknown entry", expectErr: true,
+			config: map[string]interface{}{"version": "22.07", "sever": map[string]interface{}{}}},
+		{name: "an unknown server entry", expectErr: true,
+			config: map[string]interface{}{"version": "22.07",
+				"server": map[string]interface{}{"worker_thread": 100}}},
+		{name: "an unknown identity entry", expectErr: true,
+			config: map[string]interface{}{"version": "22.07",
+				"identity": map[string]interface{}{"odic": map[string]interface{}{}}}},
+		{name: "an unknown resource server entry", expectErr: true,
+			config: map[string]interface{}{"version": "22.07",
+				"resource_servers": []interface{}{map[string]interface{}{"path": "/app", "sever": "app"}}}},
+		{name: "a known resource server entry",
+			config: map[string]interface{}{"version": "22.07",
+				"resource_servers": []interface{}{map[string]interface{}{"path": "/app", "sni": "app"}}}},
+		{name: "an unknown entry of a newer version",
+			config: map[string]interface{}{"version": "99.12", "new_entry": map[string]interface{}{}}},
+		{name: "an unknown nested entry of a newer version",
+			config: map[string]interface{}{"version": "99.12",
+				"server": map[string]interface{}{"new_entry": map[string]interface{}{}}}},
+		{name: "an invalid type of a newer version", expectErr: true,
+			config: map[string]interface{}{"version": "99.12", "server": "invalid"}},
+		{name: "an older version", expectWarning: true,
+			config: map[string]interface{}{"version": "21.12", "sever": "invalid"}},
+		{name: "an older unquoted version", expectWarning: true,
+			config: map[string]interface{}{"version": 21.12, "server": "invalid"}},
+	}
+
+	for _, test := range tests {
+		warning, err := validateMergedConfig(test.config)
+		if test.expectErr != (err != nil) {
+			t.Errorf("%s: unexpected result %v", test.name, err)
+		}
+		if test.expectWarning != (warning != "") {
+			t.Errorf("%s: unexpected warning %q", test.name, warning)
+		}
+	}
+}
+
+func TestGetMergedConfigOlderVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected string
+	}{
+		{name: "a version with a schema", value: "version: \"22.07\"\nserver:\n  worker_threads: 100\n",
+			expected: reasonConfigMerged},
+		{name: "a version without a schema", value: "version: \"21.12\"\nserver:\n  worker_threads: 100\n",
+			expected: reasonConfigNotValidated},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			instance := &ibmv1.IBMApplicationGateway{
+				ObjectMeta: metav1.ObjectMeta{Name: "iag-instance", Namespace: "default"},
+				Spec: ibmv1.IBMApplicationGatewaySpec{
+					Configuration: []ibmv1.IBMApplicationGatewayConfiguration{{Type: "literal", Value: test.value}},
+				},
+			}
+
+			r := newWatchTestReconciler(t, instance)
+			request := ctrl.Request{NamespacedName: types.NamespacedName{Name: instance.Name,
+				Namespace: instance.Namespace}}
+
+			if _, _, err := getMergedConfig(r, instance, request, false); err != nil {
+				t.Fatal(err)
+			}
+
+			merged := meta.FindStatusCondition(instance.Status.Conditions, ibmv1.ConditionConfigMerged)
+			if merged == nil || merged.Status != metav1.ConditionTrue || merged.Reason != test.expected {
+				t.Errorf("Expected the %s reason but got %+v", test.expected, merged)
+			}
+		})
+	}
+}
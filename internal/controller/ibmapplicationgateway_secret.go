@@ -0,0 +1,96 @@
+/*
+ * Copyright contributors to the IBM Application Gateway Operator project
+ */
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	corev1 "k8s.io/api/core/v1"
+
+	ibmv1 "github.com/ibm-security/ibm-application-gateway-operator/api/v1"
+)
+
+// The field index which contains the names of the secrets which are
+// referenced by the configuration sources of a custom resource.
+const secretIndexField = "spec.configuration.secrets"
+
+// The pod template annotation which contains a hash of the data of the
+// secrets which are referenced by the configuration sources.
+const secretHashAnnotationKey = "ibm-application-gateway.operator.security.ibm.com/secretHash"
+
+/*
+ * Function returns the names of the secrets which are referenced by the
+ * configuration sources of the custom resource.  This includes the secrets
+ * which contain configuration data, web header values and the OIDC
+ * registration secret.
+ */
+func getReferencedSecrets(instance *ibmv1.IBMApplicationGateway) []string {
+	names := getSourceReferences(instance.Spec.Configuration, sourceReferenceSecret, instance.Namespace)
+
+	sort.Strings(names)
+
+	return names
+}
+
+/*
+ * Function is used to index the custom resources by the secrets which are
+ * referenced by their configuration sources, or which were read by their
+ * configuration templates.
+ */
+func indexReferencedSecrets(obj client.Object) []string {
+	instance, ok := obj.(*ibmv1.IBMApplicationGateway)
+	if !ok {
+		return nil
+	}
+
+	return appendMissing(getReferencedSecrets(instance), instance.Status.TemplateSecrets)
+}
+
+/*
+ * Function returns a hash of the data of the secrets which are referenced by
+ * the configuration sources of the custom resource, or an empty string if no
+ * secrets are referenced.  The hash is added to the pod template so that the
+ * pods are reloaded when a referenced secret changes.
+ */
+func getSecretHash(r *IBMApplicationGatewayReconciler, instance *ibmv1.IBMApplicationGateway) (string, error) {
+	names := getReferencedSecrets(instance)
+	if len(names) == 0 {
+		return "", nil
+	}
+
+	hash := sha256.New()
+
+	for _, name := range names {
+		secret := &corev1.Secret{}
+		err := r.Client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: instance.Namespace}, secret)
+		if err != nil {
+			return "", err
+		}
+
+		// The keys are sorted so that the hash is stable
+		keys := make([]string, 0, len(secret.Data))
+		for key := range secret.Data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		hash.Write([]byte(name))
+		hash.Write([]byte{0})
+		for _, key := range keys {
+			hash.Write([]byte(key))
+			hash.Write([]byte{0})
+			hash.Write(secret.Data[key])
+			hash.Write([]byte{0})
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
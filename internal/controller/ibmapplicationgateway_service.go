@@ -0,0 +1,472 @@
+/*
+ * Copyright contributors to the IBM Application Gateway Operator project
+ */
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ibmv1 "github.com/ibm-security/ibm-application-gateway-operator/api/v1"
+)
+
+const (
+	iagContainerPort = 8443
+	iagPortName      = "https"
+)
+
+// The annotation which records the keys of the annotations which have been
+// added to a service, ingress or route from the custom resource.  This allows
+// an annotation which has been removed from the custom resource to be removed
+// from the object, without removing the annotations which have been added by
+// anything else.
+const managedAnnotationsKey = "ibm-application-gateway.operator.security.ibm.com/managedAnnotations"
+
+// The group/version/kind of the OpenShift route resource.  The route is
+// managed as an unstructured object so that the operator does not depend on
+// the OpenShift API.
+var routeGVK = schema.GroupVersionKind{
+	Group:   "route.openshift.io",
+	Version: "v1",
+	Kind:    "Route",
+}
+
+//+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=route.openshift.io,resources=routes;routes/custom-host,verbs=get;list;watch;create;update;patch;delete
+
+/*
+ * Function returns the name of the service for the passed in IAG instance.
+ */
+func getServiceNameForCR(cr *ibmv1.IBMApplicationGateway) string {
+	if cr.Spec.Service != nil && cr.Spec.Service.Name != "" {
+		return cr.Spec.Service.Name
+	}
+
+	return cr.Name
+}
+
+/*
+ * Function returns the name of the ingress or route for the passed in IAG
+ * instance.
+ */
+func getIngressNameForCR(cr *ibmv1.IBMApplicationGateway) string {
+	if cr.Spec.Ingress != nil && cr.Spec.Ingress.Name != "" {
+		return cr.Spec.Ingress.Name
+	}
+
+	return cr.Name
+}
+
+/*
+ * Function returns the service ports for the passed in IAG instance.  If no
+ * ports have been specified the IAG container port is exposed.
+ */
+func getServicePorts(cr *ibmv1.IBMApplicationGateway) []corev1.ServicePort {
+
+	var specPorts []ibmv1.IBMApplicationGatewayServicePort
+	if cr.Spec.Service != nil {
+		specPorts = cr.Spec.Service.Ports
+	}
+
+	if len(specPorts) == 0 {
+		specPorts = []ibmv1.IBMApplicationGatewayServicePort{
+			{
+				Name: iagPortName,
+				Port: iagContainerPort,
+			},
+		}
+	}
+
+	var ports []corev1.ServicePort
+	for _, specPort := range specPorts {
+		targetPort := specPort.TargetPort
+		if targetPort == 0 {
+			targetPort = iagContainerPort
+		}
+
+		ports = append(ports, corev1.ServicePort{
+			Name:       specPort.Name,
+			Port:       specPort.Port,
+			TargetPort: intstr.FromInt32(targetPort),
+			NodePort:   specPort.NodePort,
+			Protocol:   corev1.ProtocolTCP,
+		})
+	}
+
+	return ports
+}
+
+/*
+ * Function makes sure that the service for the IAG instance matches the
+ * custom resource.  The service is deleted if it is no longer required.
+ */
+func reconcileService(r *IBMApplicationGatewayReconciler, instance *ibmv1.IBMApplicationGateway) error {
+	reqLogger := log.WithValues("Request.Namespace", instance.Namespace, "Request.Name", instance.Name)
+
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      getServiceNameForCR(instance),
+			Namespace: instance.Namespace,
+		},
+	}
+
+	// A service is required if either a service or ingress is specified
+	if instance.Spec.Service == nil && instance.Spec.Ingress == nil {
+		return deleteStaleObjects(r, instance, &corev1.ServiceList{}, "")
+	}
+
+	// Remove the service which was created under a previous name
+	if err := deleteStaleObjects(r, instance, &corev1.ServiceList{}, service.Name); err != nil {
+		return err
+	}
+
+	serviceType := corev1.ServiceTypeClusterIP
+	var annotations map[string]string
+	if instance.Spec.Service != nil {
+		if instance.Spec.Service.Type != "" {
+			serviceType = instance.Spec.Service.Type
+		}
+		annotations = instance.Spec.Service.Annotations
+	}
+
+	op, err := controllerutil.CreateOrUpdate(context.TODO(), r.Client, service, func() error {
+		if service.ResourceVersion != "" && !metav1.IsControlledBy(service, instance) {
+			return fmt.Errorf("The service %s already exists and is not managed by the operator.", service.Name)
+		}
+
+		service.Labels = mergeStringMaps(service.Labels, map[string]string{"app": instance.Name})
+		service.Annotations = mergeManagedAnnotations(service.Annotations, annotations)
+
+		// Retain any node ports which have been allocated by Kubernetes so
+		// that they do not change on every update.
+		ports := getServicePorts(instance)
+		for i := range ports {
+			if ports[i].NodePort != 0 || serviceType == corev1.ServiceTypeClusterIP {
+				continue
+			}
+			for _, existing := range service.Spec.Ports {
+				if existing.Port == ports[i].Port {
+					ports[i].NodePort = existing.NodePort
+				}
+			}
+		}
+
+		service.Spec.Type = serviceType
+		service.Spec.Ports = ports
+		service.Spec.Selector = getSelectorLabels(instance)
+
+		return controllerutil.SetControllerReference(instance, service, r.Scheme)
+	})
+
+	if err != nil {
+		reqLogger.Error(err, "Failed to reconcile the service.")
+		return err
+	}
+
+	if op != controllerutil.OperationResultNone {
+		reqLogger.Info("Reconciled the service.", "Service", service.Name, "Operation", op)
+	}
+
+	return nil
+}
+
+/*
+ * Function makes sure that the ingress or route for the IAG instance matches
+ * the custom resource.  Any ingress or route which is no longer required is
+ * deleted.
+ */
+func reconcileIngress(r *IBMApplicationGatewayReconciler, instance *ibmv1.IBMApplicationGateway) error {
+	reqLogger := log.WithValues("Request.Namespace", instance.Namespace, "Request.Name", instance.Name)
+
+	name := getIngressNameForCR(instance)
+
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: instance.Namespace,
+		},
+	}
+
+	route := &unstructured.Unstructured{}
+	route.SetGroupVersionKind(routeGVK)
+	route.SetName(name)
+	route.SetNamespace(instance.Namespace)
+
+	spec := instance.Spec.Ingress
+	if spec == nil {
+		if err := deleteStaleObjects(r, instance, &networkingv1.IngressList{}, ""); err != nil {
+			return err
+		}
+		return deleteStaleObjects(r, instance, newRouteList(), "")
+	}
+
+	path := spec.Path
+	if path == "" {
+		path = "/"
+	}
+
+	// Default to the first port of the service.  An unnamed port can only be
+	// referenced by number.
+	backendPort := networkingv1.ServiceBackendPort{Name: spec.ServicePort}
+	var routePort interface{} = spec.ServicePort
+	if spec.ServicePort == "" {
+		firstPort := getServicePorts(instance)[0]
+		if firstPort.Name != "" {
+			backendPort.Name = firstPort.Name
+			routePort = firstPort.Name
+		} else {
+			backendPort.Number = firstPort.Port
+			routePort = int64(firstPort.TargetPort.IntVal)
+		}
+	}
+
+	var op controllerutil.OperationResult
+	var err error
+
+	if spec.Type == "route" {
+		// Make sure an ingress from a previous type, and a route which was
+		// created under a previous name, are removed
+		if err = deleteStaleObjects(r, instance, &networkingv1.IngressList{}, ""); err != nil {
+			return err
+		}
+		if err = deleteStaleObjects(r, instance, newRouteList(), name); err != nil {
+			return err
+		}
+
+		op, err = controllerutil.CreateOrUpdate(context.TODO(), r.Client, route, func() error {
+			if route.GetResourceVersion() != "" && !metav1.IsControlledBy(route, instance) {
+				return fmt.Errorf("The route %s already exists and is not managed by the operator.", name)
+			}
+
+			termination := spec.Termination
+			if termination == "" {
+				termination = "passthrough"
+			}
+
+			routeSpec, _, _ := unstructured.NestedMap(route.Object, "spec")
+			if routeSpec == nil {
+				routeSpec = map[string]interface{}{}
+			}
+
+			// An empty host is allocated by OpenShift so must not be reset
+			if spec.Host != "" {
+				routeSpec["host"] = spec.Host
+			}
+
+			// A path is not supported by OpenShift for passthrough routes
+			delete(routeSpec, "path")
+			if termination != "passthrough" {
+				routeSpec["path"] = path
+			}
+
+			routeSpec["to"] = map[string]interface{}{
+				"kind":   "Service",
+				"name":   getServiceNameForCR(instance),
+				"weight": int64(100),
+			}
+			routeSpec["port"] = map[string]interface{}{
+				"targetPort": routePort,
+			}
+
+			tlsSpec, _, _ := unstructured.NestedMap(routeSpec, "tls")
+			if tlsSpec == nil {
+				tlsSpec = map[string]interface{}{}
+			}
+			tlsSpec["termination"] = termination
+			tlsSpec["insecureEdgeTerminationPolicy"] = "Redirect"
+			routeSpec["tls"] = tlsSpec
+
+			if err := unstructured.SetNestedMap(route.Object, routeSpec, "spec"); err != nil {
+				return err
+			}
+
+			route.SetLabels(mergeStringMaps(route.GetLabels(), map[string]string{"app": instance.Name}))
+			route.SetAnnotations(mergeManagedAnnotations(route.GetAnnotations(), spec.Annotations))
+
+			return controllerutil.SetControllerReference(instance, route, r.Scheme)
+		})
+
+		if meta.IsNoMatchError(err) {
+			err = fmt.Errorf("A route has been requested but routes are not supported by this cluster.")
+		}
+	} else {
+		// Make sure a route from a previous type, and an ingress which was
+		// created under a previous name, are removed
+		if err = deleteStaleObjects(r, instance, newRouteList(), ""); err != nil {
+			return err
+		}
+		if err = deleteStaleObjects(r, instance, &networkingv1.IngressList{}, name); err != nil {
+			return err
+		}
+
+		op, err = controllerutil.CreateOrUpdate(context.TODO(), r.Client, ingress, func() error {
+			if ingress.ResourceVersion != "" && !metav1.IsControlledBy(ingress, instance) {
+				return fmt.Errorf("The ingress %s already exists and is not managed by the operator.", name)
+			}
+
+			ingress.Labels = mergeStringMaps(ingress.Labels, map[string]string{"app": instance.Name})
+			ingress.Annotations = mergeManagedAnnotations(ingress.Annotations, spec.Annotations)
+
+			pathType := networkingv1.PathTypePrefix
+
+			ingress.Spec.IngressClassName = nil
+			if spec.IngressClassName != "" {
+				className := spec.IngressClassName
+				ingress.Spec.IngressClassName = &className
+			}
+
+			ingress.Spec.TLS = nil
+			if spec.TLSSecret != "" {
+				tls := networkingv1.IngressTLS{
+					SecretName: spec.TLSSecret,
+				}
+				if spec.Host != "" {
+					tls.Hosts = []string{spec.Host}
+				}
+				ingress.Spec.TLS = []networkingv1.IngressTLS{tls}
+			}
+
+			ingress.Spec.Rules = []networkingv1.IngressRule{
+				{
+					Host: spec.Host,
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{
+							Paths: []networkingv1.HTTPIngressPath{
+								{
+									Path:     path,
+									PathType: &pathType,
+									Backend: networkingv1.IngressBackend{
+										Service: &networkingv1.IngressServiceBackend{
+											Name: getServiceNameForCR(instance),
+											Port: backendPort,
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			}
+
+			return controllerutil.SetControllerReference(instance, ingress, r.Scheme)
+		})
+	}
+
+	if err != nil {
+		reqLogger.Error(err, "Failed to reconcile the ingress.")
+		return err
+	}
+
+	if op != controllerutil.OperationResultNone {
+		reqLogger.Info("Reconciled the ingress.", "Ingress", name, "Type", spec.Type, "Operation", op)
+	}
+
+	return nil
+}
+
+/*
+ * Function returns an empty list of OpenShift routes.
+ */
+func newRouteList() *unstructured.UnstructuredList {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(routeGVK.GroupVersion().WithKind(routeGVK.Kind + "List"))
+
+	return list
+}
+
+/*
+ * Function deletes each object of the passed in list type which is controlled
+ * by the IAG instance, other than the object with the passed in name.  This
+ * removes the objects which are no longer required, including an object which
+ * was created under a previous name.  Objects which are not owned by the
+ * operator are left alone.
+ */
+func deleteStaleObjects(r *IBMApplicationGatewayReconciler, instance *ibmv1.IBMApplicationGateway,
+	list client.ObjectList, keep string) error {
+
+	err := r.Client.List(context.TODO(), list, client.InNamespace(instance.Namespace))
+	if err != nil {
+		if meta.IsNoMatchError(err) {
+			// No op. The resource type is not supported by the cluster
+			return nil
+		}
+		return err
+	}
+
+	return meta.EachListItem(list, func(item runtime.Object) error {
+		obj, ok := item.(client.Object)
+		if !ok || obj.GetName() == keep || !metav1.IsControlledBy(obj, instance) {
+			return nil
+		}
+
+		log.Info("Deleting an object which is no longer required.", "Name", obj.GetName())
+
+		return client.IgnoreNotFound(r.Client.Delete(context.TODO(), obj))
+	})
+}
+
+/*
+ * Function returns a copy of the first map with the entries of the second map
+ * added to it.
+ */
+func mergeStringMaps(current map[string]string, additions map[string]string) map[string]string {
+
+	retVal := make(map[string]string)
+
+	for key, value := range current {
+		retVal[key] = value
+	}
+	for key, value := range additions {
+		retVal[key] = value
+	}
+
+	return retVal
+}
+
+/*
+ * Function returns a copy of the current annotations of an object with the
+ * passed in annotations from the custom resource applied to it.  Any
+ * annotation which was applied previously, but is no longer specified, is
+ * removed.  The applied keys are recorded in the managed annotations
+ * annotation.
+ */
+func mergeManagedAnnotations(current map[string]string, annotations map[string]string) map[string]string {
+
+	retVal := mergeStringMaps(current, annotations)
+
+	for _, key := range strings.Split(current[managedAnnotationsKey], ",") {
+		if _, ok := annotations[key]; !ok {
+			delete(retVal, key)
+		}
+	}
+	delete(retVal, managedAnnotationsKey)
+
+	var keys []string
+	for key := range annotations {
+		keys = append(keys, key)
+	}
+
+	if len(keys) > 0 {
+		sort.Strings(keys)
+		retVal[managedAnnotationsKey] = strings.Join(keys, ",")
+	}
+
+	return retVal
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright contributors to the IBM Application Gateway Operator project
+ */
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	corev1 "k8s.io/api/core/v1"
+
+	ibmv1 "github.com/ibm-security/ibm-application-gateway-operator/api/v1"
+)
+
+func TestMergeManagedAnnotations(t *testing.T) {
+	current := map[string]string{"external": "value"}
+
+	current = mergeManagedAnnotations(current, map[string]string{"first": "1", "second": "2"})
+
+	current = mergeManagedAnnotations(current, map[string]string{"second": "two"})
+
+	expected := map[string]string{
+		"external":            "value",
+		"second":              "two",
+		managedAnnotationsKey: "second",
+	}
+	if !reflect.DeepEqual(current, expected) {
+		t.Errorf("Expected %v but got %v", expected, current)
+	}
+
+	current = mergeManagedAnnotations(current, nil)
+	if !reflect.DeepEqual(current, map[string]string{"external": "value"}) {
+		t.Errorf("The managed annotations were not removed : %v", current)
+	}
+}
+
+func TestReconcileRenamedService(t *testing.T) {
+	r, instance := newRevisionTestReconciler(t)
+	instance.Spec.Service = &ibmv1.IBMApplicationGatewayService{Name: "iag-old"}
+
+	if err := reconcileService(r, instance); err != nil {
+		t.Fatal(err)
+	}
+
+	instance.Spec.Service.Name = "iag-new"
+	if err := reconcileService(r, instance); err != nil {
+		t.Fatal(err)
+	}
+
+	services := &corev1.ServiceList{}
+	if err := r.Client.List(context.TODO(), services); err != nil {
+		t.Fatal(err)
+	}
+	if len(services.Items) != 1 || services.Items[0].Name != "iag-new" {
+		t.Errorf("Unexpected services : %v", services.Items)
+	}
+
+	// A service which is not owned by the custom resource is left alone
+	other := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default"}}
+	if err := r.Client.Create(context.TODO(), other); err != nil {
+		t.Fatal(err)
+	}
+
+	instance.Spec.Service = nil
+	if err := reconcileService(r, instance); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Client.Get(context.TODO(), types.NamespacedName{Name: "other", Namespace: "default"},
+		other); err != nil {
+		t.Errorf("The service which is not owned was deleted : %v", err)
+	}
+	if err := r.Client.List(context.TODO(), services); err != nil || len(services.Items) != 1 {
+		t.Errorf("Unexpected services : %v", services.Items)
+	}
+}
@@ -0,0 +1,662 @@
+/*
+ * Copyright contributors to the IBM Application Gateway Operator project
+ */
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	corev1 "k8s.io/api/core/v1"
+
+	ibmv1 "github.com/ibm-security/ibm-application-gateway-operator/api/v1"
+)
+
+// The kinds of object which can be referenced by a configuration source.
+const (
+	sourceReferenceConfigMap = "configmap"
+	sourceReferenceSecret    = "secret"
+)
+
+// An object which is referenced by a configuration source.  The configuration
+// is merged again if the object changes.
+type sourceReference struct {
+	// The kind of the object, either configmap or secret.
+	kind string
+
+	// The namespace of the object, if it is not in the namespace of the
+	// configuration source.
+	namespace string
+
+	// The name of the object.
+	name string
+}
+
+// The context in which the data of a configuration source is fetched.
+type sourceContext struct {
+	// The client which is used to read the referenced objects.
+	client client.Client
+
+	// The namespace and name of the resource which contains the configuration
+	// sources.
+	owner types.NamespacedName
+
+	// Whether the merged configuration is only being previewed, in which case
+	// nothing outside of the operator is changed.
+	preview bool
+
+	// The values which are available to the configuration templates.
+	values *templateValues
+
+	// The config maps and secrets which have been read by the configuration
+	// templates.
+	references []sourceReference
+}
+
+// ConfigurationSource is implemented by each type of configuration source.
+// The same implementation is used by the reconciler, for the configuration
+// sources of a custom resource, and by the sidecar webhook, for the
+// configuration sources which are defined by annotations.
+type ConfigurationSource interface {
+	// Validate returns the errors in the definition of the configuration
+	// source, without fetching any data.
+	Validate(entry *ibmv1.IBMApplicationGatewayConfiguration, fldPath *field.Path) field.ErrorList
+
+	// Fetch returns the configuration data of the source as a normalised
+	// tree, ready to be merged.
+	Fetch(ctx *sourceContext, entry *ibmv1.IBMApplicationGatewayConfiguration) (map[string]interface{}, error)
+
+	// Watches returns the objects which are referenced by the source.
+	Watches(entry *ibmv1.IBMApplicationGatewayConfiguration) []sourceReference
+}
+
+// A registered type of configuration source.
+type sourceRegistration struct {
+	source ConfigurationSource
+
+	// Whether the source is merged after all of the other sources.  Only a
+	// single source of the type may be specified.
+	last bool
+}
+
+// The registered types of configuration source, keyed by type.
+var configurationSources = make(map[string]sourceRegistration)
+
+// The error which is returned if a configuration source cannot be fetched.
+type configurationSourceError struct {
+	sourceType string
+	index      int
+	err        error
+}
+
+func (e *configurationSourceError) Error() string {
+	return e.err.Error()
+}
+
+func (e *configurationSourceError) Unwrap() error {
+	return e.err
+}
+
+func init() {
+	registerConfigurationSource("configmap", configMapSource{}, false)
+	registerConfigurationSource("secret", secretSource{}, false)
+	registerConfigurationSource("web", webSource{}, false)
+	registerConfigurationSource("literal", literalSource{}, false)
+	registerConfigurationSource("oidc_registration", oidcRegistrationSource{}, true)
+}
+
+/*
+ * Function registers a type of configuration source.  If last is true the
+ * source is merged after all of the other sources, and only a single source
+ * of the type may be specified.
+ */
+func registerConfigurationSource(sourceType string, source ConfigurationSource, last bool) {
+	configurationSources[sourceType] = sourceRegistration{source: source, last: last}
+}
+
+/*
+ * Function returns the registered types of configuration source, sorted.
+ */
+func getConfigurationSourceTypes() []string {
+	sourceTypes := make([]string, 0, len(configurationSources))
+	for sourceType := range configurationSources {
+		sourceTypes = append(sourceTypes, sourceType)
+	}
+	sort.Strings(sourceTypes)
+
+	return sourceTypes
+}
+
+/*
+ * Function validates the passed in configuration sources.
+ */
+func validateConfigurationSources(entries []ibmv1.IBMApplicationGatewayConfiguration,
+	fldPath func(idx int) *field.Path) field.ErrorList {
+
+	var allErrs field.ErrorList
+
+	// The path of the first source of each type which is merged last
+	lastPaths := make(map[string]*field.Path)
+
+	for i := range entries {
+		entry := &entries[i]
+		entryPath := fldPath(i)
+
+		if entry.MergeStrategy == mergeStrategyMergeByKey && entry.MergeKey == "" {
+			allErrs = append(allErrs, field.Required(entryPath.Child("mergeKey"),
+				"The mergeKey is required when the mergeStrategy is mergeByKey."))
+		}
+
+		if entry.Namespace != "" {
+			if entry.Type != "configmap" {
+				allErrs = append(allErrs, field.Forbidden(entryPath.Child("namespace"),
+					"The namespace may only be specified for a configmap configuration source."))
+			} else {
+				for _, msg := range validation.IsDNS1123Label(entry.Namespace) {
+					allErrs = append(allErrs, field.Invalid(entryPath.Child("namespace"), entry.Namespace, msg))
+				}
+			}
+		}
+
+		registration, ok := configurationSources[entry.Type]
+		if !ok {
+			allErrs = append(allErrs, field.NotSupported(entryPath.Child("type"), entry.Type,
+				getConfigurationSourceTypes()))
+			continue
+		}
+
+		if entry.Template && !templateSourceTypes[entry.Type] {
+			allErrs = append(allErrs, field.Forbidden(entryPath.Child("template"),
+				"Only a configmap, literal, oidc_registration or web configuration source may be a template."))
+		}
+
+		if registration.last {
+			if lastPath, found := lastPaths[entry.Type]; found {
+				allErrs = append(allErrs, field.Forbidden(entryPath,
+					fmt.Sprintf("Only a single %s configuration source may be specified, "+
+						"one has already been specified at %s.", entry.Type, lastPath.String())))
+			} else {
+				lastPaths[entry.Type] = entryPath
+			}
+		}
+
+		allErrs = append(allErrs, registration.source.Validate(entry, entryPath)...)
+	}
+
+	return allErrs
+}
+
+/*
+ * Function returns the objects of the passed in kind which are referenced by
+ * the passed in configuration sources, which are in the passed in namespace.
+ * The objects in the same namespace are identified by their name, and the
+ * objects in other namespaces by their namespace and name separated by a
+ * slash.  Each object is only returned once.
+ */
+func getSourceReferences(entries []ibmv1.IBMApplicationGatewayConfiguration, kind string,
+	namespace string) []string {
+	var refs []sourceReference
+
+	for i := range entries {
+		registration, ok := configurationSources[entries[i].Type]
+		if !ok {
+			continue
+		}
+
+		refs = append(refs, registration.source.Watches(&entries[i])...)
+	}
+
+	return getReferenceNames(refs, kind, namespace)
+}
+
+/*
+ * Function returns the names of the objects of the passed in kind in the
+ * passed in references, using the same format as getSourceReferences.
+ */
+func getReferenceNames(refs []sourceReference, kind string, namespace string) []string {
+	var names []string
+	found := make(map[string]bool)
+
+	for _, ref := range refs {
+		if ref.kind != kind || ref.name == "" {
+			continue
+		}
+
+		name := ref.name
+		if ref.namespace != "" && ref.namespace != namespace {
+			name = ref.namespace + "/" + ref.name
+		}
+
+		if !found[name] {
+			found[name] = true
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+/*
+ * Function fetches and merges the passed in configuration sources, in order,
+ * and returns the merged configuration.  Any sources which are merged last
+ * are merged after all of the other sources.  The IDs of the sources, if they
+ * were defined by annotations, are only used in the provenance report.
+ */
+func mergeConfigurationSources(ctx *sourceContext, entries []ibmv1.IBMApplicationGatewayConfiguration,
+	ids []string, provenance configProvenance) (map[string]interface{}, error) {
+
+	master := make(map[string]interface{})
+
+	var lastIndexes []int
+
+	merge := func(idx int, opts mergeOptions) error {
+		entry := &entries[idx]
+
+		source := getProvenanceSource(idx, entry)
+		if idx < len(ids) {
+			source.Id = ids[idx]
+		}
+
+		read := len(ctx.references)
+
+		fragment, err := configurationSources[entry.Type].source.Fetch(ctx, entry)
+		if err != nil {
+			return &configurationSourceError{sourceType: entry.Type, index: idx, err: err}
+		}
+
+		// The values which are rendered by a template may contain the data
+		// of any secret which it read
+		source.SecretTemplate = len(getReferenceNames(ctx.references[read:], sourceReferenceSecret,
+			ctx.owner.Namespace)) > 0
+
+		master = mergeConfigTree(fragment, master, opts.withProvenance(provenance, source))
+
+		return nil
+	}
+
+	for idx := range entries {
+		registration, ok := configurationSources[entries[idx].Type]
+		if !ok {
+			return nil, fmt.Errorf("Configuration entry has an invalid type : %s", entries[idx].Type)
+		}
+
+		if registration.last {
+			if len(lastIndexes) > 0 {
+				return nil, fmt.Errorf("Only a single %s configuration source may be specified.", entries[idx].Type)
+			}
+			lastIndexes = append(lastIndexes, idx)
+			continue
+		}
+
+		if err := merge(idx, getMergeOptions(&entries[idx])); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, idx := range lastIndexes {
+		if err := merge(idx, defaultMergeOptions); err != nil {
+			return nil, err
+		}
+	}
+
+	return master, nil
+}
+
+/*
+ * Function parses the passed in YAML document into a normalised tree.
+ */
+func parseConfigTree(data string) (map[string]interface{}, error) {
+	// Unmarshal the new config data string into a Map
+	var parsedYaml map[string]interface{}
+	err := yaml.Unmarshal([]byte(data), &parsedYaml)
+	if err != nil {
+		return nil, err
+	}
+
+	// The parser returns the nested maps with interface keys.  Convert the
+	// whole document to the normalised tree, so that every map, at every
+	// level, has the same type as the master config.
+	tree, _ := asStringMap(normaliseYaml(parsedYaml))
+	if tree == nil {
+		tree = make(map[string]interface{})
+	}
+
+	return tree, nil
+}
+
+/*****************************************************************************/
+
+// The configmap configuration source, which reads the configuration data from
+// a key of a config map.
+type configMapSource struct{}
+
+func (configMapSource) Validate(entry *ibmv1.IBMApplicationGatewayConfiguration,
+	entryPath *field.Path) field.ErrorList {
+
+	var allErrs field.ErrorList
+
+	if entry.Name == "" {
+		allErrs = append(allErrs, field.Required(entryPath.Child("name"),
+			"The name is required for a configmap configuration source."))
+	}
+	if entry.DataKey == "" {
+		allErrs = append(allErrs, field.Required(entryPath.Child("dataKey"),
+			"The dataKey is required for a configmap configuration source."))
+	}
+
+	return allErrs
+}
+
+func (configMapSource) Fetch(ctx *sourceContext,
+	entry *ibmv1.IBMApplicationGatewayConfiguration) (map[string]interface{}, error) {
+
+	if entry.Name == "" {
+		return nil, fmt.Errorf("Configuration configmap entry is missing the Name.")
+	}
+	if entry.DataKey == "" {
+		return nil, fmt.Errorf("Configuration configmap entry is missing the DataKey.")
+	}
+
+	nsn := types.NamespacedName{Name: entry.Name, Namespace: ctx.owner.Namespace}
+
+	// A config map in another namespace may only be read if it has been
+	// granted to the namespace of the configuration source
+	if entry.Namespace != "" && entry.Namespace != ctx.owner.Namespace {
+		nsn.Namespace = entry.Namespace
+
+		granted, err := isConfigGranted(ctx.client, ctx.owner.Namespace, nsn, configGrantKindConfigMap)
+		if err != nil {
+			return nil, err
+		}
+		if !granted {
+			return nil, fmt.Errorf("The config map %s has not been granted to the namespace %s by an "+
+				"IBMApplicationGatewayConfigGrant.", nsn.String(), ctx.owner.Namespace)
+		}
+	}
+
+	// Fetch the config map
+	configMapFound := &corev1.ConfigMap{}
+	err := ctx.client.Get(context.TODO(), nsn, configMapFound)
+	if err != nil {
+		log.Error(err, "Could not find config map : "+entry.Name)
+		return nil, err
+	}
+
+	// Get the config map data pointed at by the data key
+	return parseSourceData(ctx, entry, configMapFound.Data[entry.DataKey])
+}
+
+func (configMapSource) Watches(entry *ibmv1.IBMApplicationGatewayConfiguration) []sourceReference {
+	return []sourceReference{{kind: sourceReferenceConfigMap, namespace: entry.Namespace, name: entry.Name}}
+}
+
+/*****************************************************************************/
+
+// The secret configuration source, which reads the configuration data from a
+// key of a secret.  This is used for configuration data which is sensitive.
+type secretSource struct{}
+
+func (secretSource) Validate(entry *ibmv1.IBMApplicationGatewayConfiguration,
+	entryPath *field.Path) field.ErrorList {
+
+	var allErrs field.ErrorList
+
+	if entry.Name == "" {
+		allErrs = append(allErrs, field.Required(entryPath.Child("name"),
+			"The name is required for a secret configuration source."))
+	}
+	if entry.DataKey == "" {
+		allErrs = append(allErrs, field.Required(entryPath.Child("dataKey"),
+			"The dataKey is required for a secret configuration source."))
+	}
+
+	return allErrs
+}
+
+func (secretSource) Fetch(ctx *sourceContext,
+	entry *ibmv1.IBMApplicationGatewayConfiguration) (map[string]interface{}, error) {
+
+	if entry.Name == "" {
+		return nil, fmt.Errorf("Configuration secret entry is missing the Name.")
+	}
+	if entry.DataKey == "" {
+		return nil, fmt.Errorf("Configuration secret entry is missing the DataKey.")
+	}
+
+	// Fetch the secret
+	secretFound := &corev1.Secret{}
+	err := ctx.client.Get(context.TODO(), types.NamespacedName{Name: entry.Name, Namespace: ctx.owner.Namespace},
+		secretFound)
+	if err != nil {
+		log.Error(err, "Could not find secret : "+entry.Name)
+		return nil, err
+	}
+
+	// Get the secret data pointed at by the data key.  k8s automatically
+	// decodes it from base64
+	data, ok := secretFound.Data[entry.DataKey]
+	if !ok {
+		return nil, fmt.Errorf("The secret : " + entry.Name + " does not have the required key : " + entry.DataKey)
+	}
+
+	return parseSourceData(ctx, entry, string(data))
+}
+
+func (secretSource) Watches(entry *ibmv1.IBMApplicationGatewayConfiguration) []sourceReference {
+	return []sourceReference{{kind: sourceReferenceSecret, name: entry.Name}}
+}
+
+/*****************************************************************************/
+
+// The web configuration source, which retrieves the configuration data from a
+// URL.
+type webSource struct{}
+
+func (webSource) Validate(entry *ibmv1.IBMApplicationGatewayConfiguration,
+	entryPath *field.Path) field.ErrorList {
+
+	return validateWebEntry(entry, entryPath)
+}
+
+func (webSource) Fetch(ctx *sourceContext,
+	entry *ibmv1.IBMApplicationGatewayConfiguration) (map[string]interface{}, error) {
+
+	var iagHeaders []IAGHeader
+
+	for _, header := range entry.Headers {
+		var currHdr IAGHeader
+		currHdr.Name = header.Name
+		currHdr.Type = header.Type
+		currHdr.Value = header.Value
+		currHdr.SecretKey = header.SecretKey
+
+		iagHeaders = append(iagHeaders, currHdr)
+	}
+
+	httpClient, err := getWebSourceClient(ctx.client, ctx.owner.Namespace, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	webData, err := fetchWebSource(ctx.client, ctx.owner, entry.Url, iagHeaders, httpClient)
+	if err != nil {
+		log.Error(err, "Error encountered while attempting to retrieve the web config : "+entry.Url)
+		return nil, err
+	}
+
+	return parseSourceData(ctx, entry, webData)
+}
+
+func (webSource) Watches(entry *ibmv1.IBMApplicationGatewayConfiguration) []sourceReference {
+	var refs []sourceReference
+
+	for _, header := range entry.Headers {
+		if header.Type == "secret" {
+			refs = append(refs, sourceReference{kind: sourceReferenceSecret, name: header.Value})
+		}
+	}
+
+	// The TLS certificates of the source
+	if entry.CASecret != "" {
+		refs = append(refs, sourceReference{kind: sourceReferenceSecret, name: entry.CASecret})
+	}
+	if entry.CAConfigMap != "" {
+		refs = append(refs, sourceReference{kind: sourceReferenceConfigMap, name: entry.CAConfigMap})
+	}
+	if entry.ClientCertSecret != "" {
+		refs = append(refs, sourceReference{kind: sourceReferenceSecret, name: entry.ClientCertSecret})
+	}
+
+	return refs
+}
+
+/*****************************************************************************/
+
+// The literal configuration source, which contains the configuration data.
+type literalSource struct{}
+
+func (literalSource) Validate(entry *ibmv1.IBMApplicationGatewayConfiguration,
+	entryPath *field.Path) field.ErrorList {
+
+	var allErrs field.ErrorList
+
+	// A template is only a valid YAML document once it has been rendered
+	if entry.Template {
+		if _, err := parseConfigTemplate(entry.Value); err != nil {
+			allErrs = append(allErrs, field.Invalid(entryPath.Child("value"), entry.Value,
+				"The literal configuration is not a valid template: "+err.Error()))
+		}
+
+		return allErrs
+	}
+
+	var literal map[string]interface{}
+	if err := yaml.Unmarshal([]byte(entry.Value), &literal); err != nil {
+		allErrs = append(allErrs, field.Invalid(entryPath.Child("value"), entry.Value,
+			"The literal configuration is not a valid YAML document: "+err.Error()))
+	}
+
+	return allErrs
+}
+
+func (literalSource) Fetch(ctx *sourceContext,
+	entry *ibmv1.IBMApplicationGatewayConfiguration) (map[string]interface{}, error) {
+
+	return parseSourceData(ctx, entry, entry.Value)
+}
+
+func (literalSource) Watches(entry *ibmv1.IBMApplicationGatewayConfiguration) []sourceReference {
+	if !entry.Template {
+		return nil
+	}
+
+	return getTemplateReferences(entry.Value)
+}
+
+/*****************************************************************************/
+
+// The oidc_registration configuration source, which registers an OIDC client
+// and returns the identity configuration for the client.
+type oidcRegistrationSource struct{}
+
+func (oidcRegistrationSource) Validate(entry *ibmv1.IBMApplicationGatewayConfiguration,
+	entryPath *field.Path) field.ErrorList {
+
+	var allErrs field.ErrorList
+
+	if entry.Secret == "" {
+		allErrs = append(allErrs, field.Required(entryPath.Child("secret"),
+			"The secret is required for an oidc_registration configuration source."))
+	}
+
+	if entry.Template {
+		for j, postData := range entry.PostData {
+			for _, value := range append([]string{postData.Value}, postData.Values...) {
+				if _, err := parseConfigTemplate(value); err != nil {
+					allErrs = append(allErrs, field.Invalid(entryPath.Child("postData").Index(j), value,
+						"The POST data value is not a valid template: "+err.Error()))
+				}
+			}
+		}
+	}
+
+	return allErrs
+}
+
+func (oidcRegistrationSource) Fetch(ctx *sourceContext,
+	entry *ibmv1.IBMApplicationGatewayConfiguration) (map[string]interface{}, error) {
+
+	oidcReg, err := getOidcRegistration(ctx, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	// The client is not registered when the configuration is only being
+	// previewed, but the identity settings do not depend on the registration
+	if !ctx.preview {
+		if err := registerOidcEntry(ctx.client, oidcReg, ctx.owner.Namespace); err != nil {
+			return nil, err
+		}
+	}
+
+	return getOidcIdentity(oidcReg), nil
+}
+
+func (oidcRegistrationSource) Watches(entry *ibmv1.IBMApplicationGatewayConfiguration) []sourceReference {
+	refs := []sourceReference{{kind: sourceReferenceSecret, name: entry.Secret}}
+
+	if entry.Template {
+		for _, postData := range entry.PostData {
+			for _, value := range append([]string{postData.Value}, postData.Values...) {
+				refs = append(refs, getTemplateReferences(value)...)
+			}
+		}
+	}
+
+	return refs
+}
+
+/*
+ * Function converts an oidc_registration configuration source to the
+ * structure which is used to register the client.  The POST data values are
+ * rendered if the configuration source is a template.
+ */
+func getOidcRegistration(ctx *sourceContext, entry *ibmv1.IBMApplicationGatewayConfiguration) (IAGOidcReg, error) {
+	var oidcReg IAGOidcReg
+	oidcReg.DiscoveryEndpoint = entry.DiscoveryEndpoint
+	oidcReg.Secret = entry.Secret
+
+	// Add Post data to the new struct
+	for _, elem := range entry.PostData {
+		var currPd IAGPostData
+		var err error
+
+		currPd.Name = elem.Name
+		currPd.Value, err = renderConfigTemplate(ctx, entry, elem.Value)
+		if err != nil {
+			return oidcReg, fmt.Errorf("Failed to render the POST data template : %v", err)
+		}
+
+		for _, value := range elem.Values {
+			rendered, err := renderConfigTemplate(ctx, entry, value)
+			if err != nil {
+				return oidcReg, fmt.Errorf("Failed to render the POST data template : %v", err)
+			}
+
+			currPd.Values = append(currPd.Values, rendered)
+		}
+
+		oidcReg.PostData = append(oidcReg.PostData, currPd)
+	}
+
+	return oidcReg, nil
+}
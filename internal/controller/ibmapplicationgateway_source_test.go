@@ -0,0 +1,169 @@
+/*
+ * Copyright contributors to the IBM Application Gateway Operator project
+ */
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	corev1 "k8s.io/api/core/v1"
+
+	ibmv1 "github.com/ibm-security/ibm-application-gateway-operator/api/v1"
+)
+
+func TestMergeRegisteredSources(t *testing.T) {
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "iag-config", Namespace: "default"},
+		Data:       map[string]string{"config": "server:\n  ssl:\n    front_end:\n      tlsv1_3: true\n"},
+	}
+
+	ctx := &sourceContext{
+		client:  fake.NewClientBuilder().WithObjects(configMap).Build(),
+		owner:   types.NamespacedName{Name: "iag-instance", Namespace: "default"},
+		preview: true,
+	}
+
+	// The OIDC registration is merged last, even though it is listed first
+	entries := []ibmv1.IBMApplicationGatewayConfiguration{
+		{Type: "oidc_registration", Secret: "oidc-client", DiscoveryEndpoint: "https://isv/discovery"},
+		{Type: "literal", Value: "identity:\n  oidc:\n    client_id: literal\n"},
+		{Type: "configmap", Name: "iag-config", DataKey: "config"},
+	}
+
+	provenance := make(configProvenance)
+
+	master, err := mergeConfigurationSources(ctx, entries, []string{"oidc", "lit", "cm"}, provenance)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"identity": map[string]interface{}{
+			"oidc": map[string]interface{}{
+				"discovery_endpoint": "https://isv/discovery",
+				"client_id":          "secret:oidc-client/client_id",
+				"client_secret":      "secret:oidc-client/client_secret",
+			},
+		},
+		"server": map[string]interface{}{
+			"ssl": map[string]interface{}{
+				"front_end": map[string]interface{}{
+					"tlsv1_3": true,
+				},
+			},
+		},
+	}
+
+	if !reflect.DeepEqual(master, expected) {
+		t.Errorf("Expected %v but got %v", expected, master)
+	}
+
+	source := provenance["/identity/oidc/client_id"]
+	if source.Type != "oidc_registration" || source.Id != "oidc" || source.Index != 0 {
+		t.Errorf("Unexpected provenance for the client id : %+v", source)
+	}
+
+	// A missing config map is reported as an error of the configmap source
+	entries[2].Name = "missing"
+
+	_, err = mergeConfigurationSources(ctx, entries, nil, nil)
+	sourceErr, ok := err.(*configurationSourceError)
+	if !ok || sourceErr.sourceType != "configmap" || sourceErr.index != 2 {
+		t.Errorf("Expected a configmap source error but got %v", err)
+	}
+}
+
+func TestValidateConfigurationSources(t *testing.T) {
+	entries := []ibmv1.IBMApplicationGatewayConfiguration{
+		{Type: "configmap", Name: "iag-config"},
+		{Type: "oidc_registration", Secret: "first"},
+		{Type: "oidc_registration", Secret: "second"},
+		{Type: "unknown"},
+	}
+
+	allErrs := validateConfiguration(entries, field.NewPath("spec", "configuration"))
+
+	expected := []string{
+		"spec.configuration[0].dataKey",
+		"spec.configuration[2]",
+		"spec.configuration[3].type",
+	}
+
+	if len(allErrs) != len(expected) {
+		t.Fatalf("Expected errors for %v but got %v", expected, allErrs)
+	}
+	for i, err := range allErrs {
+		if err.Field != expected[i] {
+			t.Errorf("Expected an error for %s but got %v", expected[i], err)
+		}
+	}
+}
+
+func TestGetSourceReferences(t *testing.T) {
+	entries := []ibmv1.IBMApplicationGatewayConfiguration{
+		{Type: "configmap", Name: "iag-config"},
+		{Type: "web", Url: "https://example.com", Headers: []ibmv1.IBMApplicationGatewayHeaders{
+			{Type: "secret", Name: "Authorization", Value: "web-token", SecretKey: "value"},
+			{Type: "literal", Name: "Accept", Value: "application/yaml"},
+		}},
+		{Type: "oidc_registration", Secret: "oidc-client"},
+		{Type: "configmap", Name: "iag-config"},
+	}
+
+	if refs := getSourceReferences(entries, sourceReferenceConfigMap, "default"); !reflect.DeepEqual(refs, []string{"iag-config"}) {
+		t.Errorf("Unexpected config map references : %v", refs)
+	}
+
+	if refs := getSourceReferences(entries, sourceReferenceSecret, "default"); !reflect.DeepEqual(refs, []string{"web-token", "oidc-client"}) {
+		t.Errorf("Unexpected secret references : %v", refs)
+	}
+}
+
+func TestSecretSource(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "iag-credentials", Namespace: "default"},
+		Data:       map[string][]byte{"config": []byte("resource_servers:\n  - path: /app\n    password: passw0rd\n")},
+	}
+
+	ctx := &sourceContext{
+		client: fake.NewClientBuilder().WithObjects(secret).Build(),
+		owner:  types.NamespacedName{Name: "iag-instance", Namespace: "default"},
+	}
+
+	entry := ibmv1.IBMApplicationGatewayConfiguration{Type: "secret", Name: "iag-credentials", DataKey: "config"}
+
+	config, err := secretSource{}.Fetch(ctx, &entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"resource_servers": []interface{}{
+			map[string]interface{}{"path": "/app", "password": "passw0rd"},
+		},
+	}
+
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("Expected %v but got %v", expected, config)
+	}
+
+	// A missing key is an error, rather than an empty configuration
+	entry.DataKey = "missing"
+	if _, err := (secretSource{}).Fetch(ctx, &entry); err == nil {
+		t.Errorf("Expected an error for a missing key")
+	}
+
+	// The secret is watched for changes
+	entries := []ibmv1.IBMApplicationGatewayConfiguration{entry}
+	if refs := getSourceReferences(entries, sourceReferenceSecret, "default"); !reflect.DeepEqual(refs, []string{"iag-credentials"}) {
+		t.Errorf("Unexpected secret references : %v", refs)
+	}
+}
@@ -0,0 +1,134 @@
+/*
+ * Copyright contributors to the IBM Application Gateway Operator project
+ */
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ibmv1 "github.com/ibm-security/ibm-application-gateway-operator/api/v1"
+)
+
+// The reasons which are used for the status conditions.
+const (
+	reasonConfigMerged          = "ConfigMerged"
+	reasonConfigMergeFailed     = "ConfigMergeFailed"
+	reasonConfigInvalid         = "ConfigInvalid"
+	reasonConfigNotValidated    = "ConfigNotValidated"
+	reasonConfigRevisionPinned  = "ConfigRevisionPinned"
+	reasonOidcRegistered        = "ClientRegistered"
+	reasonOidcRegistrationFail  = "RegistrationFailed"
+	reasonDeploymentAvailable   = "MinimumReplicasAvailable"
+	reasonDeploymentUnavailable = "MinimumReplicasUnavailable"
+	reasonDeploymentProgressing = "RolloutInProgress"
+	reasonReady                 = "GatewayReady"
+	reasonReconcileFailed       = "ReconcileFailed"
+	reasonReconcileSucceeded    = "ReconcileSucceeded"
+)
+
+/*
+ * Function sets a single condition in the status of the custom resource.  The
+ * transition time is only changed if the status of the condition changes.
+ */
+func setCondition(instance *ibmv1.IBMApplicationGateway, condType string,
+	status metav1.ConditionStatus, reason string, message string) {
+
+	meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+		Type:               condType,
+		Status:             status,
+		ObservedGeneration: instance.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+/*
+ * Function updates the status of the custom resource from the current state of
+ * the owned deployment and the generated configuration, and then saves the
+ * status.
+ */
+func updateStatus(r *IBMApplicationGatewayReconciler, instance *ibmv1.IBMApplicationGateway,
+	dply *appsv1.Deployment, cmName string) error {
+
+	instance.Status.ObservedGeneration = instance.Generation
+	instance.Status.ConfigMapName = cmName
+
+	// The resource remains degraded while a failed configuration revision
+	// has been rolled back
+	if instance.Status.FailedConfigHash != "" {
+		setCondition(instance, ibmv1.ConditionDegraded, metav1.ConditionTrue, reasonConfigRolledBack,
+			fmt.Sprintf("Configuration revision %d failed to become ready and was rolled back to revision %d.",
+				instance.Status.FailedConfigRevision, instance.Status.ConfigRevision))
+	} else if isRolloutFailed(dply) {
+		// The rollout failed along with other changes to the deployment, so
+		// the configuration revision was not rolled back
+		setCondition(instance, ibmv1.ConditionDegraded, metav1.ConditionTrue, reasonRolloutFailed,
+			fmt.Sprintf("The rollout of configuration revision %d exceeded its progress deadline.  The revision "+
+				"was not rolled back as the deployment also contains other changes.", getDeployedConfigRevision(dply)))
+	} else {
+		setCondition(instance, ibmv1.ConditionDegraded, metav1.ConditionFalse,
+			reasonReconcileSucceeded, "The resource was successfully reconciled.")
+	}
+
+	// Mirror the replica counts from the deployment
+	instance.Status.Replicas = dply.Status.Replicas
+	instance.Status.ReadyReplicas = dply.Status.ReadyReplicas
+	instance.Status.AvailableReplicas = dply.Status.AvailableReplicas
+
+	// Mirror the available condition from the deployment
+	available := false
+	for _, cond := range dply.Status.Conditions {
+		if cond.Type == appsv1.DeploymentAvailable {
+			available = cond.Status == corev1.ConditionTrue
+			break
+		}
+	}
+
+	if available {
+		setCondition(instance, ibmv1.ConditionDeploymentAvailable, metav1.ConditionTrue,
+			reasonDeploymentAvailable, "The deployment has the minimum number of available replicas.")
+	} else {
+		setCondition(instance, ibmv1.ConditionDeploymentAvailable, metav1.ConditionFalse,
+			reasonDeploymentUnavailable, "The deployment does not have the minimum number of available replicas.")
+	}
+
+	// The configuration version is only reported once the rollout of the
+	// current pod template has completed.
+	desired := instance.Spec.Replicas
+	if dply.Spec.Replicas != nil {
+		desired = *dply.Spec.Replicas
+	}
+
+	rolledOut := dply.Status.ObservedGeneration >= dply.Generation &&
+		dply.Status.UpdatedReplicas == desired &&
+		dply.Status.Replicas == desired
+
+	if rolledOut {
+		instance.Status.ConfigVersion = dply.Spec.Template.Labels[configVersionLabelKey]
+	}
+
+	if rolledOut && available && dply.Status.ReadyReplicas == desired {
+		// Remember the configuration revision so that it can be rolled back to,
+		// along with the pod template which it was deployed with
+		if revision := getDeployedConfigRevision(dply); revision > 0 {
+			instance.Status.HealthyConfigRevision = revision
+			instance.Status.HealthyPodTemplateHash = dply.Spec.Template.Annotations[podTemplateHashAnnotationKey]
+		}
+
+		setCondition(instance, ibmv1.ConditionReady, metav1.ConditionTrue,
+			reasonReady, fmt.Sprintf("%d of %d replicas are ready.", dply.Status.ReadyReplicas, desired))
+	} else {
+		setCondition(instance, ibmv1.ConditionReady, metav1.ConditionFalse,
+			reasonDeploymentProgressing, fmt.Sprintf("%d of %d replicas are ready.", dply.Status.ReadyReplicas, desired))
+	}
+
+	return r.Client.Status().Update(context.TODO(), instance)
+}
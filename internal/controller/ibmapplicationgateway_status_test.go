@@ -0,0 +1,218 @@
+/*
+ * Copyright contributors to the IBM Application Gateway Operator project
+ */
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ibmv1 "github.com/ibm-security/ibm-application-gateway-operator/api/v1"
+)
+
+/*
+ * Function returns a reconciler whose fake client contains a single custom
+ * resource, along with the custom resource.
+ */
+func newStatusTestReconciler(t *testing.T) (*IBMApplicationGatewayReconciler, *ibmv1.IBMApplicationGateway) {
+	t.Helper()
+
+	instance := &ibmv1.IBMApplicationGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "iag-instance", Namespace: "default", UID: "1234"},
+	}
+
+	rclient := newTestClient(t, instance)
+
+	r := &IBMApplicationGatewayReconciler{
+		Client: rclient,
+		Scheme: rclient.Scheme(),
+	}
+
+	return r, instance
+}
+
+/*
+ * Function returns a deployment with the passed in number of desired and
+ * ready replicas, whose rollout has completed.
+ */
+func newStatusTestDeployment(desired int32, ready int32) *appsv1.Deployment {
+	available := corev1.ConditionFalse
+	if ready > 0 {
+		available = corev1.ConditionTrue
+	}
+
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "iag-instance", Namespace: "default", Generation: 4},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &desired,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+					configVersionLabelKey:  "0123456789abcdef",
+					configRevisionLabelKey: "3",
+				}},
+			},
+		},
+		Status: appsv1.DeploymentStatus{
+			ObservedGeneration: 4,
+			Replicas:           desired,
+			UpdatedReplicas:    desired,
+			ReadyReplicas:      ready,
+			AvailableReplicas:  ready,
+			Conditions: []appsv1.DeploymentCondition{
+				{Type: appsv1.DeploymentAvailable, Status: available},
+			},
+		},
+	}
+}
+
+func TestUpdateStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		ready    int32
+		failed   string
+		expected map[string]metav1.ConditionStatus
+	}{
+		{name: "all of the replicas are ready", ready: 2,
+			expected: map[string]metav1.ConditionStatus{
+				ibmv1.ConditionReady:               metav1.ConditionTrue,
+				ibmv1.ConditionDegraded:            metav1.ConditionFalse,
+				ibmv1.ConditionDeploymentAvailable: metav1.ConditionTrue,
+			}},
+		{name: "some of the replicas are ready", ready: 1,
+			expected: map[string]metav1.ConditionStatus{
+				ibmv1.ConditionReady:               metav1.ConditionFalse,
+				ibmv1.ConditionDegraded:            metav1.ConditionFalse,
+				ibmv1.ConditionDeploymentAvailable: metav1.ConditionTrue,
+			}},
+		{name: "none of the replicas are ready", ready: 0,
+			expected: map[string]metav1.ConditionStatus{
+				ibmv1.ConditionReady:               metav1.ConditionFalse,
+				ibmv1.ConditionDegraded:            metav1.ConditionFalse,
+				ibmv1.ConditionDeploymentAvailable: metav1.ConditionFalse,
+			}},
+		{name: "a revision has been rolled back", ready: 2, failed: "abcdef",
+			expected: map[string]metav1.ConditionStatus{
+				ibmv1.ConditionReady:               metav1.ConditionTrue,
+				ibmv1.ConditionDegraded:            metav1.ConditionTrue,
+				ibmv1.ConditionDeploymentAvailable: metav1.ConditionTrue,
+			}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, instance := newStatusTestReconciler(t)
+			instance.Generation = 7
+			instance.Status.FailedConfigHash = test.failed
+
+			dply := newStatusTestDeployment(2, test.ready)
+
+			if err := updateStatus(r, instance, dply, "iag-instance-config"); err != nil {
+				t.Fatal(err)
+			}
+
+			stored := &ibmv1.IBMApplicationGateway{}
+			if err := r.Client.Get(context.TODO(), types.NamespacedName{Name: instance.Name,
+				Namespace: instance.Namespace}, stored); err != nil {
+				t.Fatal(err)
+			}
+
+			if stored.Status.ObservedGeneration != 7 || stored.Status.ConfigMapName != "iag-instance-config" {
+				t.Errorf("Unexpected status : %+v", stored.Status)
+			}
+			if stored.Status.Replicas != 2 || stored.Status.ReadyReplicas != test.ready ||
+				stored.Status.AvailableReplicas != test.ready {
+				t.Errorf("Unexpected replica counts : %+v", stored.Status)
+			}
+			if stored.Status.ConfigVersion != "0123456789abcdef" {
+				t.Errorf("Expected the configuration version of the rolled out deployment but got %s",
+					stored.Status.ConfigVersion)
+			}
+
+			for condType, status := range test.expected {
+				cond := meta.FindStatusCondition(stored.Status.Conditions, condType)
+				if cond == nil || cond.Status != status || cond.ObservedGeneration != 7 {
+					t.Errorf("Expected the %s condition to be %s but got %+v", condType, status, cond)
+				}
+			}
+
+			healthy := int64(0)
+			if test.ready == 2 {
+				healthy = 3
+			}
+			if stored.Status.HealthyConfigRevision != healthy {
+				t.Errorf("Expected healthy revision %d but got %d", healthy, stored.Status.HealthyConfigRevision)
+			}
+		})
+	}
+}
+
+func TestUpdateStatusDuringRollout(t *testing.T) {
+	r, instance := newStatusTestReconciler(t)
+
+	dply := newStatusTestDeployment(2, 2)
+	dply.Generation = 5
+	dply.Spec.Template.Labels[configVersionLabelKey] = "fedcba9876543210"
+
+	if err := updateStatus(r, instance, dply, "iag-instance-config"); err != nil {
+		t.Fatal(err)
+	}
+
+	if instance.Status.ConfigVersion != "" {
+		t.Errorf("Expected the configuration version not to be reported before the rollout completes but got %s",
+			instance.Status.ConfigVersion)
+	}
+	if !meta.IsStatusConditionFalse(instance.Status.Conditions, ibmv1.ConditionReady) {
+		t.Errorf("Expected the resource not to be ready during the rollout")
+	}
+}
+
+func TestManageError(t *testing.T) {
+	r, instance := newStatusTestReconciler(t)
+	recorder := record.NewFakeRecorder(10)
+	r.EventRecorder = recorder
+
+	if err := updateStatus(r, instance, newStatusTestDeployment(1, 1), "iag-instance-config"); err != nil {
+		t.Fatal(err)
+	}
+
+	if !meta.IsStatusConditionFalse(instance.Status.Conditions, ibmv1.ConditionDegraded) {
+		t.Fatalf("Expected the resource not to be degraded")
+	}
+
+	instance.Generation = 2
+
+	if _, err := manageError(r, instance, fmt.Errorf("The merge failed.")); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := &ibmv1.IBMApplicationGateway{}
+	if err := r.Client.Get(context.TODO(), types.NamespacedName{Name: instance.Name,
+		Namespace: instance.Namespace}, stored); err != nil {
+		t.Fatal(err)
+	}
+
+	degraded := meta.FindStatusCondition(stored.Status.Conditions, ibmv1.ConditionDegraded)
+	if degraded == nil || degraded.Status != metav1.ConditionTrue || degraded.Reason != reasonReconcileFailed ||
+		degraded.Message != "The merge failed." {
+		t.Errorf("Expected the resource to be degraded but got %+v", degraded)
+	}
+	if !meta.IsStatusConditionFalse(stored.Status.Conditions, ibmv1.ConditionReady) {
+		t.Errorf("Expected the resource not to be ready")
+	}
+	if stored.Status.ObservedGeneration != 2 {
+		t.Errorf("Expected observed generation 2 but got %d", stored.Status.ObservedGeneration)
+	}
+	if len(recorder.Events) != 1 {
+		t.Errorf("Expected a single event but got %d", len(recorder.Events))
+	}
+}
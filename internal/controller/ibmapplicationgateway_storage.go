@@ -0,0 +1,145 @@
+/*
+ * Copyright contributors to the IBM Application Gateway Operator project
+ */
+
+package controllers
+
+import (
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	ibmv1 "github.com/ibm-security/ibm-application-gateway-operator/api/v1"
+)
+
+// The types of object which the generated configuration can be stored in.
+const (
+	configStorageConfigMap = "configmap"
+	configStorageSecret    = "secret"
+)
+
+// The name of the volume which contains the generated configuration.
+const configVolumeName = "iag-config"
+
+/*
+ * Function returns the type of object which the generated configuration of
+ * the custom resource is stored in.  If the storage has not been specified a
+ * secret is used if any of the configuration sources contain secret data, so
+ * that the secret data is not copied into a config map.
+ */
+func getConfigStorage(instance *ibmv1.IBMApplicationGateway) string {
+	switch instance.Spec.Deployment.ConfigStorage {
+	case configStorageSecret:
+		return configStorageSecret
+	case configStorageConfigMap:
+		return configStorageConfigMap
+	}
+
+	if hasSecretSource(instance.Spec.Configuration) {
+		return configStorageSecret
+	}
+
+	return configStorageConfigMap
+}
+
+/*
+ * Function returns true if any of the passed in configuration sources is of
+ * a type which contains secret data.
+ */
+func hasSecretSource(entries []ibmv1.IBMApplicationGatewayConfiguration) bool {
+	for i := range entries {
+		if secretSourceTypes[entries[i].Type] {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+ * Function returns the type of object which contains generated configuration.
+ */
+func getObjectConfigStorage(object client.Object) string {
+	if _, ok := object.(*corev1.Secret); ok {
+		return configStorageSecret
+	}
+
+	return configStorageConfigMap
+}
+
+/*
+ * Function returns the data of a generated config map or secret.
+ */
+func getConfigData(object client.Object) map[string]string {
+	switch v := object.(type) {
+	case *corev1.ConfigMap:
+		return v.Data
+	case *corev1.Secret:
+		data := make(map[string]string, len(v.Data)+len(v.StringData))
+		for key, value := range v.Data {
+			data[key] = string(value)
+		}
+		for key, value := range v.StringData {
+			data[key] = value
+		}
+		return data
+	}
+
+	return nil
+}
+
+/*
+ * Function returns a secret which contains the same generated configuration,
+ * and metadata, as the passed in config map.
+ */
+func newConfigSecret(configMap *corev1.ConfigMap) *corev1.Secret {
+	secret := &corev1.Secret{
+		ObjectMeta: *configMap.ObjectMeta.DeepCopy(),
+		Type:       corev1.SecretTypeOpaque,
+		Immutable:  configMap.Immutable,
+		Data:       make(map[string][]byte, len(configMap.Data)),
+	}
+
+	for key, value := range configMap.Data {
+		secret.Data[key] = []byte(value)
+	}
+
+	return secret
+}
+
+/*
+ * Function returns the source of the volume which contains the generated
+ * configuration.
+ */
+func getConfigVolumeSource(name string, storage string) corev1.VolumeSource {
+	if storage == configStorageSecret {
+		return corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: name,
+			},
+		}
+	}
+
+	return corev1.VolumeSource{
+		ConfigMap: &corev1.ConfigMapVolumeSource{
+			LocalObjectReference: corev1.LocalObjectReference{
+				Name: name,
+			},
+		},
+	}
+}
+
+/*
+ * Function returns the type of object which contains the generated
+ * configuration that is mounted by the passed in deployment.
+ */
+func getDeployedConfigStorage(dply *appsv1.Deployment) string {
+	for _, volume := range dply.Spec.Template.Spec.Volumes {
+		if volume.Name == configVolumeName && volume.Secret != nil {
+			return configStorageSecret
+		}
+	}
+
+	return configStorageConfigMap
+}
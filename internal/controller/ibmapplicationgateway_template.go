@@ -0,0 +1,352 @@
+/*
+ * Copyright contributors to the IBM Application Gateway Operator project
+ */
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ibmv1 "github.com/ibm-security/ibm-application-gateway-operator/api/v1"
+)
+
+// The names of the template functions.
+const (
+	templateFuncConfigMapKey = "configMapKey"
+	templateFuncSecretKey    = "secretKey"
+	templateFuncEnv          = "env"
+	templateFuncQuote        = "quote"
+	templateFuncToYaml       = "toYaml"
+)
+
+// The types of configuration source which may be a template.  The data of a
+// secret source is not rendered.
+var templateSourceTypes = map[string]bool{
+	"configmap":         true,
+	"literal":           true,
+	"oidc_registration": true,
+	"web":               true,
+}
+
+// The values which are available to a configuration template.
+type templateValues struct {
+	// The namespace of the object which owns the configuration sources.
+	Namespace string
+
+	// The name of the object which owns the configuration sources.
+	Name string
+
+	// The labels of the object which owns the configuration sources.
+	Labels map[string]string
+
+	// The annotations of the object which owns the configuration sources.
+	Annotations map[string]string
+
+	// The name of the service which exposes the gateway, if there is one.
+	ServiceName string
+
+	// The host name of the service which exposes the gateway, if there is
+	// one.
+	ServiceHost string
+
+	// The environment variables of the gateway container, which are
+	// returned by the env function.
+	env map[string]string
+}
+
+/*
+ * Function returns the template values for the configuration sources of the
+ * passed in custom resource.  Only the environment variables which have a
+ * literal value are available to the template.
+ */
+func getTemplateValues(instance *ibmv1.IBMApplicationGateway) *templateValues {
+	serviceName := getServiceNameForCR(instance)
+
+	values := &templateValues{
+		Namespace:   instance.Namespace,
+		Name:        instance.Name,
+		Labels:      instance.Labels,
+		Annotations: instance.Annotations,
+		ServiceName: serviceName,
+		ServiceHost: serviceName + "." + instance.Namespace + ".svc",
+		env:         make(map[string]string),
+	}
+
+	for _, env := range instance.Spec.Deployment.Env {
+		if env.ValueFrom == nil {
+			values.env[env.Name] = env.Value
+		}
+	}
+
+	return values
+}
+
+/*
+ * Function returns the template values for the configuration sources which
+ * are defined by the annotations of the object in the passed in admission
+ * request.  The environment variables are taken from the passed in
+ * annotations, which include the defaults of the class.  The service name is
+ * empty if no service has been created.
+ */
+func getWebhookTemplateValues(req *admissionv1.AdmissionRequest, annots map[string]string,
+	serviceName string) *templateValues {
+	var object metav1.PartialObjectMetadata
+	if err := json.Unmarshal(req.Object.Raw, &object); err != nil {
+		log.Error(err, "Failed to decode the metadata of the admission request object.")
+	}
+
+	values := &templateValues{
+		Namespace:   req.Namespace,
+		Name:        req.Name,
+		Labels:      object.Labels,
+		Annotations: object.Annotations,
+		ServiceName: serviceName,
+		env:         getAnnotEnv(annots),
+	}
+
+	if serviceName != "" {
+		values.ServiceHost = serviceName + "." + req.Namespace + ".svc"
+	}
+
+	return values
+}
+
+/*
+ * Function returns the restricted set of functions which are available to the
+ * template of the passed in configuration source.  The config maps and
+ * secrets are read from the namespace of the configuration sources.  The data
+ * of a web source, or of a config map in another namespace, is not owned by
+ * the namespace and so is not trusted to read its secrets.  A web source may
+ * not read its config maps either.  All of the functions are returned if no
+ * configuration source is passed in.  The quote and toYaml functions render a
+ * value as a single YAML scalar, or flow collection, so that a value which
+ * contains special characters cannot change the structure of the rendered
+ * document.
+ */
+func getTemplateFuncs(ctx *sourceContext, entry *ibmv1.IBMApplicationGatewayConfiguration) template.FuncMap {
+	funcs := template.FuncMap{
+		templateFuncConfigMapKey: func(name string, key string) (string, error) {
+			ctx.references = append(ctx.references, sourceReference{kind: sourceReferenceConfigMap, name: name})
+
+			configMap := &corev1.ConfigMap{}
+			err := ctx.client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: ctx.owner.Namespace},
+				configMap)
+			if err != nil {
+				return "", err
+			}
+
+			value, ok := configMap.Data[key]
+			if !ok {
+				return "", fmt.Errorf("The config map : " + name + " does not have the required key : " + key)
+			}
+
+			return value, nil
+		},
+		templateFuncSecretKey: func(name string, key string) (string, error) {
+			ctx.references = append(ctx.references, sourceReference{kind: sourceReferenceSecret, name: name})
+
+			secret := &corev1.Secret{}
+			err := ctx.client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: ctx.owner.Namespace},
+				secret)
+			if err != nil {
+				return "", err
+			}
+
+			value, ok := secret.Data[key]
+			if !ok {
+				return "", fmt.Errorf("The secret : " + name + " does not have the required key : " + key)
+			}
+
+			return string(value), nil
+		},
+		templateFuncEnv: func(name string) (string, error) {
+			if ctx.values == nil {
+				return "", fmt.Errorf("The environment variable : " + name + " is not defined.")
+			}
+
+			value, ok := ctx.values.env[name]
+			if !ok {
+				return "", fmt.Errorf("The environment variable : " + name + " is not defined.")
+			}
+
+			return value, nil
+		},
+		templateFuncQuote: func(value interface{}) (string, error) {
+			return toInlineYaml(fmt.Sprint(value))
+		},
+		templateFuncToYaml: toInlineYaml,
+	}
+
+	if entry != nil {
+		if entry.Type == "web" {
+			delete(funcs, templateFuncConfigMapKey)
+			delete(funcs, templateFuncSecretKey)
+		} else if entry.Namespace != "" && entry.Namespace != ctx.owner.Namespace {
+			delete(funcs, templateFuncSecretKey)
+		}
+	}
+
+	return funcs
+}
+
+/*
+ * Function returns the passed in value as an inline YAML value.  JSON is used,
+ * as every JSON document is also a valid YAML flow value.
+ */
+func toInlineYaml(value interface{}) (string, error) {
+	var buffer bytes.Buffer
+
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+
+	if err := encoder.Encode(value); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(buffer.String(), "\n"), nil
+}
+
+/*
+ * Function parses the passed in configuration template.  The template
+ * functions are not called, so no context is required.
+ */
+func parseConfigTemplate(data string) (*template.Template, error) {
+	return template.New("configuration").
+		Funcs(getTemplateFuncs(&sourceContext{}, nil)).
+		Option("missingkey=error").
+		Parse(data)
+}
+
+/*
+ * Function renders the passed in configuration data if the configuration
+ * source is a template, and returns the data unchanged if it is not.
+ */
+func renderConfigTemplate(ctx *sourceContext, entry *ibmv1.IBMApplicationGatewayConfiguration,
+	data string) (string, error) {
+
+	if !entry.Template {
+		return data, nil
+	}
+
+	if !templateSourceTypes[entry.Type] {
+		return "", fmt.Errorf("A %s configuration source may not be a template.", entry.Type)
+	}
+
+	tmpl, err := template.New("configuration").
+		Funcs(getTemplateFuncs(ctx, entry)).
+		Option("missingkey=error").
+		Parse(data)
+	if err != nil {
+		return "", err
+	}
+
+	values := ctx.values
+	if values == nil {
+		values = &templateValues{Namespace: ctx.owner.Namespace, Name: ctx.owner.Name}
+	}
+
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, values); err != nil {
+		return "", err
+	}
+
+	return rendered.String(), nil
+}
+
+/*
+ * Function renders the passed in configuration data, if the configuration
+ * source is a template, and parses the result into a normalised tree.
+ */
+func parseSourceData(ctx *sourceContext, entry *ibmv1.IBMApplicationGatewayConfiguration,
+	data string) (map[string]interface{}, error) {
+
+	rendered, err := renderConfigTemplate(ctx, entry, data)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to render the %s configuration template : %v", entry.Type, err)
+	}
+
+	return parseConfigTree(rendered)
+}
+
+/*
+ * Function returns the config maps and secrets which are read by the passed
+ * in configuration template.  Only the references which use a constant name
+ * can be found.
+ */
+func getTemplateReferences(data string) []sourceReference {
+	tmpl, err := parseConfigTemplate(data)
+	if err != nil || tmpl.Tree == nil {
+		return nil
+	}
+
+	var refs []sourceReference
+
+	var walk func(node parse.Node)
+	walk = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				walk(child)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.IfNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.RangeNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.WithNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.TemplateNode:
+			walk(n.Pipe)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, cmd := range n.Cmds {
+				walk(cmd)
+			}
+		case *parse.CommandNode:
+			if len(n.Args) > 1 {
+				ident, isIdent := n.Args[0].(*parse.IdentifierNode)
+				name, isString := n.Args[1].(*parse.StringNode)
+
+				if isIdent && isString {
+					switch ident.Ident {
+					case templateFuncConfigMapKey:
+						refs = append(refs, sourceReference{kind: sourceReferenceConfigMap, name: name.Text})
+					case templateFuncSecretKey:
+						refs = append(refs, sourceReference{kind: sourceReferenceSecret, name: name.Text})
+					}
+				}
+			}
+			for _, arg := range n.Args {
+				walk(arg)
+			}
+		}
+	}
+
+	walk(tmpl.Tree.Root)
+
+	return refs
+}
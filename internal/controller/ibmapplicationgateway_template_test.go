@@ -0,0 +1,277 @@
+/*
+ * Copyright contributors to the IBM Application Gateway Operator project
+ */
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	corev1 "k8s.io/api/core/v1"
+
+	ibmv1 "github.com/ibm-security/ibm-application-gateway-operator/api/v1"
+)
+
+/*
+ * Function returns a source context for the templates of a custom resource,
+ * which uses a fake client containing the passed in objects.
+ */
+func newTemplateTestContext(instance *ibmv1.IBMApplicationGateway) *sourceContext {
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-settings", Namespace: "default"},
+		Data:       map[string]string{"host": "app.default.svc"},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-credentials", Namespace: "default"},
+		Data:       map[string][]byte{"password": []byte("passw0rd")},
+	}
+
+	return &sourceContext{
+		client: fake.NewClientBuilder().WithObjects(configMap, secret).Build(),
+		owner:  types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace},
+		values: getTemplateValues(instance),
+	}
+}
+
+func TestRenderConfigTemplate(t *testing.T) {
+	instance := &ibmv1.IBMApplicationGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "iag-instance", Namespace: "default",
+			Labels: map[string]string{"tier": "edge"}},
+	}
+	instance.Spec.Deployment.Env = []corev1.EnvVar{{Name: "APP_PORT", Value: "8080"}}
+
+	ctx := newTemplateTestContext(instance)
+
+	entry := ibmv1.IBMApplicationGatewayConfiguration{
+		Type:     "literal",
+		Template: true,
+		Value: `server:
+  hostname: {{ .ServiceHost }}
+resource_servers:
+  - path: /{{ .Labels.tier }}
+    servers:
+      - host: {{ configMapKey "app-settings" "host" }}
+        port: {{ env "APP_PORT" }}
+    identity_headers:
+      basic_auth:
+        password: {{ secretKey "app-credentials" "password" | printf "%q" }}
+`,
+	}
+
+	config, err := literalSource{}.Fetch(ctx, &entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := parseTestYaml(t, `server:
+  hostname: iag-instance.default.svc
+resource_servers:
+  - path: /edge
+    servers:
+      - host: app.default.svc
+        port: 8080
+    identity_headers:
+      basic_auth:
+        password: passw0rd
+`)
+
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("Expected %v but got %v", expected, config)
+	}
+
+	// The data is not rendered unless the source is a template
+	entry.Template = false
+	entry.Value = "server:\n  hostname: \"{{ .ServiceHost }}\"\n"
+
+	config, err = literalSource{}.Fetch(ctx, &entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hostname := config["server"].(map[string]interface{})["hostname"]; hostname != "{{ .ServiceHost }}" {
+		t.Errorf("Expected the data to be unchanged but got %v", hostname)
+	}
+
+	errorTests := []struct {
+		name  string
+		value string
+	}{
+		{name: "an unknown value", value: "a: {{ .Unknown }}"},
+		{name: "an unknown environment variable", value: "a: {{ env \"UNKNOWN\" }}"},
+		{name: "a missing config map key", value: "a: {{ configMapKey \"app-settings\" \"missing\" }}"},
+		{name: "a missing secret", value: "a: {{ secretKey \"missing\" \"password\" }}"},
+	}
+
+	for _, test := range errorTests {
+		entry := ibmv1.IBMApplicationGatewayConfiguration{Type: "literal", Template: true, Value: test.value}
+		if _, err := (literalSource{}).Fetch(ctx, &entry); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
+
+func TestRenderQuotedTemplateValues(t *testing.T) {
+	instance := &ibmv1.IBMApplicationGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "iag-instance", Namespace: "default",
+			Labels: map[string]string{"tier": "edge: true\ninjected: \"yes\""}},
+	}
+
+	ctx := newTemplateTestContext(instance)
+
+	entry := ibmv1.IBMApplicationGatewayConfiguration{
+		Type:     "literal",
+		Template: true,
+		Value: `server:
+  hostname: {{ .Labels.tier | quote }}
+  labels: {{ .Labels | toYaml }}
+  password: {{ secretKey "app-credentials" "password" | quote }}
+`,
+	}
+
+	config, err := literalSource{}.Fetch(ctx, &entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"server": map[string]interface{}{
+			"hostname": "edge: true\ninjected: \"yes\"",
+			"labels":   map[string]interface{}{"tier": "edge: true\ninjected: \"yes\""},
+			"password": "passw0rd",
+		},
+	}
+
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("Expected %v but got %v", expected, config)
+	}
+}
+
+func TestRenderPostDataTemplate(t *testing.T) {
+	instance := &ibmv1.IBMApplicationGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "iag-instance", Namespace: "default"},
+	}
+
+	ctx := newTemplateTestContext(instance)
+
+	entry := ibmv1.IBMApplicationGatewayConfiguration{
+		Type:     "oidc_registration",
+		Secret:   "oidc-client",
+		Template: true,
+		PostData: []ibmv1.IBMApplicationGatewayPostData{
+			{Name: "client_name", Value: "{{ .Namespace }}-{{ .Name }}"},
+			{Name: "redirect_uris", Values: []string{"https://{{ .ServiceHost }}/pkmsoidc"}},
+		},
+	}
+
+	oidcReg, err := getOidcRegistration(ctx, &entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if oidcReg.PostData[0].Value != "default-iag-instance" {
+		t.Errorf("Unexpected client name : %s", oidcReg.PostData[0].Value)
+	}
+	if !reflect.DeepEqual(oidcReg.PostData[1].Values, []string{"https://iag-instance.default.svc/pkmsoidc"}) {
+		t.Errorf("Unexpected redirect URIs : %v", oidcReg.PostData[1].Values)
+	}
+}
+
+func TestTemplateValidationAndReferences(t *testing.T) {
+	entries := []ibmv1.IBMApplicationGatewayConfiguration{
+		{Type: "literal", Template: true, Value: "a: {{ configMapKey \"app-settings\" \"host\" }}\n" +
+			"{{ if true }}b: {{ secretKey \"app-credentials\" (printf \"%s\" \"password\") }}{{ end }}\n"},
+		{Type: "literal", Template: true, Value: "a: {{ .Name"},
+		{Type: "oidc_registration", Secret: "oidc-client", Template: true, PostData: []ibmv1.IBMApplicationGatewayPostData{
+			{Name: "client_name", Value: "{{ secretKey \"client-names\" \"name\" }}"},
+		}},
+	}
+
+	allErrs := validateConfiguration(entries, field.NewPath("spec", "configuration"))
+	if len(allErrs) != 1 || allErrs[0].Field != "spec.configuration[1].value" {
+		t.Errorf("Expected an error for the invalid template but got %v", allErrs)
+	}
+
+	// The data of a secret source is not rendered
+	entry := ibmv1.IBMApplicationGatewayConfiguration{Type: "secret", Template: true, Name: "remote",
+		DataKey: "config"}
+
+	allErrs = validateConfiguration([]ibmv1.IBMApplicationGatewayConfiguration{entry},
+		field.NewPath("spec", "configuration"))
+	if len(allErrs) != 1 || allErrs[0].Field != "spec.configuration[0].template" {
+		t.Errorf("Expected an error for the secret template but got %v", allErrs)
+	}
+
+	if _, err := renderConfigTemplate(&sourceContext{}, &entry, "a: {{ .Name }}"); err == nil {
+		t.Errorf("Expected the secret template not to be rendered")
+	}
+
+	if refs := getSourceReferences(entries, sourceReferenceConfigMap, "default"); !reflect.DeepEqual(refs, []string{"app-settings"}) {
+		t.Errorf("Unexpected config map references : %v", refs)
+	}
+	if refs := getSourceReferences(entries, sourceReferenceSecret, "default"); !reflect.DeepEqual(refs,
+		[]string{"app-credentials", "oidc-client", "client-names"}) {
+		t.Errorf("Unexpected secret references : %v", refs)
+	}
+}
+
+func TestUntrustedTemplateFuncs(t *testing.T) {
+	instance := &ibmv1.IBMApplicationGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "iag-instance", Namespace: "default"},
+	}
+
+	ctx := newTemplateTestContext(instance)
+
+	tests := []struct {
+		name      string
+		entry     ibmv1.IBMApplicationGatewayConfiguration
+		data      string
+		expected  string
+		expectErr bool
+	}{
+		{name: "web source metadata",
+			entry:    ibmv1.IBMApplicationGatewayConfiguration{Type: "web", Template: true},
+			data:     "a: {{ .Namespace }}-{{ .Name }}",
+			expected: "a: default-iag-instance"},
+		{name: "web source secret", expectErr: true,
+			entry: ibmv1.IBMApplicationGatewayConfiguration{Type: "web", Template: true},
+			data:  `a: {{ secretKey "app-credentials" "password" }}`},
+		{name: "web source config map", expectErr: true,
+			entry: ibmv1.IBMApplicationGatewayConfiguration{Type: "web", Template: true},
+			data:  `a: {{ configMapKey "app-settings" "host" }}`},
+		{name: "same namespace config map secret",
+			entry:    ibmv1.IBMApplicationGatewayConfiguration{Type: "configmap", Template: true, Namespace: "default"},
+			data:     `a: {{ secretKey "app-credentials" "password" }}`,
+			expected: "a: passw0rd"},
+		{name: "other namespace config map secret", expectErr: true,
+			entry: ibmv1.IBMApplicationGatewayConfiguration{Type: "configmap", Template: true, Namespace: "iag-platform"},
+			data:  `a: {{ secretKey "app-credentials" "password" }}`},
+		{name: "other namespace config map config map",
+			entry:    ibmv1.IBMApplicationGatewayConfiguration{Type: "configmap", Template: true, Namespace: "iag-platform"},
+			data:     `a: {{ configMapKey "app-settings" "host" }}`,
+			expected: "a: app.default.svc"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rendered, err := renderConfigTemplate(ctx, &test.entry, test.data)
+			if test.expectErr {
+				if err == nil {
+					t.Errorf("Expected an error but got %s", rendered)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rendered != test.expected {
+				t.Errorf("Expected %s but got %s", test.expected, rendered)
+			}
+		})
+	}
+}
@@ -0,0 +1,342 @@
+/*
+ * Copyright contributors to the IBM Application Gateway Operator project
+ */
+
+package controllers
+
+/*****************************************************************************/
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	ibmv1 "github.com/ibm-security/ibm-application-gateway-operator/api/v1"
+)
+
+/*****************************************************************************/
+
+// The valid web header types.
+var validHeaderTypes = []string{
+	"literal",
+	"secret",
+}
+
+/*****************************************************************************/
+
+// +kubebuilder:webhook:path=/validate-ibm-com-v1-ibmapplicationgateway,mutating=false,failurePolicy=fail,sideEffects=None,groups=ibm.com,resources=ibmapplicationgateways,verbs=create;update,versions=v1,name=vibmapplicationgateway.kb.io,admissionReviewVersions={v1}
+
+/*****************************************************************************/
+
+/*
+ * The validator for the IBMApplicationGateway custom resource.  The client is
+ * used to read the class of the custom resource, so that the configuration
+ * sources of the class can be validated along with the configuration sources
+ * of the custom resource.  The class is not used if there is no client.
+ */
+type IBMApplicationGatewayValidator struct {
+	Client client.Reader
+}
+
+var _ webhook.CustomValidator = &IBMApplicationGatewayValidator{}
+
+/*
+ * Function registers the validating webhook with the manager.
+ */
+func (v *IBMApplicationGatewayValidator) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&ibmv1.IBMApplicationGateway{}).
+		WithValidator(v).
+		Complete()
+}
+
+/*
+ * Function validates a new custom resource.
+ */
+func (v *IBMApplicationGatewayValidator) ValidateCreate(ctx context.Context,
+	obj runtime.Object) (admission.Warnings, error) {
+
+	instance, ok := obj.(*ibmv1.IBMApplicationGateway)
+	if !ok {
+		return nil, fmt.Errorf("Expected an IBMApplicationGateway but got a %T.", obj)
+	}
+
+	class, warnings := v.getClass(instance)
+
+	warnings = append(warnings, getConfigStorageWarnings(instance, class)...)
+
+	return warnings, toInvalidError(instance, validateGateway(instance, class))
+}
+
+/*
+ * Function validates an updated custom resource.  A warning is returned for
+ * each changed field which results in all of the pods being replaced.
+ */
+func (v *IBMApplicationGatewayValidator) ValidateUpdate(ctx context.Context,
+	oldObj runtime.Object, newObj runtime.Object) (admission.Warnings, error) {
+
+	oldInstance, ok := oldObj.(*ibmv1.IBMApplicationGateway)
+	if !ok {
+		return nil, fmt.Errorf("Expected an IBMApplicationGateway but got a %T.", oldObj)
+	}
+
+	instance, ok := newObj.(*ibmv1.IBMApplicationGateway)
+	if !ok {
+		return nil, fmt.Errorf("Expected an IBMApplicationGateway but got a %T.", newObj)
+	}
+
+	// Deletion only requires the finalizers to be removed, which must not be
+	// blocked by an invalid specification
+	if !instance.DeletionTimestamp.IsZero() {
+		return nil, nil
+	}
+
+	class, warnings := v.getClass(instance)
+
+	warnings = append(warnings, getConfigStorageWarnings(instance, class)...)
+	warnings = append(warnings, getUpdateWarnings(oldInstance, instance)...)
+
+	return warnings, toInvalidError(instance, validateGateway(instance, class))
+}
+
+/*
+ * Function validates a deleted custom resource.  Nothing is checked.
+ */
+func (v *IBMApplicationGatewayValidator) ValidateDelete(ctx context.Context,
+	obj runtime.Object) (admission.Warnings, error) {
+
+	return nil, nil
+}
+
+/*
+ * Function returns the class of the custom resource, or nil if it does not use
+ * a class.  A class which cannot be read does not prevent the custom resource
+ * from being saved, as it may be created or fixed later, so a warning is
+ * returned instead.
+ */
+func (v *IBMApplicationGatewayValidator) getClass(
+	instance *ibmv1.IBMApplicationGateway) (*ibmv1.IBMApplicationGatewayClass, admission.Warnings) {
+
+	if v.Client == nil {
+		return nil, nil
+	}
+
+	class, err := getGatewayClass(v.Client, instance.Spec.ClassName)
+	if err != nil {
+		return nil, admission.Warnings{
+			fmt.Sprintf("The configuration sources of the IBMApplicationGatewayClass have not been validated : %v", err),
+		}
+	}
+
+	return class, nil
+}
+
+/*
+ * Function converts a list of field errors into an invalid error.
+ */
+func toInvalidError(instance *ibmv1.IBMApplicationGateway, allErrs field.ErrorList) error {
+	if len(allErrs) == 0 {
+		return nil
+	}
+
+	return errors.NewInvalid(ibmv1.GroupVersion.WithKind("IBMApplicationGateway").GroupKind(),
+		instance.Name, allErrs)
+}
+
+/*
+ * Function validates the specification of the custom resource.  The
+ * configuration sources are validated along with the configuration sources of
+ * the passed in class, if any, which are merged before them.
+ */
+func validateGateway(instance *ibmv1.IBMApplicationGateway, class *ibmv1.IBMApplicationGatewayClass) field.ErrorList {
+	var allErrs field.ErrorList
+
+	specPath := field.NewPath("spec")
+
+	if class == nil || len(class.Spec.Configuration) == 0 {
+		allErrs = append(allErrs, validateConfiguration(instance.Spec.Configuration,
+			specPath.Child("configuration"))...)
+	} else {
+		// The configuration sources of the class are reported using the
+		// path of the class
+		classEntries := len(class.Spec.Configuration)
+		classPath := field.NewPath("IBMApplicationGatewayClass").Key(class.Name).Child("spec", "configuration")
+
+		allErrs = append(allErrs, validateConfigurationSources(getClassConfiguration(class, instance.Spec.Configuration),
+			func(idx int) *field.Path {
+				if idx < classEntries {
+					return classPath.Index(idx)
+				}
+				return specPath.Child("configuration").Index(idx - classEntries)
+			})...)
+	}
+
+	if instance.Spec.Service != nil {
+		allErrs = append(allErrs, validateService(instance.Spec.Service, specPath.Child("service"))...)
+	}
+
+	return allErrs
+}
+
+/*
+ * Function validates the configuration sources of the custom resource.  Each
+ * source is validated by the registered implementation of its type.
+ */
+func validateConfiguration(entries []ibmv1.IBMApplicationGatewayConfiguration, fldPath *field.Path) field.ErrorList {
+	return validateConfigurationSources(entries, fldPath.Index)
+}
+
+/*
+ * Function validates a web configuration source.
+ */
+func validateWebEntry(entry *ibmv1.IBMApplicationGatewayConfiguration, entryPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if entry.Url == "" {
+		allErrs = append(allErrs, field.Required(entryPath.Child("url"),
+			"The url is required for a web configuration source."))
+	} else if parsed, err := url.Parse(entry.Url); err != nil ||
+		(parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		allErrs = append(allErrs, field.Invalid(entryPath.Child("url"), entry.Url,
+			"The url must be an absolute http or https URL."))
+	}
+
+	if entry.RefreshInterval != nil && entry.RefreshInterval.Duration < 0 {
+		allErrs = append(allErrs, field.Invalid(entryPath.Child("refreshInterval"),
+			entry.RefreshInterval.Duration.String(), "The refresh interval must not be negative."))
+	}
+
+	if entry.TimeoutSeconds < 0 {
+		allErrs = append(allErrs, field.Invalid(entryPath.Child("timeoutSeconds"),
+			entry.TimeoutSeconds, "The timeout must not be negative."))
+	}
+
+	if entry.InsecureSkipVerify {
+		if entry.CASecret != "" {
+			allErrs = append(allErrs, field.Forbidden(entryPath.Child("caSecret"),
+				"A CA certificate must not be specified if insecureSkipVerify is true."))
+		}
+		if entry.CAConfigMap != "" {
+			allErrs = append(allErrs, field.Forbidden(entryPath.Child("caConfigMap"),
+				"A CA certificate must not be specified if insecureSkipVerify is true."))
+		}
+	}
+
+	for j, header := range entry.Headers {
+		headerPath := entryPath.Child("headers").Index(j)
+
+		if header.Name == "" {
+			allErrs = append(allErrs, field.Required(headerPath.Child("name"),
+				"The name of the header is required."))
+		}
+		if header.Value == "" {
+			allErrs = append(allErrs, field.Required(headerPath.Child("value"),
+				"The value of the header is required."))
+		}
+
+		switch header.Type {
+		case "literal":
+		case "secret":
+			if header.SecretKey == "" {
+				allErrs = append(allErrs, field.Required(headerPath.Child("secretKey"),
+					"The secretKey is required for a secret header."))
+			}
+		default:
+			allErrs = append(allErrs, field.NotSupported(headerPath.Child("type"), header.Type,
+				validHeaderTypes))
+		}
+	}
+
+	return allErrs
+}
+
+/*
+ * Function validates the service of the custom resource.
+ */
+func validateService(service *ibmv1.IBMApplicationGatewayService, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if len(service.Ports) < 2 {
+		return allErrs
+	}
+
+	names := make(map[string]bool)
+	for i, port := range service.Ports {
+		namePath := fldPath.Child("ports").Index(i).Child("name")
+
+		if port.Name == "" {
+			allErrs = append(allErrs, field.Required(namePath,
+				"The name of the port is required if more than one port is specified."))
+		} else if names[port.Name] {
+			allErrs = append(allErrs, field.Duplicate(namePath, port.Name))
+		}
+
+		names[port.Name] = true
+	}
+
+	return allErrs
+}
+
+/*
+ * Function returns a warning if the generated configuration is explicitly
+ * stored in a config map, but the configuration sources of the custom
+ * resource, or of its class, contain secret data which would be copied into
+ * the config map.
+ */
+func getConfigStorageWarnings(instance *ibmv1.IBMApplicationGateway,
+	class *ibmv1.IBMApplicationGatewayClass) admission.Warnings {
+
+	storage := instance.Spec.Deployment.ConfigStorage
+	if storage == "" && class != nil && class.Spec.Deployment != nil {
+		storage = class.Spec.Deployment.ConfigStorage
+	}
+
+	if storage != configStorageConfigMap ||
+		!hasSecretSource(getClassConfiguration(class, instance.Spec.Configuration)) {
+		return nil
+	}
+
+	return admission.Warnings{
+		fmt.Sprintf("%s is configmap, but a secret or oidc_registration configuration source is specified; "+
+			"the secret data will be stored in the generated config map.",
+			field.NewPath("spec", "deployment", "configStorage")),
+	}
+}
+
+/*
+ * Function returns a warning for each field which is documented as "Cannot be
+ * updated" and has been changed.  The deployment is updated with the new value,
+ * but this will result in all of the pods being replaced.
+ */
+func getUpdateWarnings(oldInstance *ibmv1.IBMApplicationGateway, instance *ibmv1.IBMApplicationGateway) admission.Warnings {
+	var warnings admission.Warnings
+
+	deplPath := field.NewPath("spec", "deployment")
+
+	oldDepl := &oldInstance.Spec.Deployment
+	newDepl := &instance.Spec.Deployment
+
+	addWarning := func(name string, changed bool) {
+		if changed {
+			warnings = append(warnings, fmt.Sprintf("%s is documented as \"Cannot be updated\"; "+
+				"the change will result in all of the gateway pods being replaced.", deplPath.Child(name)))
+		}
+	}
+
+	addWarning("imagePullPolicy", oldDepl.ImagePullPolicy != newDepl.ImagePullPolicy)
+	addWarning("readinessProbe", !equality.Semantic.DeepEqual(oldDepl.ReadinessProbe, newDepl.ReadinessProbe))
+	addWarning("livenessProbe", !equality.Semantic.DeepEqual(oldDepl.LivenessProbe, newDepl.LivenessProbe))
+	addWarning("customAnnotations", !equality.Semantic.DeepEqual(oldDepl.CustomAnnotations, newDepl.CustomAnnotations))
+
+	return warnings
+}
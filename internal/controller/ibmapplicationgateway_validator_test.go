@@ -0,0 +1,329 @@
+/*
+ * Copyright contributors to the IBM Application Gateway Operator project
+ */
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	corev1 "k8s.io/api/core/v1"
+
+	ibmv1 "github.com/ibm-security/ibm-application-gateway-operator/api/v1"
+)
+
+/*
+ * Function returns a valid custom resource with the passed in configuration
+ * sources.
+ */
+func newValidatorTestGateway(entries ...ibmv1.IBMApplicationGatewayConfiguration) *ibmv1.IBMApplicationGateway {
+	return &ibmv1.IBMApplicationGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "iag-instance", Namespace: "default"},
+		Spec: ibmv1.IBMApplicationGatewaySpec{
+			Deployment: ibmv1.IBMApplicationGatewayDeployment{
+				ImageLocation:   "icr.io/ibmappgateway/ibm-application-gateway:24.12",
+				ImagePullPolicy: "IfNotPresent",
+			},
+			Configuration: entries,
+		},
+	}
+}
+
+/*
+ * Function returns the fields of the invalid error which is returned by the
+ * validator, or nil if no error is returned.
+ */
+func getInvalidFields(t *testing.T, err error) []string {
+	t.Helper()
+
+	if err == nil {
+		return nil
+	}
+
+	statusErr, ok := err.(*errors.StatusError)
+	if !ok || !errors.IsInvalid(err) {
+		t.Fatalf("Expected an invalid error but got %v", err)
+	}
+
+	var fields []string
+	for _, cause := range statusErr.ErrStatus.Details.Causes {
+		fields = append(fields, cause.Field)
+	}
+
+	return fields
+}
+
+func TestValidateCreate(t *testing.T) {
+	tests := []struct {
+		name     string
+		instance *ibmv1.IBMApplicationGateway
+		expected []string
+	}{
+		{name: "a valid custom resource",
+			instance: newValidatorTestGateway(
+				ibmv1.IBMApplicationGatewayConfiguration{Type: "literal", Value: "version: \"24.12\"\n"},
+				ibmv1.IBMApplicationGatewayConfiguration{Type: "web", Url: "https://config.example.com/iag.yaml"},
+			)},
+		{name: "an invalid web source",
+			instance: newValidatorTestGateway(
+				ibmv1.IBMApplicationGatewayConfiguration{Type: "literal", Value: "version: \"24.12\"\n"},
+				ibmv1.IBMApplicationGatewayConfiguration{Type: "web", Url: "config.example.com/iag.yaml",
+					Headers: []ibmv1.IBMApplicationGatewayHeaders{{Name: "Authorization", Type: "env", Value: "x"}}},
+			),
+			expected: []string{"spec.configuration[1].url", "spec.configuration[1].headers[0].type"}},
+		{name: "an unknown source type",
+			instance: newValidatorTestGateway(ibmv1.IBMApplicationGatewayConfiguration{Type: "unknown"}),
+			expected: []string{"spec.configuration[0].type"}},
+		{name: "an invalid service",
+			instance: func() *ibmv1.IBMApplicationGateway {
+				instance := newValidatorTestGateway()
+				instance.Spec.Service = &ibmv1.IBMApplicationGatewayService{
+					Ports: []ibmv1.IBMApplicationGatewayServicePort{{Port: 443}, {Name: "https", Port: 8443}},
+				}
+				return instance
+			}(),
+			expected: []string{"spec.service.ports[0].name"}},
+	}
+
+	v := &IBMApplicationGatewayValidator{}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			warnings, err := v.ValidateCreate(context.TODO(), test.instance)
+			if len(warnings) != 0 {
+				t.Errorf("Unexpected warnings : %v", warnings)
+			}
+
+			if fields := getInvalidFields(t, err); !reflect.DeepEqual(fields, test.expected) {
+				t.Errorf("Expected errors for %v but got %v", test.expected, fields)
+			}
+		})
+	}
+
+	if _, err := v.ValidateCreate(context.TODO(), &corev1.ConfigMap{}); err == nil {
+		t.Errorf("Expected an error for an object which is not an IBMApplicationGateway")
+	}
+}
+
+func TestValidateUpdate(t *testing.T) {
+	oldInstance := newValidatorTestGateway(ibmv1.IBMApplicationGatewayConfiguration{Type: "literal",
+		Value: "version: \"24.12\"\n"})
+
+	v := &IBMApplicationGatewayValidator{}
+
+	// A change which replaces the pods results in a warning
+	instance := oldInstance.DeepCopy()
+	instance.Spec.Deployment.ImagePullPolicy = "Always"
+
+	warnings, err := v.ValidateUpdate(context.TODO(), oldInstance, instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "spec.deployment.imagePullPolicy") {
+		t.Errorf("Expected a warning for the image pull policy but got %v", warnings)
+	}
+
+	// An invalid specification is rejected
+	instance.Spec.Configuration = append(instance.Spec.Configuration,
+		ibmv1.IBMApplicationGatewayConfiguration{Type: "unknown"})
+
+	_, err = v.ValidateUpdate(context.TODO(), oldInstance, instance)
+	if fields := getInvalidFields(t, err); !reflect.DeepEqual(fields, []string{"spec.configuration[1].type"}) {
+		t.Errorf("Expected an error for the unknown source type but got %v", fields)
+	}
+
+	// The removal of the finalizers of a deleted custom resource is allowed
+	now := metav1.Now()
+	instance.DeletionTimestamp = &now
+
+	warnings, err = v.ValidateUpdate(context.TODO(), oldInstance, instance)
+	if err != nil || len(warnings) != 0 {
+		t.Errorf("Expected the deleted custom resource to be allowed but got %v : %v", warnings, err)
+	}
+}
+
+func TestGetUpdateWarnings(t *testing.T) {
+	tests := []struct {
+		name     string
+		update   func(depl *ibmv1.IBMApplicationGatewayDeployment)
+		expected []string
+	}{
+		{name: "no change",
+			update: func(depl *ibmv1.IBMApplicationGatewayDeployment) {}},
+		{name: "a change which does not replace the pods",
+			update: func(depl *ibmv1.IBMApplicationGatewayDeployment) { depl.ServiceAccountName = "iag" }},
+		{name: "the image pull policy",
+			update:   func(depl *ibmv1.IBMApplicationGatewayDeployment) { depl.ImagePullPolicy = "Always" },
+			expected: []string{"spec.deployment.imagePullPolicy"}},
+		{name: "the probes",
+			update: func(depl *ibmv1.IBMApplicationGatewayDeployment) {
+				depl.ReadinessProbe.Period = 10
+				depl.LivenessProbe.Period = 10
+			},
+			expected: []string{"spec.deployment.readinessProbe", "spec.deployment.livenessProbe"}},
+		{name: "the custom annotations",
+			update: func(depl *ibmv1.IBMApplicationGatewayDeployment) {
+				depl.CustomAnnotations = []ibmv1.CustomAnnotation{{Key: "team", Value: "edge"}}
+			},
+			expected: []string{"spec.deployment.customAnnotations"}},
+	}
+
+	oldInstance := newValidatorTestGateway()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			instance := oldInstance.DeepCopy()
+			test.update(&instance.Spec.Deployment)
+
+			warnings := getUpdateWarnings(oldInstance, instance)
+			if len(warnings) != len(test.expected) {
+				t.Fatalf("Expected warnings for %v but got %v", test.expected, warnings)
+			}
+			for i, warning := range warnings {
+				if !strings.HasPrefix(warning, test.expected[i]+" ") {
+					t.Errorf("Expected a warning for %s but got %s", test.expected[i], warning)
+				}
+			}
+		})
+	}
+}
+
+func TestGetConfigStorageWarnings(t *testing.T) {
+	secretSource := ibmv1.IBMApplicationGatewayConfiguration{Type: "secret", Name: "credentials", DataKey: "config"}
+	literalSource := ibmv1.IBMApplicationGatewayConfiguration{Type: "literal", Value: "version: \"24.12\"\n"}
+
+	tests := []struct {
+		name         string
+		storage      string
+		classStorage string
+		classSource  bool
+		entries      []ibmv1.IBMApplicationGatewayConfiguration
+		expected     bool
+	}{
+		{name: "a secret source with the default storage",
+			entries: []ibmv1.IBMApplicationGatewayConfiguration{secretSource}},
+		{name: "a secret source stored in a secret", storage: configStorageSecret,
+			entries: []ibmv1.IBMApplicationGatewayConfiguration{secretSource}},
+		{name: "a literal source stored in a config map", storage: configStorageConfigMap,
+			entries: []ibmv1.IBMApplicationGatewayConfiguration{literalSource}},
+		{name: "a secret source stored in a config map", storage: configStorageConfigMap,
+			entries: []ibmv1.IBMApplicationGatewayConfiguration{literalSource, secretSource}, expected: true},
+		{name: "a secret source of the class stored in a config map by the class",
+			classStorage: configStorageConfigMap, classSource: true,
+			entries: []ibmv1.IBMApplicationGatewayConfiguration{literalSource}, expected: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			instance := newValidatorTestGateway(test.entries...)
+			instance.Spec.Deployment.ConfigStorage = test.storage
+
+			class := &ibmv1.IBMApplicationGatewayClass{ObjectMeta: metav1.ObjectMeta{Name: "standard"}}
+			class.Spec.Deployment = &ibmv1.IBMApplicationGatewayDeployment{ConfigStorage: test.classStorage}
+			if test.classSource {
+				class.Spec.Configuration = []ibmv1.IBMApplicationGatewayConfiguration{secretSource}
+			}
+
+			warnings := getConfigStorageWarnings(instance, class)
+			if test.expected != (len(warnings) == 1) || len(warnings) > 1 {
+				t.Errorf("Expected a warning %v but got %v", test.expected, warnings)
+			}
+			if test.expected && !strings.HasPrefix(warnings[0], "spec.deployment.configStorage ") {
+				t.Errorf("Unexpected warning : %s", warnings[0])
+			}
+		})
+	}
+}
+
+func TestValidateService(t *testing.T) {
+	tests := []struct {
+		name     string
+		ports    []ibmv1.IBMApplicationGatewayServicePort
+		expected []string
+	}{
+		{name: "no ports"},
+		{name: "a single unnamed port",
+			ports: []ibmv1.IBMApplicationGatewayServicePort{{Port: 443}}},
+		{name: "named ports",
+			ports: []ibmv1.IBMApplicationGatewayServicePort{{Name: "https", Port: 443}, {Name: "alt", Port: 8443}}},
+		{name: "an unnamed port",
+			ports:    []ibmv1.IBMApplicationGatewayServicePort{{Name: "https", Port: 443}, {Port: 8443}},
+			expected: []string{"spec.service.ports[1].name"}},
+		{name: "a duplicate port name",
+			ports: []ibmv1.IBMApplicationGatewayServicePort{{Name: "https", Port: 443}, {Name: "https", Port: 8443},
+				{Name: "https", Port: 9443}},
+			expected: []string{"spec.service.ports[1].name", "spec.service.ports[2].name"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			allErrs := validateService(&ibmv1.IBMApplicationGatewayService{Ports: test.ports},
+				field.NewPath("spec", "service"))
+
+			var fields []string
+			for _, err := range allErrs {
+				fields = append(fields, err.Field)
+			}
+
+			if !reflect.DeepEqual(fields, test.expected) {
+				t.Errorf("Expected errors for %v but got %v", test.expected, fields)
+			}
+		})
+	}
+}
+
+func TestValidateGatewayClassPaths(t *testing.T) {
+	class := &ibmv1.IBMApplicationGatewayClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "standard"},
+		Spec: ibmv1.IBMApplicationGatewayClassSpec{
+			Configuration: []ibmv1.IBMApplicationGatewayConfiguration{
+				{Type: "literal", Value: "version: \"24.12\"\n"},
+				{Type: "unknown"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		class    *ibmv1.IBMApplicationGatewayClass
+		expected []string
+	}{
+		{name: "without a class",
+			expected: []string{"spec.configuration[1].type"}},
+		{name: "with a class",
+			class: class,
+			expected: []string{
+				"IBMApplicationGatewayClass[standard].spec.configuration[1].type",
+				"spec.configuration[1].type",
+			}},
+		{name: "with a class without configuration sources",
+			class:    &ibmv1.IBMApplicationGatewayClass{ObjectMeta: metav1.ObjectMeta{Name: "empty"}},
+			expected: []string{"spec.configuration[1].type"}},
+	}
+
+	instance := newValidatorTestGateway(
+		ibmv1.IBMApplicationGatewayConfiguration{Type: "literal", Value: "version: \"24.12\"\n"},
+		ibmv1.IBMApplicationGatewayConfiguration{Type: "invalid"},
+	)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var fields []string
+			for _, err := range validateGateway(instance, test.class) {
+				fields = append(fields, err.Field)
+			}
+
+			if !reflect.DeepEqual(fields, test.expected) {
+				t.Errorf("Expected errors for %v but got %v", test.expected, fields)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+/*
+ * Copyright contributors to the IBM Application Gateway Operator project
+ */
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"slices"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ibmv1 "github.com/ibm-security/ibm-application-gateway-operator/api/v1"
+)
+
+// The field index which contains the names of the config maps which are
+// referenced by the configuration sources of a custom resource.
+const configMapIndexField = "spec.configuration.name"
+
+/*
+ * Function is used to index the custom resources by the config maps which are
+ * referenced by their configuration sources, or which were read by their
+ * configuration templates.
+ */
+func indexReferencedConfigMaps(obj client.Object) []string {
+	instance, ok := obj.(*ibmv1.IBMApplicationGateway)
+	if !ok {
+		return nil
+	}
+
+	return appendMissing(getSourceReferences(instance.Spec.Configuration, sourceReferenceConfigMap,
+		instance.Namespace), instance.Status.TemplateConfigMaps)
+}
+
+/*
+ * Function appends each of the passed in names which is not already in the
+ * passed in list of names.
+ */
+func appendMissing(names []string, additions []string) []string {
+	for _, name := range additions {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+/*
+ * Function returns a map function which returns a reconcile request for each
+ * custom resource which references the changed object through the passed in
+ * field index.  The custom resources in the same namespace as the object
+ * reference it by name, and the custom resources in other namespaces
+ * reference it by namespace and name.  The custom resources whose class
+ * references the object, which are not indexed, are also returned.
+ */
+func (r *IBMApplicationGatewayReconciler) findGatewaysForIndex(indexField string, kind string) handler.MapFunc {
+	return func(ctx context.Context, obj client.Object) []reconcile.Request {
+		instanceList := &ibmv1.IBMApplicationGatewayList{}
+		err := r.Client.List(ctx, instanceList,
+			client.InNamespace(obj.GetNamespace()),
+			client.MatchingFields{indexField: obj.GetName()})
+		if err != nil {
+			log.Error(err, "Failed to find the custom objects which reference : "+obj.GetName())
+			return nil
+		}
+
+		crossNamespaceList := &ibmv1.IBMApplicationGatewayList{}
+		err = r.Client.List(ctx, crossNamespaceList,
+			client.MatchingFields{indexField: obj.GetNamespace() + "/" + obj.GetName()})
+		if err != nil {
+			log.Error(err, "Failed to find the custom objects which reference : "+
+				obj.GetNamespace()+"/"+obj.GetName())
+			return nil
+		}
+
+		items := append(instanceList.Items, crossNamespaceList.Items...)
+
+		requests := make([]reconcile.Request, len(items))
+		for i, inst := range items {
+			log.V(1).Info("Handle " + indexField + " change : " + obj.GetName() + " -> " + inst.Name)
+			requests[i] = reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: inst.Name, Namespace: inst.Namespace},
+			}
+		}
+
+		requests = append(requests, r.findGatewaysForClassSources(ctx,
+			func(entries []ibmv1.IBMApplicationGatewayConfiguration, inst *ibmv1.IBMApplicationGateway) bool {
+				name := obj.GetName()
+				if obj.GetNamespace() != inst.Namespace {
+					name = obj.GetNamespace() + "/" + name
+				}
+
+				return slices.Contains(getSourceReferences(entries, kind, inst.Namespace), name)
+			})...)
+
+		return requests
+	}
+}
+
+/*
+ * Function returns the predicate which is used to filter the config map and
+ * secret events.  The objects which are generated by the operator are ignored,
+ * as are updates which do not change the data of the object, such as the
+ * updates which are made to a leader election lock.
+ */
+func referencedDataPredicate() predicate.Predicate {
+	return predicate.And(
+		predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			owner := metav1.GetControllerOf(obj)
+			return owner == nil || owner.Kind != "IBMApplicationGateway" ||
+				owner.APIVersion != ibmv1.GroupVersion.String()
+		}),
+		predicate.Funcs{
+			UpdateFunc: func(e event.UpdateEvent) bool {
+				switch oldObj := e.ObjectOld.(type) {
+				case *corev1.ConfigMap:
+					newObj, ok := e.ObjectNew.(*corev1.ConfigMap)
+					return !ok || !reflect.DeepEqual(oldObj.Data, newObj.Data) ||
+						!reflect.DeepEqual(oldObj.BinaryData, newObj.BinaryData)
+				case *corev1.Secret:
+					newObj, ok := e.ObjectNew.(*corev1.Secret)
+					return !ok || !reflect.DeepEqual(oldObj.Data, newObj.Data)
+				}
+
+				return true
+			},
+		},
+	)
+}
@@ -0,0 +1,276 @@
+/*
+ * Copyright contributors to the IBM Application Gateway Operator project
+ */
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ibmv1 "github.com/ibm-security/ibm-application-gateway-operator/api/v1"
+)
+
+/*
+ * Function returns a custom resource with the passed in name and namespace
+ * which has the passed in configuration sources.
+ */
+func newWatchTestGateway(namespace string, name string,
+	entries ...ibmv1.IBMApplicationGatewayConfiguration) *ibmv1.IBMApplicationGateway {
+
+	return &ibmv1.IBMApplicationGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec:       ibmv1.IBMApplicationGatewaySpec{Configuration: entries},
+	}
+}
+
+/*
+ * Function returns a reconciler whose fake client contains the passed in
+ * objects, and which indexes the custom resources by the config maps and
+ * secrets which they reference, as the manager does.
+ */
+func newWatchTestReconciler(t *testing.T, objs ...client.Object) *IBMApplicationGatewayReconciler {
+	t.Helper()
+
+	rclient := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(objs...).
+		WithIndex(&ibmv1.IBMApplicationGateway{}, configMapIndexField, indexReferencedConfigMaps).
+		WithIndex(&ibmv1.IBMApplicationGateway{}, secretIndexField, indexReferencedSecrets).
+		Build()
+
+	return &IBMApplicationGatewayReconciler{
+		Client: rclient,
+		Scheme: rclient.Scheme(),
+	}
+}
+
+func TestIndexReferencedData(t *testing.T) {
+	instance := newWatchTestGateway("default", "iag-instance",
+		ibmv1.IBMApplicationGatewayConfiguration{Type: "configmap", Name: "local-config", DataKey: "config"},
+		ibmv1.IBMApplicationGatewayConfiguration{Type: "configmap", Namespace: "default", Name: "same-namespace",
+			DataKey: "config"},
+		ibmv1.IBMApplicationGatewayConfiguration{Type: "configmap", Namespace: "iag-platform", Name: "iag-baseline",
+			DataKey: "config"},
+		ibmv1.IBMApplicationGatewayConfiguration{Type: "secret", Name: "credentials", DataKey: "config"},
+		ibmv1.IBMApplicationGatewayConfiguration{Type: "configmap", Name: "local-config", DataKey: "other"},
+	)
+
+	configMaps := indexReferencedConfigMaps(instance)
+	expected := []string{"local-config", "same-namespace", "iag-platform/iag-baseline"}
+	if !reflect.DeepEqual(configMaps, expected) {
+		t.Errorf("Expected the config maps %v but got %v", expected, configMaps)
+	}
+
+	secrets := indexReferencedSecrets(instance)
+	if !reflect.DeepEqual(secrets, []string{"credentials"}) {
+		t.Errorf("Expected the secret credentials but got %v", secrets)
+	}
+
+	if refs := indexReferencedConfigMaps(&corev1.ConfigMap{}); refs != nil {
+		t.Errorf("Expected no references for an object which is not an IBMApplicationGateway but got %v", refs)
+	}
+}
+
+func TestFindGatewaysForIndex(t *testing.T) {
+	class := &ibmv1.IBMApplicationGatewayClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "standard"},
+		Spec: ibmv1.IBMApplicationGatewayClassSpec{
+			Configuration: []ibmv1.IBMApplicationGatewayConfiguration{
+				{Type: "configmap", Name: "shared", DataKey: "config"},
+			},
+		},
+	}
+
+	classInstance := newWatchTestGateway("default", "class-instance")
+	classInstance.Spec.ClassName = "standard"
+
+	r := newWatchTestReconciler(t,
+		class,
+		classInstance,
+		newWatchTestGateway("default", "local-instance",
+			ibmv1.IBMApplicationGatewayConfiguration{Type: "configmap", Name: "shared", DataKey: "config"}),
+		newWatchTestGateway("default", "other-instance",
+			ibmv1.IBMApplicationGatewayConfiguration{Type: "configmap", Name: "other", DataKey: "config"}),
+		newWatchTestGateway("team", "remote-instance",
+			ibmv1.IBMApplicationGatewayConfiguration{Type: "configmap", Namespace: "default", Name: "shared",
+				DataKey: "config"}),
+		newWatchTestGateway("team", "team-instance",
+			ibmv1.IBMApplicationGatewayConfiguration{Type: "configmap", Name: "shared", DataKey: "config"}),
+		newWatchTestGateway("default", "secret-instance",
+			ibmv1.IBMApplicationGatewayConfiguration{Type: "secret", Name: "shared", DataKey: "config"}),
+	)
+
+	tests := []struct {
+		name       string
+		indexField string
+		kind       string
+		obj        client.Object
+		expected   []string
+	}{
+		{name: "a config map which is referenced by name, namespace and name, and a class",
+			indexField: configMapIndexField, kind: sourceReferenceConfigMap,
+			obj:      &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "shared", Namespace: "default"}},
+			expected: []string{"default/class-instance", "default/local-instance", "team/remote-instance"}},
+		{name: "a config map which is only referenced from its own namespace",
+			indexField: configMapIndexField, kind: sourceReferenceConfigMap,
+			obj:      &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "shared", Namespace: "team"}},
+			expected: []string{"team/team-instance"}},
+		{name: "a config map which is not referenced",
+			indexField: configMapIndexField, kind: sourceReferenceConfigMap,
+			obj: &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "unused", Namespace: "default"}}},
+		{name: "a secret with the same name as a referenced config map",
+			indexField: secretIndexField, kind: sourceReferenceSecret,
+			obj:      &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "shared", Namespace: "default"}},
+			expected: []string{"default/secret-instance"}},
+		{name: "a secret in another namespace",
+			indexField: secretIndexField, kind: sourceReferenceSecret,
+			obj: &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "shared", Namespace: "team"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var names []string
+			for _, request := range r.findGatewaysForIndex(test.indexField, test.kind)(context.TODO(), test.obj) {
+				names = append(names, request.Namespace+"/"+request.Name)
+			}
+			sort.Strings(names)
+
+			if !reflect.DeepEqual(names, test.expected) {
+				t.Errorf("Expected the requests %v but got %v", test.expected, names)
+			}
+		})
+	}
+}
+
+func TestFindGatewaysForTemplateReferences(t *testing.T) {
+	instance := newWatchTestGateway("default", "iag-instance",
+		ibmv1.IBMApplicationGatewayConfiguration{Type: "configmap", Name: "templated", DataKey: "config",
+			Template: true})
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "templated", Namespace: "default"},
+		Data: map[string]string{"config": "version: \"24.12\"\nidentity:\n  oidc:\n    client_secret: " +
+			"{{ secretKey \"oidc-client\" \"secret\" | quote }}\n"},
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "oidc-client", Namespace: "default"},
+		Data:       map[string][]byte{"secret": []byte("passw0rd")},
+	}
+
+	r := newWatchTestReconciler(t, instance, configMap, secret)
+
+	// The secret is only known once the data of the config map has been read
+	if refs := indexReferencedSecrets(instance); len(refs) != 0 {
+		t.Fatalf("Expected no secret references before the merge but got %v", refs)
+	}
+
+	request := ctrl.Request{NamespacedName: types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}}
+	if _, _, err := getMergedConfig(r, instance, request, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(instance.Status.TemplateSecrets, []string{"oidc-client"}) {
+		t.Fatalf("Expected the secret read by the template to be recorded but got %v",
+			instance.Status.TemplateSecrets)
+	}
+
+	if err := r.Client.Update(context.TODO(), instance); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := r.findGatewaysForIndex(secretIndexField, sourceReferenceSecret)(context.TODO(), secret)
+	if len(requests) != 1 || requests[0].NamespacedName != request.NamespacedName {
+		t.Errorf("Expected a change to the secret to reconcile the custom resource but got %v", requests)
+	}
+
+	// The objects read by a preview are not recorded
+	instance.Status.TemplateSecrets = nil
+	if _, _, err := getMergedConfig(r, instance, request, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(instance.Status.TemplateSecrets) != 0 {
+		t.Errorf("Expected the preview not to record the template references but got %v",
+			instance.Status.TemplateSecrets)
+	}
+}
+
+func TestReferencedDataPredicate(t *testing.T) {
+	owned := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "iag-instance-config", Namespace: "default"}}
+	controller := true
+	owned.OwnerReferences = []metav1.OwnerReference{{APIVersion: ibmv1.GroupVersion.String(),
+		Kind: "IBMApplicationGateway", Name: "iag-instance", UID: "1234", Controller: &controller}}
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "shared", Namespace: "default", ResourceVersion: "1"},
+		Data:       map[string]string{"config": "version: \"24.12\"\n"},
+	}
+
+	relabelled := configMap.DeepCopy()
+	relabelled.ResourceVersion = "2"
+	relabelled.Labels = map[string]string{"team": "edge"}
+
+	changed := configMap.DeepCopy()
+	changed.ResourceVersion = "2"
+	changed.Data["config"] = "version: \"25.03\"\n"
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "credentials", Namespace: "default", ResourceVersion: "1"},
+		Data:       map[string][]byte{"password": []byte("passw0rd")},
+	}
+
+	relabelledSecret := secret.DeepCopy()
+	relabelledSecret.ResourceVersion = "2"
+	relabelledSecret.Annotations = map[string]string{"team": "edge"}
+
+	changedSecret := secret.DeepCopy()
+	changedSecret.Data["password"] = []byte("secret")
+
+	changedOwned := owned.DeepCopy()
+	changedOwned.Data = map[string]string{"config": "version: \"24.12\"\n"}
+
+	pred := referencedDataPredicate()
+
+	if pred.Create(event.CreateEvent{Object: owned}) {
+		t.Errorf("Expected a config map which is generated by the operator to be ignored")
+	}
+	if !pred.Create(event.CreateEvent{Object: configMap}) {
+		t.Errorf("Expected a created config map to be handled")
+	}
+	if !pred.Delete(event.DeleteEvent{Object: secret}) {
+		t.Errorf("Expected a deleted secret to be handled")
+	}
+
+	tests := []struct {
+		name     string
+		oldObj   client.Object
+		newObj   client.Object
+		expected bool
+	}{
+		{name: "the data of a config map is changed", oldObj: configMap, newObj: changed, expected: true},
+		{name: "the metadata of a config map is changed", oldObj: configMap, newObj: relabelled},
+		{name: "the data of a secret is changed", oldObj: secret, newObj: changedSecret, expected: true},
+		{name: "the metadata of a secret is changed", oldObj: secret, newObj: relabelledSecret},
+		{name: "a config map which is generated by the operator", oldObj: owned, newObj: changedOwned},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := pred.Update(event.UpdateEvent{ObjectOld: test.oldObj, ObjectNew: test.newObj})
+			if result != test.expected {
+				t.Errorf("Expected %v but got %v", test.expected, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,350 @@
+/*
+ * Copyright contributors to the IBM Application Gateway Operator project
+ */
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	corev1 "k8s.io/api/core/v1"
+
+	ibmv1 "github.com/ibm-security/ibm-application-gateway-operator/api/v1"
+)
+
+// The key of the secret, or config map, which contains the CA certificates
+// which are trusted by a web configuration source.
+const webSourceCAKey = "ca.crt"
+
+// The time after which the request to retrieve the data of a web
+// configuration source times out, if no timeout is specified.
+const defaultWebSourceTimeout = 20 * time.Second
+
+// The data which was last retrieved from a web configuration source.  This is
+// used to avoid downloading the configuration data again if it has not changed.
+type webSourceCacheEntry struct {
+	etag string
+	data string
+}
+
+// The key of the data of a web configuration source in the cache.  The data
+// is cached separately for each resource which references the source, and for
+// each set of request headers, as different headers, such as a different
+// bearer token, may result in different data.
+type webSourceCacheKey struct {
+	owner   types.NamespacedName
+	url     string
+	headers string
+}
+
+// The cache of web configuration source data.
+var webSourceCache = struct {
+	sync.Mutex
+	entries map[webSourceCacheKey]webSourceCacheEntry
+}{entries: make(map[webSourceCacheKey]webSourceCacheEntry)}
+
+/*
+ * Function returns the key which is used to cache the data of a web
+ * configuration source.  The request headers are hashed, so that the values
+ * of the headers are not kept in memory.
+ */
+func getWebSourceCacheKey(nsn types.NamespacedName, webUrl string, headers http.Header) webSourceCacheKey {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hash := sha256.New()
+	for _, name := range names {
+		for _, value := range headers[name] {
+			fmt.Fprintf(hash, "%s\x00%s\x00", name, value)
+		}
+	}
+
+	return webSourceCacheKey{owner: nsn, url: webUrl, headers: hex.EncodeToString(hash.Sum(nil))}
+}
+
+/*
+ * Function removes any cached web configuration source data for the
+ * resource with the passed in namespace and name.
+ */
+func forgetWebSources(nsn types.NamespacedName) {
+	webSourceCache.Lock()
+	defer webSourceCache.Unlock()
+
+	for key := range webSourceCache.entries {
+		if key.owner == nsn {
+			delete(webSourceCache.entries, key)
+		}
+	}
+}
+
+/*
+ * Function removes the cached web configuration source data for the resource
+ * with the passed in namespace and name which was retrieved from a URL that
+ * is no longer referenced by the passed in configuration sources.
+ */
+func pruneWebSources(nsn types.NamespacedName, entries []ibmv1.IBMApplicationGatewayConfiguration) {
+	referenced := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.Type == "web" {
+			referenced[entry.Url] = true
+		}
+	}
+
+	webSourceCache.Lock()
+	defer webSourceCache.Unlock()
+
+	for key := range webSourceCache.entries {
+		if key.owner == nsn && !referenced[key.url] {
+			delete(webSourceCache.entries, key)
+		}
+	}
+}
+
+/*
+ * Function returns the shortest refresh interval of the web configuration
+ * sources of the custom resource, or 0 if none of the web configuration
+ * sources are to be refreshed.
+ */
+func getRefreshInterval(instance *ibmv1.IBMApplicationGateway) time.Duration {
+	var interval time.Duration
+
+	for _, entry := range instance.Spec.Configuration {
+		if entry.Type != "web" || entry.RefreshInterval == nil || entry.RefreshInterval.Duration <= 0 {
+			continue
+		}
+
+		if interval == 0 || entry.RefreshInterval.Duration < interval {
+			interval = entry.RefreshInterval.Duration
+		}
+	}
+
+	return interval
+}
+
+/*
+ * Function returns the HTTP client which is used to retrieve the data of the
+ * passed in web config source.  The system CA certificates, the service
+ * account CA certificate and the CA certificates of the source are trusted,
+ * unless the certificate of the server is not to be verified.  The client
+ * certificate of the source, if any, is presented to the server.
+ */
+func getWebSourceClient(rclient client.Client, ns string,
+	entry *ibmv1.IBMApplicationGatewayConfiguration) (*http.Client, error) {
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: entry.InsecureSkipVerify,
+	}
+
+	if !entry.InsecureSkipVerify {
+		rootCAs, _ := x509.SystemCertPool()
+		if rootCAs == nil {
+			rootCAs = x509.NewCertPool()
+		}
+
+		// Add service account CA to rootCAs
+		cert, err := ioutil.ReadFile(serviceCAFile)
+		if err == nil {
+			rootCAs.AppendCertsFromPEM(cert)
+		}
+
+		if entry.CASecret != "" {
+			secret := &corev1.Secret{}
+			err = rclient.Get(context.TODO(), types.NamespacedName{Name: entry.CASecret, Namespace: ns}, secret)
+			if err != nil {
+				log.Error(err, "Failed to retrieve the CA certificate secret : "+entry.CASecret)
+				return nil, err
+			}
+
+			if !rootCAs.AppendCertsFromPEM(secret.Data[webSourceCAKey]) {
+				return nil, fmt.Errorf("The CA certificate secret : " + entry.CASecret +
+					" does not contain a PEM encoded certificate in the key : " + webSourceCAKey)
+			}
+		}
+
+		if entry.CAConfigMap != "" {
+			configMap := &corev1.ConfigMap{}
+			err = rclient.Get(context.TODO(), types.NamespacedName{Name: entry.CAConfigMap, Namespace: ns}, configMap)
+			if err != nil {
+				log.Error(err, "Failed to retrieve the CA certificate config map : "+entry.CAConfigMap)
+				return nil, err
+			}
+
+			if !rootCAs.AppendCertsFromPEM([]byte(configMap.Data[webSourceCAKey])) &&
+				!rootCAs.AppendCertsFromPEM(configMap.BinaryData[webSourceCAKey]) {
+				return nil, fmt.Errorf("The CA certificate config map : " + entry.CAConfigMap +
+					" does not contain a PEM encoded certificate in the key : " + webSourceCAKey)
+			}
+		}
+
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	if entry.ClientCertSecret != "" {
+		secret := &corev1.Secret{}
+		err := rclient.Get(context.TODO(), types.NamespacedName{Name: entry.ClientCertSecret, Namespace: ns}, secret)
+		if err != nil {
+			log.Error(err, "Failed to retrieve the client certificate secret : "+entry.ClientCertSecret)
+			return nil, err
+		}
+
+		cert, err := tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+		if err != nil {
+			return nil, fmt.Errorf("The client certificate secret : %s does not contain a valid certificate "+
+				"and key : %v", entry.ClientCertSecret, err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	timeout := defaultWebSourceTimeout
+	if entry.TimeoutSeconds > 0 {
+		timeout = time.Duration(entry.TimeoutSeconds) * time.Second
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}, nil
+}
+
+/*
+ * Retrieve the data of a web config source, using the passed in HTTP client.
+ * The data which is retrieved is cached along with the ETag which is returned
+ * by the server so that the data is only downloaded again if it has changed.
+ */
+func fetchWebSource(rclient client.Client, nsn types.NamespacedName,
+	webUrl string, headers []IAGHeader, client *http.Client) (string, error) {
+
+	if webUrl == "" {
+		return "", fmt.Errorf("Configuration web entry is missing the Url.")
+	}
+
+	log.V(1).Info("Retrieving config from " + webUrl)
+
+	req, err := http.NewRequest("GET", webUrl, nil)
+	if err != nil {
+		return "", err
+	}
+
+	// Add the headers if there are any
+	for _, header := range headers {
+
+		if header.Name == "" {
+			return "", fmt.Errorf("Configuration web header entry is missing the required name.")
+		}
+		if header.Value == "" {
+			return "", fmt.Errorf("Configuration web header entry is missing the required value.")
+		}
+
+		switch header.Type {
+		case "literal":
+			log.V(1).Info("Adding literal header : " + header.Name)
+			req.Header.Add(header.Name, header.Value)
+		case "secret":
+			// Retrieve the header value from the secret
+			secretNamespaceName := nsn
+			secretNamespaceName.Name = header.Value
+
+			secret := &corev1.Secret{}
+			err = rclient.Get(context.TODO(), secretNamespaceName, secret)
+			if err != nil {
+				log.Error(err, "Failed to retrieve the authorization secret : "+header.Value)
+				return "", err
+			} else {
+
+				// Extract the raw secret. k8s automatically decodes it from base64
+				hdrValue := string(secret.Data[header.SecretKey])
+
+				if hdrValue != "" {
+					log.V(1).Info("Adding secret header : " + header.Name)
+					req.Header.Add(header.Name, hdrValue)
+				} else {
+					return "", fmt.Errorf("The authorization secret : " + header.Value + " does not have the required key : " + header.SecretKey)
+				}
+			}
+		default:
+			// Invalid
+			return "", fmt.Errorf("Configuration web header entry has an invalid type : " + header.Type)
+		}
+	}
+
+	// Only download the data again if it has changed since it was cached
+	cacheKey := getWebSourceCacheKey(nsn, webUrl, req.Header)
+
+	webSourceCache.Lock()
+	cached, found := webSourceCache.entries[cacheKey]
+	webSourceCache.Unlock()
+
+	if found && cached.etag != "" {
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+
+	// Make the request
+	resp, err := client.Do(req)
+
+	// Handle the response
+	if err != nil {
+		log.Error(err, "Failed to get web config : "+webUrl)
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	var webData string
+
+	if resp.StatusCode == http.StatusNotModified && found {
+		log.V(1).Info("The web config has not been modified : " + webUrl)
+		webData = cached.data
+	} else if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			log.Error(err, "Failed to get web config data")
+			return "", err
+		}
+
+		webData = string(body)
+		log.V(1).Info("Found web config " + webData)
+
+		// A change to the data is detected by the hash of the merged
+		// configuration, so the data is only cached for the ETag
+		webSourceCache.Lock()
+		for key := range webSourceCache.entries {
+			// The data which was retrieved using the previous headers is
+			// no longer needed
+			if key.owner == nsn && key.url == webUrl {
+				delete(webSourceCache.entries, key)
+			}
+		}
+		webSourceCache.entries[cacheKey] = webSourceCacheEntry{
+			etag: resp.Header.Get("ETag"),
+			data: webData,
+		}
+		webSourceCache.Unlock()
+	} else {
+		// Error response code
+		err = fmt.Errorf("Error response from the remote config source.")
+		log.Error(err, "HTTP Response Status:", fmt.Sprintf("%v", resp.StatusCode), fmt.Sprintf("%v", http.StatusText(resp.StatusCode)))
+		return "", err
+	}
+
+	return webData, nil
+}
@@ -0,0 +1,290 @@
+/*
+ * Copyright contributors to the IBM Application Gateway Operator project
+ */
+
+package controllers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	corev1 "k8s.io/api/core/v1"
+
+	ibmv1 "github.com/ibm-security/ibm-application-gateway-operator/api/v1"
+)
+
+/*
+ * Function returns a self-signed client certificate and key, PEM encoded.
+ */
+func newTestClientCert(t *testing.T) ([]byte, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "iag-operator"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func TestWebSourceTLS(t *testing.T) {
+	clientCert, clientKey := newTestClientCert(t)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AppendCertsFromPEM(clientCert)
+
+	// The server requires a client certificate
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("version: \"24.12\"\n"))
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+
+	serverCA := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	rclient := fake.NewClientBuilder().WithObjects(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "config-ca", Namespace: "default"},
+			Data:       map[string][]byte{webSourceCAKey: serverCA},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "config-ca", Namespace: "default"},
+			Data:       map[string]string{webSourceCAKey: string(serverCA)},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "config-client", Namespace: "default"},
+			Type:       corev1.SecretTypeTLS,
+			Data:       map[string][]byte{corev1.TLSCertKey: clientCert, corev1.TLSPrivateKeyKey: clientKey},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "invalid", Namespace: "default"},
+			Data:       map[string][]byte{webSourceCAKey: []byte("invalid")},
+		},
+	).Build()
+
+	tests := []struct {
+		name      string
+		entry     ibmv1.IBMApplicationGatewayConfiguration
+		expectErr bool
+	}{
+		{name: "the server is not trusted", expectErr: true,
+			entry: ibmv1.IBMApplicationGatewayConfiguration{ClientCertSecret: "config-client"}},
+		{name: "no client certificate", expectErr: true,
+			entry: ibmv1.IBMApplicationGatewayConfiguration{CASecret: "config-ca"}},
+		{name: "a CA secret and client certificate",
+			entry: ibmv1.IBMApplicationGatewayConfiguration{CASecret: "config-ca", ClientCertSecret: "config-client"}},
+		{name: "a CA config map and client certificate",
+			entry: ibmv1.IBMApplicationGatewayConfiguration{CAConfigMap: "config-ca", ClientCertSecret: "config-client"}},
+		{name: "the server is not verified",
+			entry: ibmv1.IBMApplicationGatewayConfiguration{InsecureSkipVerify: true, ClientCertSecret: "config-client"}},
+		{name: "an invalid CA certificate", expectErr: true,
+			entry: ibmv1.IBMApplicationGatewayConfiguration{CASecret: "invalid", ClientCertSecret: "config-client"}},
+		{name: "an invalid client certificate", expectErr: true,
+			entry: ibmv1.IBMApplicationGatewayConfiguration{CASecret: "config-ca", ClientCertSecret: "invalid"}},
+		{name: "a missing CA secret", expectErr: true,
+			entry: ibmv1.IBMApplicationGatewayConfiguration{CASecret: "missing", ClientCertSecret: "config-client"}},
+	}
+
+	for _, test := range tests {
+		ctx := &sourceContext{
+			client: rclient,
+			owner:  types.NamespacedName{Name: "iag-instance", Namespace: "default"},
+		}
+
+		entry := test.entry
+		entry.Type = "web"
+		entry.Url = server.URL
+
+		config, err := webSource{}.Fetch(ctx, &entry)
+		if test.expectErr != (err != nil) {
+			t.Errorf("%s: unexpected result %v", test.name, err)
+		} else if err == nil && config["version"] != "24.12" {
+			t.Errorf("%s: unexpected configuration %v", test.name, config)
+		}
+
+		forgetWebSources(ctx.owner)
+	}
+}
+
+func TestWebSourceClientSettings(t *testing.T) {
+	rclient := fake.NewClientBuilder().Build()
+
+	httpClient, err := getWebSourceClient(rclient, "default", &ibmv1.IBMApplicationGatewayConfiguration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if httpClient.Timeout != defaultWebSourceTimeout {
+		t.Errorf("Expected the default timeout but got %v", httpClient.Timeout)
+	}
+
+	httpClient, err = getWebSourceClient(rclient, "default", &ibmv1.IBMApplicationGatewayConfiguration{TimeoutSeconds: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if httpClient.Timeout != 5*time.Second {
+		t.Errorf("Expected a 5 second timeout but got %v", httpClient.Timeout)
+	}
+
+	annots := map[string]string{
+		confPrefix + "remote.type":               "web",
+		confPrefix + "remote.order":              "1",
+		confPrefix + "remote.url":                "https://config.example.com/iag.yaml",
+		confPrefix + "remote.caConfigMap":        "config-ca",
+		confPrefix + "remote.clientCertSecret":   "config-client",
+		confPrefix + "remote.timeoutSeconds":     "5",
+		confPrefix + "remote.insecureSkipVerify": "false",
+	}
+
+	elements, err := getConfigElements(annots)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry := getConfigurationEntries(elements)[0]
+	if entry.CAConfigMap != "config-ca" || entry.ClientCertSecret != "config-client" || entry.TimeoutSeconds != 5 {
+		t.Errorf("Unexpected TLS settings : %+v", entry)
+	}
+
+	if refs := getSourceReferences([]ibmv1.IBMApplicationGatewayConfiguration{entry}, sourceReferenceSecret,
+		"default"); len(refs) != 1 || refs[0] != "config-client" {
+		t.Errorf("Unexpected secret references : %v", refs)
+	}
+
+	// The CA certificate is not used if the server is not verified
+	annots[confPrefix+"remote.insecureSkipVerify"] = "true"
+	if _, err := getConfigElements(annots); err == nil {
+		t.Errorf("Expected an error for a CA certificate which is not used")
+	}
+
+	annots[confPrefix+"remote.insecureSkipVerify"] = "false"
+	annots[confPrefix+"remote.timeoutSeconds"] = "-1"
+	if _, err := getConfigElements(annots); err == nil {
+		t.Errorf("Expected an error for a negative timeout")
+	}
+}
+
+func TestPruneWebSources(t *testing.T) {
+	owner := types.NamespacedName{Name: "iag-instance", Namespace: "default"}
+	other := types.NamespacedName{Name: "other", Namespace: "default"}
+
+	webSourceCache.Lock()
+	for _, key := range []webSourceCacheKey{
+		getWebSourceCacheKey(owner, "https://config.example.com/kept.yaml", nil),
+		getWebSourceCacheKey(owner, "https://config.example.com/removed.yaml", nil),
+		getWebSourceCacheKey(other, "https://config.example.com/removed.yaml", nil),
+	} {
+		webSourceCache.entries[key] = webSourceCacheEntry{data: "version: \"24.12\"\n"}
+	}
+	webSourceCache.Unlock()
+
+	defer forgetWebSources(owner)
+	defer forgetWebSources(other)
+
+	pruneWebSources(owner, []ibmv1.IBMApplicationGatewayConfiguration{
+		{Type: "web", Url: "https://config.example.com/kept.yaml"},
+		{Type: "literal", Value: "version: \"24.12\"\n"},
+	})
+
+	webSourceCache.Lock()
+	defer webSourceCache.Unlock()
+
+	if _, ok := webSourceCache.entries[getWebSourceCacheKey(owner, "https://config.example.com/kept.yaml", nil)]; !ok {
+		t.Errorf("The referenced web source was removed from the cache")
+	}
+	if _, ok := webSourceCache.entries[getWebSourceCacheKey(owner, "https://config.example.com/removed.yaml", nil)]; ok {
+		t.Errorf("The unreferenced web source was not removed from the cache")
+	}
+	if _, ok := webSourceCache.entries[getWebSourceCacheKey(other, "https://config.example.com/removed.yaml", nil)]; !ok {
+		t.Errorf("The web source of another resource was removed from the cache")
+	}
+}
+
+func TestWebSourceCacheHeaders(t *testing.T) {
+	// The server returns different data for each bearer token, and the same
+	// ETag for all of the data
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("If-None-Match") == "\"1\"" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", "\"1\"")
+		w.Write([]byte("token: " + req.Header.Get("Authorization") + "\n"))
+	}))
+	defer server.Close()
+
+	owner := types.NamespacedName{Name: "app-ibm-application-gateway-sidecar-pod", Namespace: "default"}
+	defer forgetWebSources(owner)
+
+	rclient := fake.NewClientBuilder().Build()
+
+	for _, token := range []string{"first", "second", "first"} {
+		data, err := fetchWebSource(rclient, owner, server.URL,
+			[]IAGHeader{{Name: "Authorization", Type: "literal", Value: token}}, server.Client())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data != "token: "+token+"\n" {
+			t.Errorf("Expected the data for the %s token but got %s", token, data)
+		}
+	}
+
+	webSourceCache.Lock()
+	cached := 0
+	for key := range webSourceCache.entries {
+		if key.owner == owner {
+			cached++
+		}
+	}
+	webSourceCache.Unlock()
+
+	if cached != 1 {
+		t.Errorf("Expected a single cached entry but got %d", cached)
+	}
+
+	forgetWebSources(owner)
+
+	webSourceCache.Lock()
+	defer webSourceCache.Unlock()
+
+	for key := range webSourceCache.entries {
+		if key.owner == owner {
+			t.Errorf("The cached entry was not removed : %v", key)
+		}
+	}
+}
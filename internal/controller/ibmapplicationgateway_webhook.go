@@ -8,6 +8,7 @@ package controllers
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"sort"
@@ -18,6 +19,7 @@ import (
 
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
@@ -26,6 +28,8 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ibmv1 "github.com/ibm-security/ibm-application-gateway-operator/api/v1"
 )
 
 /*****************************************************************************/
@@ -38,11 +42,15 @@ var ignoredNamespaces = []string{
 const (
 	admissionWebhookAnnotationInjectKey = "ibm-application-gateway.security.ibm.com/"
 	imageAnnot                          = "ibm-application-gateway.security.ibm.com/deployment.image"
+	imagePullPolicyAnnot                = "ibm-application-gateway.security.ibm.com/deployment.imagePullPolicy"
 	servPort                            = "ibm-application-gateway.security.ibm.com/service.port"
 	confPrefix                          = "ibm-application-gateway.security.ibm.com/configuration."
 	envPrefix                           = "ibm-application-gateway.security.ibm.com/env."
 	servAnnot                           = "ibm-application-gateway.security.ibm.com/serviceName"
 	cmAnnot                             = "ibm-application-gateway.security.ibm.com/configMapName"
+	provenanceAnnot                     = "ibm-application-gateway.security.ibm.com/configurationProvenance"
+	configStorageAnnot                  = "ibm-application-gateway.security.ibm.com/configStorage"
+	classAnnot                          = "ibm-application-gateway.security.ibm.com/class"
 	volumeName                          = "ibm-application-gateway-config"
 )
 
@@ -51,19 +59,30 @@ var updateRequiredAnnotations = []string{
 	confPrefix,
 	servPort,
 	imageAnnot,
+	provenanceAnnot,
+	configStorageAnnot,
+	classAnnot,
 }
 
 type IAGConfigElement struct {
-	Name              string
-	Type              string
-	DataKey           string
-	Value             string
-	Url               string
-	Headers           []IAGHeader
-	Order             int
-	DiscoveryEndpoint string
-	Secret            string
-	PostData          []IAGPostData
+	Id                 string
+	Name               string
+	Namespace          string
+	Type               string
+	DataKey            string
+	Value              string
+	Url                string
+	Headers            []IAGHeader
+	Order              int
+	DiscoveryEndpoint  string
+	Secret             string
+	PostData           []IAGPostData
+	Template           bool
+	CASecret           string
+	CAConfigMap        string
+	ClientCertSecret   string
+	InsecureSkipVerify bool
+	TimeoutSeconds     int32
 }
 
 type patchOperation struct {
@@ -194,8 +213,10 @@ func mutationRequired(whsvr *IBMApplicationGatewayWebhook, ignoredList []string,
 
 /*
  * Do some validation of the annotation entries to try and catch errors before changes are made.
+ * The configuration sources of the class, if any, are merged before the configuration sources
+ * of the annotations, so at least one source is only required once they have been combined.
  */
-func validateAnnotations(annots map[string]string) (error, []IAGConfigElement) {
+func validateAnnotations(annots map[string]string, class *ibmv1.IBMApplicationGatewayClass) (error, []IAGConfigElement) {
 
 	log.V(2).Info("IBMApplicationGatewayWebhook: validateAnnotations")
 
@@ -204,14 +225,46 @@ func validateAnnotations(annots map[string]string) (error, []IAGConfigElement) {
 		return fmt.Errorf("No IBM Application Gateway image has been specified."), nil
 	}
 
+	switch annots[configStorageAnnot] {
+	case "", configStorageConfigMap, configStorageSecret:
+	default:
+		return fmt.Errorf("The configuration storage must be either %s or %s.",
+			configStorageConfigMap, configStorageSecret), nil
+	}
+
 	configElements, err := getConfigElements(annots)
 	if err != nil {
 		return err, nil
 	}
 
+	// Make sure there is at least one
+	if len(getClassConfiguration(class, getConfigurationEntries(configElements))) < 1 {
+		return fmt.Errorf("No configuration entries specified in the annotations or the class."), nil
+	}
+
 	return nil, configElements
 }
 
+/*
+ * Function returns true if the annotations request a provenance report for the merged configuration.
+ */
+func isProvenanceRequested(annots map[string]string) bool {
+	requested, _ := strconv.ParseBool(annots[provenanceAnnot])
+
+	return requested
+}
+
+/*
+ * Function returns the type of object which the merged configuration is stored in.
+ */
+func getAnnotConfigStorage(annots map[string]string) string {
+	if annots[configStorageAnnot] == configStorageSecret {
+		return configStorageSecret
+	}
+
+	return configStorageConfigMap
+}
+
 /*
  * Parse the annotations list and return a list of the config source entries only.
  */
@@ -284,118 +337,260 @@ func getConfigElements(annots map[string]string) ([]IAGConfigElement, error) {
 		}
 	}
 
-	oidcExists := false
-
 	// Now for each unique name get the required config
 	for name := range configNames {
 		var currElem IAGConfigElement
 
+		currElem.Id = name
 		currElem.Type = cfgAnnotations[name+".type"]
 		currElem.Order, err = strconv.Atoi(cfgAnnotations[name+".order"])
 		if err != nil {
 			return nil, fmt.Errorf("Configuration entry has an invalid order value : " + cfgAnnotations[name+".order"])
 		}
 
-		switch currElem.Type {
-		case "configmap":
-			// Config map requires name, datakey and order
-			currElem.Name = cfgAnnotations[name+".name"]
-			currElem.DataKey = cfgAnnotations[name+".dataKey"]
+		// The fields of the entry are validated by the configuration source
+		// of the type, so every field is read regardless of the type
+		currElem.Name = cfgAnnotations[name+".name"]
+		currElem.Namespace = cfgAnnotations[name+".namespace"]
+		currElem.DataKey = cfgAnnotations[name+".dataKey"]
+		currElem.Value = cfgAnnotations[name+".value"]
+
+		// Multi-line literal configuration can be base64 encoded
+		if encoded, ok := cfgAnnotations[name+".valueBase64"]; ok {
+			if currElem.Value != "" {
+				return nil, fmt.Errorf("Configuration entry " + name + " must not specify both a value and a valueBase64.")
+			}
+
+			decoded, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				return nil, fmt.Errorf("Configuration entry " + name + " has an invalid valueBase64 : " + err.Error())
+			}
+
+			currElem.Value = string(decoded)
+		}
+		currElem.Url = cfgAnnotations[name+".url"]
+		currElem.DiscoveryEndpoint = cfgAnnotations[name+".discoveryEndpoint"]
+		currElem.Secret = cfgAnnotations[name+".secret"]
+
+		if template, ok := cfgAnnotations[name+".template"]; ok {
+			currElem.Template, err = strconv.ParseBool(template)
+			if err != nil {
+				return nil, fmt.Errorf("Configuration entry has an invalid template value : " + template)
+			}
+		}
 
-		case "oidc_registration":
+		// The TLS settings of a web entry
+		currElem.CASecret = cfgAnnotations[name+".caSecret"]
+		currElem.CAConfigMap = cfgAnnotations[name+".caConfigMap"]
+		currElem.ClientCertSecret = cfgAnnotations[name+".clientCertSecret"]
 
-			// Error if more than one oidc_registration exists
-			if oidcExists {
-				return nil, fmt.Errorf("Configuration must not contain multiple oidc_registration entries")
+		if insecure, ok := cfgAnnotations[name+".insecureSkipVerify"]; ok {
+			currElem.InsecureSkipVerify, err = strconv.ParseBool(insecure)
+			if err != nil {
+				return nil, fmt.Errorf("Configuration entry has an invalid insecureSkipVerify value : " + insecure)
 			}
+		}
 
-			// Oidc registration has a discoveryEndpoint, secret and postData
-			currElem.DiscoveryEndpoint = cfgAnnotations[name+".discoveryEndpoint"]
-			currElem.Secret = cfgAnnotations[name+".secret"]
+		if timeout, ok := cfgAnnotations[name+".timeoutSeconds"]; ok {
+			seconds, err := strconv.ParseInt(timeout, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("Configuration entry has an invalid timeoutSeconds value : " + timeout)
+			}
+			currElem.TimeoutSeconds = int32(seconds)
+		}
 
-			// Get the post data
-			var postData []IAGPostData
+		// There can be multiple headers defined in the form
+		// configuration.sample.header.<name>.<vals>
+		for hdrName := range hdrNames {
 
-			// There can be multiple postData entries defined in the form
-			// configuration.sample.postData.<name>: <vals>
-			for pdName := range pdNames {
+			var hdrPrefix = name + ".header." + hdrName
 
-				// Create the postData prefix
-				var pdPrefix = name + ".postData." + pdName
+			var currHdr IAGHeader
 
-				var currPd IAGPostData
+			currHdr.Type = cfgAnnotations[hdrPrefix+".type"]
 
-				// Set the postdata name and value(s)
-				currPd.Name = cfgAnnotations[pdPrefix+".name"]
+			if currHdr.Type != "" {
+				// Valid for this entry
+				currHdr.Name = cfgAnnotations[hdrPrefix+".name"]
+				currHdr.Value = cfgAnnotations[hdrPrefix+".value"]
+				currHdr.SecretKey = cfgAnnotations[hdrPrefix+".secretKey"]
 
-				// Name is required
-				if currPd.Name != "" {
+				currElem.Headers = append(currElem.Headers, currHdr)
+			}
+		}
 
-					// Get the value if it exists
-					currPd.Value = cfgAnnotations[pdPrefix+".value"]
+		// There can be multiple postData entries defined in the form
+		// configuration.sample.postData.<name>: <vals>
+		for pdName := range pdNames {
 
-					// Check for values if value has not been specified
-					if currPd.Value == "" {
-						currPd.Values = pdValues[pdName]
-					}
-					postData = append(postData, currPd)
+			// Create the postData prefix
+			var pdPrefix = name + ".postData." + pdName
+
+			var currPd IAGPostData
+
+			// Set the postdata name and value(s)
+			currPd.Name = cfgAnnotations[pdPrefix+".name"]
+
+			// Name is required
+			if currPd.Name != "" {
+
+				// Get the value if it exists
+				currPd.Value = cfgAnnotations[pdPrefix+".value"]
+
+				// Check for values if value has not been specified
+				if currPd.Value == "" {
+					currPd.Values = pdValues[pdName]
 				}
+				currElem.PostData = append(currElem.PostData, currPd)
 			}
+		}
 
-			currElem.PostData = postData
-			oidcExists = true
+		configElements = append(configElements, currElem)
+	}
 
-		case "web":
-			// Web has a url , order and headers
-			currElem.Url = cfgAnnotations[name+".url"]
+	// Sort via the order fields, so that any errors are reported in order
+	sort.SliceStable(configElements, func(first, second int) bool {
+		if configElements[first].Order != configElements[second].Order {
+			return configElements[first].Order < configElements[second].Order
+		}
+		return configElements[first].Id < configElements[second].Id
+	})
 
-			var headers []IAGHeader
+	// Validate the entries using the registered configuration sources
+	entries := getConfigurationEntries(configElements)
 
-			// There can be multiple headers defined in the form
-			// configuration.sample.header.<name>.<vals>
-			for hdrName := range hdrNames {
+	allErrs := validateConfigurationSources(entries, func(idx int) *field.Path {
+		return field.NewPath("metadata", "annotations").Key(confPrefix + configElements[idx].Id)
+	})
+	if len(allErrs) > 0 {
+		return nil, allErrs.ToAggregate()
+	}
 
-				var hdrPrefix = name + ".header." + hdrName
+	return configElements, nil
+}
 
-				var currHdr IAGHeader
+/*
+ * Function converts the config source elements which were defined by annotations into
+ * configuration sources, so that they can be handled by the registered configuration sources.
+ */
+func getConfigurationEntries(configElements []IAGConfigElement) []ibmv1.IBMApplicationGatewayConfiguration {
+	entries := make([]ibmv1.IBMApplicationGatewayConfiguration, len(configElements))
+
+	for idx, element := range configElements {
+		entries[idx] = ibmv1.IBMApplicationGatewayConfiguration{
+			Type:               element.Type,
+			Name:               element.Name,
+			Namespace:          element.Namespace,
+			DataKey:            element.DataKey,
+			Value:              element.Value,
+			Url:                element.Url,
+			DiscoveryEndpoint:  element.DiscoveryEndpoint,
+			Secret:             element.Secret,
+			Template:           element.Template,
+			CASecret:           element.CASecret,
+			CAConfigMap:        element.CAConfigMap,
+			ClientCertSecret:   element.ClientCertSecret,
+			InsecureSkipVerify: element.InsecureSkipVerify,
+			TimeoutSeconds:     element.TimeoutSeconds,
+		}
 
-				currHdr.Type = cfgAnnotations[hdrPrefix+".type"]
+		for _, header := range element.Headers {
+			entries[idx].Headers = append(entries[idx].Headers, ibmv1.IBMApplicationGatewayHeaders{
+				Name:      header.Name,
+				Type:      header.Type,
+				Value:     header.Value,
+				SecretKey: header.SecretKey,
+			})
+		}
 
-				if currHdr.Type != "" && currHdr.Type != "secret" && currHdr.Type != "literal" {
-					return nil, fmt.Errorf("Configuration entry has an invalid header type : " + currHdr.Type)
-				}
+		for _, postData := range element.PostData {
+			entries[idx].PostData = append(entries[idx].PostData, ibmv1.IBMApplicationGatewayPostData{
+				Name:   postData.Name,
+				Value:  postData.Value,
+				Values: postData.Values,
+			})
+		}
+	}
 
-				if currHdr.Type != "" {
-					// Valid for this entry
-					currHdr.Name = cfgAnnotations[hdrPrefix+".name"]
-					currHdr.Value = cfgAnnotations[hdrPrefix+".value"]
-					currHdr.SecretKey = cfgAnnotations[hdrPrefix+".secretKey"]
+	return entries
+}
 
-					headers = append(headers, currHdr)
-				}
-			}
+/*
+ * Function returns true if any of the config source elements is a template.
+ */
+func hasTemplateElement(configElements []IAGConfigElement) bool {
+	for _, element := range configElements {
+		if element.Template {
+			return true
+		}
+	}
 
-			currElem.Headers = headers
+	return false
+}
 
-		default:
-			return nil, fmt.Errorf("Configuration entry has an invalid type : " + currElem.Type)
+/*
+ * Function returns the environment variables which are set by the env annotations.
+ */
+func getAnnotEnv(annots map[string]string) map[string]string {
+	env := make(map[string]string)
+
+	for key, value := range annots {
+		if strings.HasPrefix(key, envPrefix) {
+			env[strings.TrimPrefix(key, envPrefix)] = value
 		}
+	}
 
-		configElements = append(configElements, currElem)
+	return env
+}
+
+/*
+ * Function returns a copy of the annotations with the defaults of the class
+ * added.  The image, image pull policy, configuration storage and the literal
+ * environment variables of the class deployment are used for any annotation
+ * which has not been set.  The class is named by the class annotation, and
+ * the default class is used if there is no class annotation.  The returned
+ * class is nil if there is no class.
+ */
+func getClassAnnotations(rclient client.Reader, annots map[string]string) (map[string]string,
+	*ibmv1.IBMApplicationGatewayClass, error) {
+
+	class, err := getGatewayClass(rclient, annots[classAnnot])
+	if err != nil {
+		return nil, nil, err
 	}
 
-	// Make sure there is at least one
-	if len(configElements) < 1 {
-		return nil, fmt.Errorf("No configuration entries specified in the annotations.")
+	classAnnots := make(map[string]string, len(annots))
+	for key, value := range annots {
+		classAnnots[key] = value
 	}
 
-	return configElements, nil
+	if class == nil || class.Spec.Deployment == nil {
+		return classAnnots, class, nil
+	}
+
+	addDefault := func(key string, value string) {
+		if classAnnots[key] == "" && value != "" {
+			classAnnots[key] = value
+		}
+	}
+
+	addDefault(imageAnnot, class.Spec.Deployment.ImageLocation)
+	addDefault(imagePullPolicyAnnot, class.Spec.Deployment.ImagePullPolicy)
+	addDefault(configStorageAnnot, class.Spec.Deployment.ConfigStorage)
+
+	for _, env := range class.Spec.Deployment.Env {
+		if env.ValueFrom == nil {
+			addDefault(envPrefix+env.Name, env.Value)
+		}
+	}
+
+	return classAnnots, class, nil
 }
 
 /*
- * Function sorts the config source array and creates the merged master IAG configmap.
+ * Function sorts the config source array and creates the merged master IAG configmap, or secret.
  */
-func createIAGConfig(whsvr *IBMApplicationGatewayWebhook, req *admissionv1.AdmissionRequest, configElements []IAGConfigElement, update bool, cmName string) (string, error) {
+func createIAGConfig(whsvr *IBMApplicationGatewayWebhook, req *admissionv1.AdmissionRequest, configElements []IAGConfigElement, update bool, cmName string, provenance bool, storage string, values *templateValues, class *ibmv1.IBMApplicationGatewayClass) (string, error) {
 
 	log.V(2).Info("IBMApplicationGatewayWebhook: createIAGConfig")
 
@@ -405,82 +600,77 @@ func createIAGConfig(whsvr *IBMApplicationGatewayWebhook, req *admissionv1.Admis
 	})
 
 	// Merge all of the entries
-	return mergeIAGConfig(whsvr, configElements, req.Namespace, req, update, cmName)
+	return mergeIAGConfig(whsvr, configElements, req.Namespace, req, update, cmName, provenance, storage, values, class)
 }
 
 /*
- * Function creates the merged master IAG configmap.
+ * Function creates the merged master IAG configmap.  A provenance report is
+ * added to the configmap if it has been requested.  The merged configuration
+ * is stored in a secret, rather than a configmap, if the storage is secret.
+ * The configuration sources of the class, if any, are merged first.
  */
-func mergeIAGConfig(whsvr *IBMApplicationGatewayWebhook, configElements []IAGConfigElement, ns string, req *admissionv1.AdmissionRequest, update bool, cmName string) (string, error) {
+func mergeIAGConfig(whsvr *IBMApplicationGatewayWebhook, configElements []IAGConfigElement, ns string, req *admissionv1.AdmissionRequest, update bool, cmName string, provenance bool, storage string, values *templateValues, class *ibmv1.IBMApplicationGatewayClass) (string, error) {
 
 	log.V(2).Info("IBMApplicationGatewayWebhook : mergeIAGConfig")
 
-	master := make(map[string]interface{})
-	var err error
+	var report configProvenance
+	if provenance {
+		report = make(configProvenance)
+	}
 
-	var oidcReg IAGConfigElement
-	foundOidcElem := false
+	entries := getClassConfiguration(class, getConfigurationEntries(configElements))
 
+	// The class sources do not have an ID
+	ids := make([]string, len(entries)-len(configElements))
 	for _, element := range configElements {
-		switch element.Type {
-		case "configmap":
-			// Handle configmap entry
-			master, err = handleIAGConfigMap(whsvr, element.Name, element.DataKey, ns, master)
-			if err != nil {
-				log.Error(err, "Error encountered attempting to merge a config map : "+element.Name)
-				return "", err
-			}
-		case "web":
-			// Handle web entry
-			master, err = handleWebEntryMerge(whsvr.Client, types.NamespacedName{Name: "dummy", Namespace: ns},
-				element.Url, element.Headers, master)
-			if err != nil {
-				log.Error(err, "Error encountered attempting to merge a web config : "+element.Url)
-				return "", err
-			}
-
-		case "oidc_registration":
-			// Don't handle it here. Need to make sure the oidc registration happens last
-			oidcReg = element
-			foundOidcElem = true
-		}
+		ids = append(ids, element.Id)
 	}
 
-	// OIDC registration must happen last
-	if foundOidcElem {
-
-		// Build the required struct
-		var iagOidcReg IAGOidcReg
-		iagOidcReg.DiscoveryEndpoint = oidcReg.DiscoveryEndpoint
-		iagOidcReg.Secret = oidcReg.Secret
-		iagOidcReg.PostData = oidcReg.PostData
+	// The cached web configuration sources are owned by the application
+	ctx := &sourceContext{
+		client: whsvr.Client,
+		owner:  getWebhookSourceOwner(req),
+		values: values,
+	}
 
-		// Handle the registration and merge
-		master, err = handleOidcEntryMerge(whsvr.Client, iagOidcReg, ns, master)
-		if err != nil {
-			log.Error(err, "Error encountered attempting to merge OIDC registration : "+oidcReg.Name)
-			return "", err
-		}
+	// Merge the entries using the registered configuration sources
+	master, err := mergeConfigurationSources(ctx, entries, ids, report)
+	if err != nil {
+		log.Error(err, "Error encountered attempting to merge the configuration sources")
+		return "", err
 	}
 
+	pruneWebSources(ctx.owner, entries)
+
 	// Marshal the object to a yaml byte array
-	masterYaml, err := yaml.Marshal(validateStringKeysFromString(master))
+	masterYaml, err := yaml.Marshal(master)
 	if err != nil {
 		log.Error(err, "failed to marshal the YAML master configuration")
 		return "", err
 	}
 
+	provenanceData, err := report.toJson()
+	if err != nil {
+		log.Error(err, "failed to marshal the provenance report")
+		return "", err
+	}
+
 	var retName string
 
-	// First create the new configmap
-	configMap := getNewConfigMap(getWebhookConfigMapName(req), getAppName(req), ns, string(masterYaml))
+	// First create the new configmap, or secret
+	var configMap client.Object = getNewConfigMap(getWebhookConfigMapName(req), getAppName(req), ns,
+		string(masterYaml), provenanceData)
+	if storage == configStorageSecret {
+		configMap = newConfigSecret(configMap.(*corev1.ConfigMap))
+	}
+
 	err = whsvr.Client.Create(context.TODO(), configMap)
 	if err != nil {
-		log.Error(err, "Error encountered while attempting to create the IBM Application Gateway config map")
+		log.Error(err, "Error encountered while attempting to create the IBM Application Gateway "+storage)
 		return "", err
 	}
 
-	retName = configMap.Name
+	retName = configMap.GetName()
 
 	// Then delete the old one
 	deleteConfigMap(whsvr, req, cmName)
@@ -488,33 +678,6 @@ func mergeIAGConfig(whsvr *IBMApplicationGatewayWebhook, configElements []IAGCon
 	return retName, nil
 }
 
-/*
- * Function retrieves a config map source and merges the data with the current master source.
- */
-func handleIAGConfigMap(whsvr *IBMApplicationGatewayWebhook, configMap string, dataKey string, ns string, masterConfig map[string]interface{}) (map[string]interface{}, error) {
-
-	log.V(2).Info("IBMApplicationGatewayWebhook : handleIAGConfigMap")
-
-	// Fetch the config map
-	configMapFound := &corev1.ConfigMap{}
-	err := whsvr.Client.Get(context.TODO(), types.NamespacedName{Name: configMap, Namespace: ns}, configMapFound)
-	if err != nil {
-		log.Error(err, "Could not find config map : "+configMap)
-		return nil, err
-	}
-
-	// Get the config map data pointed at by the data key
-	cmData := configMapFound.Data[dataKey]
-
-	masterConfig, err = handleYamlDataMerge(cmData, masterConfig)
-	if err != nil {
-		log.Error(err, "Failed to merge the configmap data")
-		return nil, err
-	}
-
-	return masterConfig, nil
-}
-
 /*
  * Function creates a new service to expose the IAG 8443 port
  */
@@ -588,6 +751,14 @@ func getAppName(req *admissionv1.AdmissionRequest) string {
 	return strings.ToLower(name + "-ibm-application-gateway-sidecar-pod")
 }
 
+/*
+ * Function returns the owner of the configuration sources of the application,
+ * which is used to cache the data of its web configuration sources.
+ */
+func getWebhookSourceOwner(req *admissionv1.AdmissionRequest) types.NamespacedName {
+	return types.NamespacedName{Name: getAppName(req), Namespace: req.Namespace}
+}
+
 /*
  * Function retrieves the base configmap name.
  */
@@ -654,7 +825,7 @@ func addIAGContainer(currVolumes []corev1.Volume, annots map[string]string, cont
 		return nil, fmt.Errorf("No IBM Application Gateway image has been specified.")
 	}
 
-	imagePullPolicyStr := annots["ibm-application-gateway.security.ibm.com/deployment.imagePullPolicy"]
+	imagePullPolicyStr := annots[imagePullPolicyAnnot]
 	if imagePullPolicyStr == "" {
 		imagePullPolicyStr = "IfNotPresent"
 	}
@@ -673,14 +844,8 @@ func addIAGContainer(currVolumes []corev1.Volume, annots map[string]string, cont
 	if !update || configChanged {
 		// First add the volume
 		volume := corev1.Volume{
-			Name: volumeName,
-			VolumeSource: corev1.VolumeSource{
-				ConfigMap: &corev1.ConfigMapVolumeSource{
-					LocalObjectReference: corev1.LocalObjectReference{
-						Name: cmName,
-					},
-				},
-			},
+			Name:         volumeName,
+			VolumeSource: getConfigVolumeSource(cmName, getAnnotConfigStorage(annots)),
 		}
 
 		handled := false
@@ -877,15 +1042,20 @@ func createObjects(whsvr *IBMApplicationGatewayWebhook, volumes []corev1.Volume,
 
 	var patch []patchOperation
 
+	// The class supplies the defaults for any annotations which have not been set
+	classAnnots, class, err := getClassAnnotations(whsvr.Client, annots)
+	if err != nil {
+		return nil, err
+	}
+
 	// First do some validation on the YAML annotations to try and make sure it won't fail part way through
-	errVal, configElements := validateAnnotations(annots)
+	errVal, configElements := validateAnnotations(classAnnots, class)
 	if errVal != nil {
 		return nil, errVal
 	}
 
 	var sName string
 	var cmName string
-	var err error
 
 	// Next create the IAG service if the port has been specified
 	if annots[servPort] != "" {
@@ -896,7 +1066,8 @@ func createObjects(whsvr *IBMApplicationGatewayWebhook, volumes []corev1.Volume,
 	}
 
 	// Next create the master config map
-	cmName, err = createIAGConfig(whsvr, req, configElements, false, "")
+	cmName, err = createIAGConfig(whsvr, req, configElements, false, "", isProvenanceRequested(annots),
+		getAnnotConfigStorage(classAnnots), getWebhookTemplateValues(req, classAnnots, sName), class)
 	if err != nil {
 		// Cleanup the service that was created before failure
 		deleteService(whsvr, req, sName)
@@ -904,7 +1075,7 @@ func createObjects(whsvr *IBMApplicationGatewayWebhook, volumes []corev1.Volume,
 	}
 
 	// Create the IAG container patch
-	patchOps, err := addIAGContainer(volumes, annots, containers, basePath, cmName, req, false, true)
+	patchOps, err := addIAGContainer(volumes, classAnnots, containers, basePath, cmName, req, false, true)
 	if err != nil {
 
 		// Cleanup the service and configmap that was created before failure
@@ -935,8 +1106,14 @@ func updateObjects(whsvr *IBMApplicationGatewayWebhook, volumes []corev1.Volume,
 
 	var patch []patchOperation
 
+	// The class supplies the defaults for any annotations which have not been set
+	classAnnots, class, err := getClassAnnotations(whsvr.Client, annots)
+	if err != nil {
+		return nil, err
+	}
+
 	// First do some validation on the YAML annotations to try and make sure it won't fail part way through
-	errVal, configElements := validateAnnotations(annots)
+	errVal, configElements := validateAnnotations(classAnnots, class)
 	if errVal != nil {
 		return nil, errVal
 	}
@@ -946,19 +1123,28 @@ func updateObjects(whsvr *IBMApplicationGatewayWebhook, volumes []corev1.Volume,
 	var updateService bool = false
 
 	for _, annot := range annotationChanges {
-		if strings.HasPrefix(annot, confPrefix) {
+		if strings.HasPrefix(annot, confPrefix) || annot == provenanceAnnot {
+			updateConfig = true
+		} else if annot == configStorageAnnot || annot == classAnnot {
+			// The volume must be changed to the new type of object, and a new
+			// class may change the image and the configuration sources
 			updateConfig = true
+			updateContainer = true
 		} else if strings.HasPrefix(annot, servPort) {
 			updateService = true
 		} else if strings.HasPrefix(annot, imageAnnot) || strings.HasPrefix(annot, envPrefix) {
 			// Note: in a running pod, image is the only thing that can be updated
 			updateContainer = true
+
+			// The environment is available to the configuration templates
+			if strings.HasPrefix(annot, envPrefix) && hasTemplateElement(configElements) {
+				updateConfig = true
+			}
 		}
 	}
 
 	var sName string
 	cmName := annots[cmAnnot]
-	var err error
 
 	// Update the IAG service if required
 	if updateService {
@@ -970,7 +1156,13 @@ func updateObjects(whsvr *IBMApplicationGatewayWebhook, volumes []corev1.Volume,
 
 	// Next create the master config map
 	if updateConfig {
-		cmName, err = createIAGConfig(whsvr, req, configElements, true, cmName)
+		serviceName := annots[servAnnot]
+		if updateService {
+			serviceName = sName
+		}
+
+		cmName, err = createIAGConfig(whsvr, req, configElements, true, cmName, isProvenanceRequested(annots),
+			getAnnotConfigStorage(classAnnots), getWebhookTemplateValues(req, classAnnots, serviceName), class)
 		if err != nil {
 			return nil, err
 		}
@@ -978,7 +1170,7 @@ func updateObjects(whsvr *IBMApplicationGatewayWebhook, volumes []corev1.Volume,
 
 	// First create the IAG container patch
 	if updateContainer {
-		patchOps, err := addIAGContainer(volumes, annots, containers, basePath, cmName, req, true, updateConfig)
+		patchOps, err := addIAGContainer(volumes, classAnnots, containers, basePath, cmName, req, true, updateConfig)
 		if err != nil {
 			return nil, err
 		}
@@ -1032,7 +1224,9 @@ func deleteService(whsvr *IBMApplicationGatewayWebhook, req *admissionv1.Admissi
 }
 
 /*
- * Function deletes the IAG configmap.
+ * Function deletes the IAG configmap, or secret.  A secret is only deleted if
+ * it was generated for the target resource, as the configuration storage may
+ * have changed since it was created.
  */
 func deleteConfigMap(whsvr *IBMApplicationGatewayWebhook, req *admissionv1.AdmissionRequest, configMapName string) error {
 
@@ -1058,6 +1252,26 @@ func deleteConfigMap(whsvr *IBMApplicationGatewayWebhook, req *admissionv1.Admis
 		}
 	}
 
+	foundSecret := &corev1.Secret{}
+	err = whsvr.Client.Get(context.TODO(), types.NamespacedName{Name: configMapName, Namespace: req.Namespace}, foundSecret)
+	if err == nil {
+		if foundSecret.Labels["app"] == getAppName(req) {
+			err = whsvr.Client.Delete(context.TODO(), foundSecret)
+			if err != nil {
+				log.Error(err, "failed to delete the secret")
+				return err
+			}
+		}
+	} else {
+		if errors.IsNotFound(err) {
+			log.V(2).Info("Secret did not exist")
+			// No op. Does not exist so ignore
+		} else {
+			log.Error(err, "Encountered an error while attempting to delete the secret")
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -1409,6 +1623,8 @@ func (whsvr *IBMApplicationGatewayWebhook) mutateDeleteCommon(req *admissionv1.A
 	deleteService(whsvr, req, sName)
 	deleteConfigMap(whsvr, req, cmName)
 
+	forgetWebSources(getWebhookSourceOwner(req))
+
 	return &admissionv1.AdmissionResponse{
 		Allowed: true,
 	}
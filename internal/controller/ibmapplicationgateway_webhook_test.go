@@ -0,0 +1,203 @@
+/*
+ * Copyright contributors to the IBM Application Gateway Operator project
+ */
+
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestGetConfigElementsLiteral(t *testing.T) {
+	multiLine := "resource_servers:\n  - path: /app\n    connection_type: tcp\n"
+
+	annots := map[string]string{
+		confPrefix + "base.type":           "configmap",
+		confPrefix + "base.order":          "1",
+		confPrefix + "base.name":           "iag-config",
+		confPrefix + "base.dataKey":        "config",
+		confPrefix + "version.type":        "literal",
+		confPrefix + "version.order":       "2",
+		confPrefix + "version.value":       "version: \"23.04\"",
+		confPrefix + "servers.type":        "literal",
+		confPrefix + "servers.order":       "0",
+		confPrefix + "servers.valueBase64": base64.StdEncoding.EncodeToString([]byte(multiLine)),
+	}
+
+	elements, err := getConfigElements(annots)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The elements are returned in merge order
+	var ids []string
+	for _, element := range elements {
+		ids = append(ids, element.Id)
+	}
+	if !reflect.DeepEqual(ids, []string{"servers", "base", "version"}) {
+		t.Fatalf("Unexpected merge order : %v", ids)
+	}
+	if elements[0].Value != multiLine {
+		t.Errorf("Unexpected decoded value : %q", elements[0].Value)
+	}
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "iag-config", Namespace: "default"},
+		Data:       map[string]string{"config": "version: \"22.07\"\nresource_servers:\n  - path: /other\n"},
+	}
+
+	ctx := &sourceContext{
+		client: fake.NewClientBuilder().WithObjects(configMap).Build(),
+		owner:  types.NamespacedName{Name: "dummy", Namespace: "default"},
+	}
+
+	master, err := mergeConfigurationSources(ctx, getConfigurationEntries(elements), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"version": "23.04",
+		"resource_servers": []interface{}{
+			map[string]interface{}{"path": "/app", "connection_type": "tcp"},
+			map[string]interface{}{"path": "/other"},
+		},
+	}
+
+	if !reflect.DeepEqual(master, expected) {
+		t.Errorf("Expected %v but got %v", expected, master)
+	}
+}
+
+func TestGetConfigElementsInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		annots map[string]string
+	}{
+		{
+			name: "an invalid literal",
+			annots: map[string]string{
+				confPrefix + "lit.type":  "literal",
+				confPrefix + "lit.order": "1",
+				confPrefix + "lit.value": "version: [",
+			},
+		},
+		{
+			name: "an invalid base64 literal",
+			annots: map[string]string{
+				confPrefix + "lit.type":        "literal",
+				confPrefix + "lit.order":       "1",
+				confPrefix + "lit.valueBase64": "not base64!",
+			},
+		},
+		{
+			name: "both a value and a base64 value",
+			annots: map[string]string{
+				confPrefix + "lit.type":        "literal",
+				confPrefix + "lit.order":       "1",
+				confPrefix + "lit.value":       "version: \"23.04\"",
+				confPrefix + "lit.valueBase64": base64.StdEncoding.EncodeToString([]byte("version: \"23.04\"")),
+			},
+		},
+		{
+			name: "an unknown type",
+			annots: map[string]string{
+				confPrefix + "other.type":  "unknown",
+				confPrefix + "other.order": "1",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		if _, err := getConfigElements(test.annots); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
+
+func TestMutateCreateDeploymentWithClassSources(t *testing.T) {
+	depl := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "app",
+			Namespace:   "default",
+			Annotations: map[string]string{classAnnot: "standard"},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "app", Image: "app:1.0"}},
+				},
+			},
+		},
+	}
+
+	raw, err := json.Marshal(depl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := &admissionv1.AdmissionRequest{
+		Name:      depl.Name,
+		Namespace: depl.Namespace,
+		Kind:      metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+	}
+
+	tests := []struct {
+		name      string
+		class     bool
+		expectErr bool
+	}{
+		{name: "all of the configuration sources are supplied by the class", class: true},
+		{name: "there are no configuration sources", expectErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			class := newTestGatewayClass("standard", false)
+			if !test.class {
+				class.Spec.Configuration = nil
+			}
+
+			whsvr := &IBMApplicationGatewayWebhook{Client: newTestClient(t, class)}
+
+			resp := whsvr.mutateCreateDeployment(req)
+
+			if test.expectErr {
+				if resp.Allowed {
+					t.Errorf("Expected the deployment to be rejected")
+				}
+				return
+			}
+
+			if !resp.Allowed || len(resp.Patch) == 0 {
+				t.Fatalf("Expected the deployment to be mutated but got %+v", resp.Result)
+			}
+
+			configMaps := &corev1.ConfigMapList{}
+			if err := whsvr.Client.List(context.TODO(), configMaps); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(configMaps.Items) != 1 ||
+				configMaps.Items[0].Data[configMapMasterKey] != "version: \"24.12\"\n" {
+				t.Errorf("Expected the configuration of the class but got %v", configMaps.Items)
+			}
+		})
+	}
+}
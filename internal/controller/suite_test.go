@@ -10,9 +10,11 @@ import (
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
@@ -63,3 +65,33 @@ var _ = AfterSuite(func() {
 	err := testEnv.Stop()
 	Expect(err).NotTo(HaveOccurred())
 })
+
+/*
+ * Function returns a scheme which contains the Kubernetes types and the types
+ * of the operator.
+ */
+func newTestScheme(t *testing.T) *runtime.Scheme {
+	t.Helper()
+
+	testScheme := runtime.NewScheme()
+	if err := scheme.AddToScheme(testScheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := ibmv1.AddToScheme(testScheme); err != nil {
+		t.Fatal(err)
+	}
+
+	return testScheme
+}
+
+/*
+ * Function returns a fake client, using the test scheme, which contains the
+ * passed in objects.  The status of a custom resource is only saved through
+ * the status subresource, as it is by the API server.
+ */
+func newTestClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+
+	return fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(objs...).
+		WithStatusSubresource(&ibmv1.IBMApplicationGateway{}).Build()
+}